@@ -22,7 +22,10 @@ func Chat(rw http.ResponseWriter, req *http.Request) {
 		msg = "用10个字介绍你自己"
 	}
 	bot := chat.GetChatBot(botType)
-	rpn := bot.Chat("admin", msg)
+	// 接口直接返回回复，迟到的回复和保存历史等后台任务在返回前完成
+	ctx, waitAsync := chat.WithAsyncTasks(req.Context(), nil)
+	defer waitAsync()
+	rpn := bot.Chat(ctx, "admin", msg)
 	s, err := simplifiedchinese.GBK.NewEncoder().String(rpn)
 	if err != nil {
 		fmt.Fprint(rw, err.Error())
@@ -4,11 +4,12 @@ import (
 	"fmt"
 	"net/http"
 
-	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/chat"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
 )
 
 func Check(rw http.ResponseWriter, req *http.Request) {
-	botType, checkRes := config.CheckAllBotConfig()
+	botType, checkRes := chat.CheckAllBotConfig()
 	var res string
 	for bot, status := range checkRes {
 		if res == "" {
@@ -18,5 +19,11 @@ func Check(rw http.ResponseWriter, req *http.Request) {
 		}
 	}
 	res = fmt.Sprintf("%v\nDEFAULT BOT: %v", res, botType)
+	res = fmt.Sprintf("%v\nSTORE: %v", res, db.StoreStatus())
+	// 机器人的熔断状态和故障转移链
+	res = fmt.Sprintf("%v\nHEALTH:", res)
+	for _, h := range chat.GetProviderHealth() {
+		res = fmt.Sprintf("%v\n%v: %v", res, h.BotType, h)
+	}
 	fmt.Fprint(rw, res)
 }
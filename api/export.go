@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/chat"
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+)
+
+// Export 下载 /export 生成的对话记录，参数：token=导出链接中的token
+func Export(rw http.ResponseWriter, req *http.Request) {
+	claims, err := chat.ParseExportToken(req.URL.Query().Get("token"), config.GetExportSecret())
+	if err != nil {
+		rw.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(rw, err.Error())
+		return
+	}
+	text, err := chat.RenderHistory(claims.BotType, claims.UserId, claims.SessionId, claims.Format)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, chat.ErrHistoryEmpty) {
+			status = http.StatusNotFound
+		}
+		rw.WriteHeader(status)
+		fmt.Fprint(rw, err.Error())
+		return
+	}
+	contentType := "text/plain; charset=utf-8"
+	if claims.Format == chat.ExportFormatMarkdown {
+		contentType = "text/markdown; charset=utf-8"
+	}
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"chat-%s.%s\"", time.Now().Format("20060102150405"), claims.Format))
+	fmt.Fprint(rw, text)
+}
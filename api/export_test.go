@@ -0,0 +1,16 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExportRejectsInvalidToken(t *testing.T) {
+	t.Setenv("EXPORT_SECRET", "secret")
+	rec := httptest.NewRecorder()
+	Export(rec, httptest.NewRequest(http.MethodGet, "/api/export?token=bad.token", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 with invalid token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/pwh-pwh/aiwechat-vercel/chat"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+// History 导出或导入用户的全部对话记录，用于在部署之间迁移
+// GET 参数：code=accessCode&user=openid，以json返回
+// POST 参数：code=accessCode&user=openid(选填，默认为json中的用户)，请求体为 GET 返回的json
+func History(rw http.ResponseWriter, req *http.Request) {
+	accessCode := os.Getenv("accessCode")
+	code := req.URL.Query().Get("code")
+	// 对话记录包含用户隐私，必须配置 accessCode 才能访问
+	if accessCode == "" || code != accessCode {
+		rw.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(rw, "No valid query code provided.")
+		return
+	}
+	if db.StoreInstance == nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(rw, db.ErrStoreNil.Error())
+		return
+	}
+	userId := req.URL.Query().Get("user")
+
+	switch req.Method {
+	case http.MethodGet:
+		if userId == "" {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(rw, "missing user")
+			return
+		}
+		data, err := chat.ExportUserHistory(userId)
+		if err != nil {
+			rw.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(rw, err.Error())
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(rw).Encode(data)
+	case http.MethodPost:
+		var data chat.HistoryExport
+		if err := json.NewDecoder(req.Body).Decode(&data); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(rw, "invalid json: %v", err)
+			return
+		}
+		histories, err := chat.ImportUserHistory(userId, &data)
+		if err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(rw, err.Error())
+			return
+		}
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(rw).Encode(map[string]int{"sessions": len(data.Sessions), "histories": histories})
+	default:
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
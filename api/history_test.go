@@ -0,0 +1,24 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHistoryRequiresAccessCode(t *testing.T) {
+	t.Setenv("accessCode", "")
+	rec := httptest.NewRecorder()
+	History(rec, httptest.NewRequest(http.MethodGet, "/api/history?user=u", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without accessCode, got %d", rec.Code)
+	}
+
+	t.Setenv("accessCode", "secret")
+	rec = httptest.NewRecorder()
+	History(rec, httptest.NewRequest(http.MethodPost, "/api/history?code=wrong", strings.NewReader("{}")))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 with wrong code, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/pwh-pwh/aiwechat-vercel/chat"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+// Usage 以json返回用量报表，参数：code=accessCode&days=7&user=openid
+func Usage(rw http.ResponseWriter, req *http.Request) {
+	accessCode := os.Getenv("accessCode")
+	code := req.URL.Query().Get("code")
+	// 用量数据包含用户信息，必须配置 accessCode 才能访问
+	if accessCode == "" || code != accessCode {
+		rw.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(rw, "No valid query code provided.")
+		return
+	}
+	if db.StoreInstance == nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(rw, db.ErrStoreNil.Error())
+		return
+	}
+
+	days := 1
+	if s := req.URL.Query().Get("days"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(rw, "invalid days")
+			return
+		}
+		days = n
+	}
+	report, err := chat.GetUsageReport(days, req.URL.Query().Get("user"))
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(rw, err.Error())
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(rw).Encode(report)
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUsageRequiresAccessCode(t *testing.T) {
+	t.Setenv("accessCode", "")
+	rec := httptest.NewRecorder()
+	Usage(rec, httptest.NewRequest(http.MethodGet, "/api/usage", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without accessCode, got %d", rec.Code)
+	}
+
+	t.Setenv("accessCode", "secret")
+	rec = httptest.NewRecorder()
+	Usage(rec, httptest.NewRequest(http.MethodGet, "/api/usage?code=wrong", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 with wrong code, got %d", rec.Code)
+	}
+}
@@ -3,6 +3,7 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -22,25 +23,45 @@ func Wx(rw http.ResponseWriter, req *http.Request) {
 	wc := wechat.NewWechat()
 	memory := cache.NewMemory()
 	cfg := &offConfig.Config{
-		AppID:     config.GetWxAppId(),
-		AppSecret: config.GetWxAppSecret(),
-		Token:     config.GetWxToken(),
-		Cache:     memory,
+		AppID:          config.GetWxAppId(),
+		AppSecret:      config.GetWxAppSecret(),
+		Token:          config.GetWxToken(),
+		EncodingAESKey: config.GetWxEncodingAESKey(),
+		Cache:          memory,
 	}
 	officialAccount := wc.GetOfficialAccount(cfg)
 
-	// 传入request和responseWriter
+	// 对AI接口的调用最多持续 WX_REQUEST_TIMEOUT，包括超时后的异步回复
+	ctx, cancel := context.WithTimeout(req.Context(), config.GetWxRequestTimeout())
+	defer cancel()
+
+	// 传入request和responseWriter，签名校验由 server 完成
 	server := officialAccount.GetServer(req, rw)
-	server.SkipValidate(true)
+
+	// 配置了 AppID 和 AppSecret 时，超时的回复改为通过客服消息异步推送
+	var sender chat.ReplySender
+	if config.IsWxCustomMsgEnabled() {
+		sender = chat.NewWxCustomSender(officialAccount.GetAccessToken)
+	}
+	// 本次请求的异步回复推送及保存历史等后台任务，回复发出后等待其完成，避免函数实例提前被回收
+	ctx, waitAsync := chat.WithAsyncTasks(ctx, sender)
+	defer waitAsync()
 
 	// 设置接收消息的处理方法
 	server.SetMessageHandler(func(msg *message.MixMessage) *message.Reply {
-		// 回复消息：由 handleWxMessage 生成最终文本
-		replyMsg := handleWxMessage(msg, officialAccount)
+		// 回复消息：由 handleWxMessage 生成最终文本，微信的重试请求复用同一次处理结果
+		replyMsg := chat.HandleOnce(chat.DedupKey(msg), func() string {
+			return chat.ShapeReply(ctx, string(msg.FromUserName), handleWxMessage(ctx, msg, officialAccount))
+		})
 
 		// debug: 打印即将回复的纯文本长度，便于检查是否过长
 		fmt.Printf("Will reply to user %s, reply length=%d\n", string(msg.FromUserName), len(replyMsg))
 
+		// 回复为空说明已转为异步回复，直接回复 success 给微信服务器
+		if replyMsg == "" {
+			return nil
+		}
+
 		// 构造文本回复
 		text := message.NewText(replyMsg)
 		return &message.Reply{MsgType: message.MsgTypeText, MsgData: text}
@@ -53,11 +74,24 @@ func Wx(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	// 发送回复
-	if err := server.Send(); err != nil {
+	if server.ResponseMsg == nil {
+		// 安全模式下处理方法返回 nil 时 Serve 不会回复，需手动回复 success，避免加密空消息
+		if isSafeMode(req) {
+			server.String("success")
+		}
+	} else if err := server.Send(); err != nil {
 		// Send 出错也打印出来，便于排查
 		fmt.Println("server.Send error:", err)
 	}
 
+	// 先把被动回复刷给微信，再推送剩余分段
+	if f, ok := rw.(http.Flusher); ok {
+		f.Flush()
+	}
+	if server.RequestMsg != nil {
+		chat.SendOverflowReplies(ctx, string(server.RequestMsg.FromUserName))
+	}
+
 	// —— 调试用：输出最终发送给微信的完整 XML —— //
 	// silenceper/wechat 的 Server 结构会把最终的 raw xml 放到 ResponseRawXMLMsg 字段
 	if len(server.ResponseRawXMLMsg) > 0 {
@@ -70,41 +104,47 @@ func Wx(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// isSafeMode 安全模式和兼容模式下微信会带上 encrypt_type=aes
+func isSafeMode(req *http.Request) bool {
+	return req.URL.Query().Get("encrypt_type") == "aes"
+}
+
 // handleWxMessage 保持你原先的逻辑
-func handleWxMessage(msg *message.MixMessage, oa *officialaccount.OfficialAccount) (replyMsg string) {
+func handleWxMessage(ctx context.Context, msg *message.MixMessage, oa *officialaccount.OfficialAccount) (replyMsg string) {
 	msgType := msg.MsgType
 	msgContent := msg.Content
 	userId := string(msg.FromUserName)
 
-	// Check if user is authenticated (only if ADDME_PASSWORD is set)
-	if config.GetAddMePassword() != "" && !config.IsUserAuthenticated(userId) {
+	// 访客(开启 ADDME_PASSWORD 或 INVITE_ONLY 后未认证、角色过期或被撤销角色的用户)只能执行允许访客的命令，如 /addme
+	if !chat.HasRole(userId, chat.RoleMember) {
 		if msgType == message.MsgTypeText {
-			// Only allow /addme command for non-authenticated users
-			if msgContent == "/addme" || len(msgContent) > len("/addme") && msgContent[:len("/addme")] == "/addme" {
-				bot := chat.GetChatBot(config.GetUserBotType(userId))
-				replyMsg = bot.Chat(userId, msgContent)
-			} else {
-				replyMsg = "功能还在开发中"
+			if actionReply, isAction := chat.DoAction(ctx, userId, msgContent); isAction {
+				return actionReply
 			}
-		} else {
-			replyMsg = "功能还在开发中"
 		}
-		return
+		return "功能还在开发中"
 	}
 
 	// 首先，检查并处理所有命令
 	if msgType == message.MsgTypeText {
-		if actionReply, isAction := chat.DoAction(userId, msgContent); isAction {
+		if chat.IsContinueMsg(userId, msgContent) {
+			return chat.MoreReply("", userId)
+		}
+		if actionReply, isAction := chat.DoAction(ctx, userId, msgContent); isAction {
 			return actionReply
 		}
 	}
 
 	// 如果不是命令，再根据用户当前选择的模式处理
-	bot := chat.GetChatBot(config.GetUserBotType(userId))
+	botType := config.GetUserBotType(userId)
+	bot := chat.GetChatBot(botType)
 
 	switch msgType {
 	case message.MsgTypeText:
-		replyMsg = bot.Chat(userId, msgContent)
+		if quotaReply, ok := chat.CheckQuota(userId, botType); !ok {
+			return quotaReply
+		}
+		replyMsg = bot.Chat(ctx, userId, msgContent)
 	case message.MsgTypeImage:
 		// 检查当前机器人是否支持图片输入
 		if _, ok := bot.(*chat.KeywordChat); ok {
@@ -114,30 +154,29 @@ func handleWxMessage(msg *message.MixMessage, oa *officialaccount.OfficialAccoun
 		}
 
 		// 检查当前的 bot 是否是支持多模态的AI模型
-		if botType := config.GetUserBotType(userId); botType != config.Bot_Type_Gemini {
+		if !chat.GetCapabilities(botType).Image {
 			// 当前AI模式不支持多模态，返回提示
-			replyMsg = fmt.Sprintf("您当前的 %s 机器人只支持文本输入。如需图片解读，请使用 /gemini 切换到 Gemini 机器人。", botType)
+			replyMsg = fmt.Sprintf("您当前的 %s 机器人只支持文本输入。如需图片解读，请使用 %s 切换到支持图片的机器人。", botType,
+				strings.Join(chat.CommandsWith(func(c chat.Capabilities) bool { return c.Image }), " 或 "))
 			return
 		}
-
-		// 如果当前是 Gemini 模式，则进行图片解读
-		// 这里需要将bot断言为GeminiChat类型，因为GeminiChat.Chat方法签名与其他ChatBot不同
-		if geminiBot, ok := bot.(*chat.GeminiChat); ok {
-			geminiReply := geminiBot.Chat(userId, "", msg.PicURL)
-			replyBuilder := strings.Builder{}
-			replyBuilder.WriteString("Gemini 图片解读：\n")
-			replyBuilder.WriteString(geminiReply)
-			replyMsg = replyBuilder.String()
-		} else {
-			replyMsg = "无法处理图片，请确保已切换到Gemini机器人。"
+		if quotaReply, ok := chat.CheckQuota(userId, botType); !ok {
+			return quotaReply
 		}
+
+		// 支持图片的机器人通过 imageURL 参数接收图片
+		replyMsg = withMediaTitle(botType, "图片解读", bot.Chat(ctx, userId, "", msg.PicURL))
 	case message.MsgTypeVoice:
 		// NOTE: 新增代码，用于处理语音消息
-		botType := config.GetUserBotType(userId)
-		if botType != config.Bot_Type_Gemini {
-			replyMsg = fmt.Sprintf("您当前的 %s 机器人只支持文本输入。如需语音解读，请使用 /gemini 切换到 Gemini 机器人。", botType)
+		voiceBot, ok := bot.(chat.VoiceChat)
+		if !chat.GetCapabilities(botType).Voice || !ok {
+			replyMsg = fmt.Sprintf("您当前的 %s 机器人只支持文本输入。如需语音解读，请使用 %s 切换到支持语音的机器人。", botType,
+				strings.Join(chat.CommandsWith(func(c chat.Capabilities) bool { return c.Voice }), " 或 "))
 			return
 		}
+		if quotaReply, ok := chat.CheckQuota(userId, botType); !ok {
+			return quotaReply
+		}
 
 		// 获取 access token
 		accessToken, err := oa.GetAccessToken()
@@ -153,17 +192,7 @@ func handleWxMessage(msg *message.MixMessage, oa *officialaccount.OfficialAccoun
 			return
 		}
 
-		// 调用 Gemini 模型进行语音解读
-		// 这里需要将bot断言为GeminiChat类型
-		if geminiBot, ok := bot.(*chat.GeminiChat); ok {
-			geminiReply := geminiBot.ChatWithVoice(userId, voiceData)
-			replyBuilder := strings.Builder{}
-			replyBuilder.WriteString("Gemini 语音解读：\n")
-			replyBuilder.WriteString(geminiReply)
-			replyMsg = replyBuilder.String()
-		} else {
-			replyMsg = "无法处理语音，请确保已切换到Gemini机器人。"
-		}
+		replyMsg = withMediaTitle(botType, "语音解读", voiceBot.ChatWithVoice(ctx, userId, voiceData))
 	default:
 		replyMsg = bot.HandleMediaMsg(msg)
 	}
@@ -171,9 +200,20 @@ func handleWxMessage(msg *message.MixMessage, oa *officialaccount.OfficialAccoun
 	return
 }
 
+// withMediaTitle 为多媒体解读结果加上标题，回复为空说明已转为异步回复，不加标题
+func withMediaTitle(botType, title, reply string) string {
+	if reply == "" {
+		return ""
+	}
+	if p, ok := chat.GetProvider(botType); ok {
+		title = p.Title + " " + title
+	}
+	return title + "：\n" + reply
+}
+
 // NOTE: 新增函数，用于从微信服务器下载临时素材
 func downloadWxMedia(accessToken, mediaID string) ([]byte, error) {
-	url := fmt.Sprintf("https://api.weixin.qq.com/cgi-bin/media/get?access_token=%s&media_id=%s", accessToken, mediaID)
+	url := fmt.Sprintf("%s/cgi-bin/media/get?access_token=%s&media_id=%s", config.GetWxApiBaseUrl(), accessToken, mediaID)
 	resp, err := http.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("下载微信媒体文件失败: %w", err)
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/silenceper/wechat/v2/officialaccount/message"
+	"github.com/silenceper/wechat/v2/util"
+)
+
+const (
+	testToken  = "testtoken"
+	testAppId  = "wx1234567890abcdef"
+	testAESKey = "abcdefghijklmnopqrstuvwxyz0123456789ABCDEFG"
+)
+
+func textMsgXML(msgId int64, content string) string {
+	return fmt.Sprintf(`<xml><ToUserName><![CDATA[gh_test]]></ToUserName><FromUserName><![CDATA[user1]]></FromUserName><CreateTime>1700000000</CreateTime><MsgType><![CDATA[text]]></MsgType><Content><![CDATA[%s]]></Content><MsgId>%d</MsgId></xml>`, content, msgId)
+}
+
+func setupWxEnv(t *testing.T, aesKey string) {
+	t.Setenv("WX_TOKEN", testToken)
+	t.Setenv("WX_APP_ID", testAppId)
+	t.Setenv("WX_APP_SECRET", "")
+	t.Setenv("WX_ENCODING_AES_KEY", aesKey)
+	t.Setenv("botType", "echo")
+	t.Setenv("ADDME_PASSWORD", "")
+}
+
+func TestWxPlainSignature(t *testing.T) {
+	setupWxEnv(t, "")
+	timestamp, nonce := "1700000000", "nonce"
+
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/wx?signature=%s&timestamp=%s&nonce=%s",
+		util.Signature(testToken, timestamp, nonce), timestamp, nonce), strings.NewReader(textMsgXML(1001, "hello")))
+	rw := httptest.NewRecorder()
+	Wx(rw, req)
+
+	var reply message.Text
+	if err := xml.Unmarshal(rw.Body.Bytes(), &reply); err != nil {
+		t.Fatalf("unmarshal reply failed: %v, body=%s", err, rw.Body.String())
+	}
+	if reply.Content != "hello" {
+		t.Errorf("unexpected reply %q", reply.Content)
+	}
+
+	// 签名错误的请求不应被处理
+	req = httptest.NewRequest("POST", fmt.Sprintf("/api/wx?signature=bad&timestamp=%s&nonce=%s", timestamp, nonce),
+		strings.NewReader(textMsgXML(1002, "hello")))
+	rw = httptest.NewRecorder()
+	Wx(rw, req)
+	if rw.Body.Len() != 0 {
+		t.Errorf("expected no reply for invalid signature, got %s", rw.Body.String())
+	}
+}
+
+func TestWxEncryptedRoundTrip(t *testing.T) {
+	setupWxEnv(t, testAESKey)
+	timestamp, nonce := "1700000000", "nonce"
+
+	encrypted, err := util.EncryptMsg([]byte("0123456789abcdef"), []byte(textMsgXML(2001, "hello aes")), testAppId, testAESKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := fmt.Sprintf(`<xml><ToUserName><![CDATA[gh_test]]></ToUserName><Encrypt><![CDATA[%s]]></Encrypt></xml>`, encrypted)
+	msgSignature := util.Signature(testToken, timestamp, nonce, string(encrypted))
+	req := httptest.NewRequest("POST", fmt.Sprintf("/api/wx?signature=%s&timestamp=%s&nonce=%s&encrypt_type=aes&msg_signature=%s",
+		util.Signature(testToken, timestamp, nonce), timestamp, nonce, msgSignature), strings.NewReader(body))
+	rw := httptest.NewRecorder()
+	Wx(rw, req)
+
+	var encReply struct {
+		Encrypt      string `xml:"Encrypt"`
+		MsgSignature string `xml:"MsgSignature"`
+		TimeStamp    string `xml:"TimeStamp"`
+		Nonce        string `xml:"Nonce"`
+	}
+	if err = xml.Unmarshal(rw.Body.Bytes(), &encReply); err != nil {
+		t.Fatalf("unmarshal encrypted reply failed: %v, body=%s", err, rw.Body.String())
+	}
+	if encReply.MsgSignature != util.Signature(testToken, encReply.TimeStamp, encReply.Nonce, encReply.Encrypt) {
+		t.Error("reply msg_signature mismatch")
+	}
+	_, raw, err := util.DecryptMsg(testAppId, encReply.Encrypt, testAESKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply message.Text
+	if err = xml.Unmarshal(raw, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "hello aes" || reply.ToUserName != "user1" {
+		t.Errorf("unexpected decrypted reply: %+v", reply)
+	}
+
+	// msg_signature 错误时拒绝处理
+	req = httptest.NewRequest("POST", fmt.Sprintf("/api/wx?signature=%s&timestamp=%s&nonce=%s&encrypt_type=aes&msg_signature=bad",
+		util.Signature(testToken, timestamp, nonce), timestamp, nonce), strings.NewReader(body))
+	rw = httptest.NewRecorder()
+	Wx(rw, req)
+	if rw.Body.Len() != 0 {
+		t.Errorf("expected no reply for invalid msg_signature, got %s", rw.Body.String())
+	}
+}
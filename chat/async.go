@@ -0,0 +1,171 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+)
+
+// ReplySender 用于在被动回复超时后，通过客服消息接口主动推送回复
+type ReplySender interface {
+	SendText(toUser, content string) error
+}
+
+// asyncTasks 一次请求中尚未完成的异步回复推送及保存历史等后台任务，请求结束前需等待其完成
+type asyncTasks struct {
+	wg     sync.WaitGroup
+	sender ReplySender
+
+	mu       sync.Mutex
+	overflow map[string][]string // 被动回复之外的剩余分段，userId -> 分段
+}
+
+type asyncTasksKey struct{}
+
+// WithAsyncTasks 返回记录本次请求后台任务的 ctx，迟到的回复和超长回复的剩余分段通过 sender 推送，
+// sender 为 nil 时回退为缓存模式。返回的 wait 只等待本次请求的后台任务，需在回复发出后调用
+func WithAsyncTasks(ctx context.Context, sender ReplySender) (context.Context, func()) {
+	tasks := &asyncTasks{sender: sender}
+	return context.WithValue(ctx, asyncTasksKey{}, tasks), tasks.wg.Wait
+}
+
+func asyncTasksOf(ctx context.Context) *asyncTasks {
+	tasks, _ := ctx.Value(asyncTasksKey{}).(*asyncTasks)
+	return tasks
+}
+
+// replySenderOf 本次请求使用的发送者，未设置时返回 nil
+func replySenderOf(ctx context.Context) ReplySender {
+	if tasks := asyncTasksOf(ctx); tasks != nil {
+		return tasks.sender
+	}
+	return nil
+}
+
+// goAsync 在后台执行 f 并计入本次请求的后台任务，ctx 没有记录后台任务时直接在后台执行
+func goAsync(ctx context.Context, f func()) {
+	tasks := asyncTasksOf(ctx)
+	if tasks == nil {
+		go f()
+		return
+	}
+	tasks.wg.Add(1)
+	go func() {
+		defer tasks.wg.Done()
+		f()
+	}()
+}
+
+// WxCustomSender 通过微信公众号客服消息接口发送文本消息
+// 文档：https://developers.weixin.qq.com/doc/offiaccount/Message_Management/Service_Center_messages.html
+type WxCustomSender struct {
+	BaseUrl        string
+	GetAccessToken func() (string, error)
+	Client         *http.Client
+}
+
+type wxCustomTextMessage struct {
+	ToUser  string `json:"touser"`
+	MsgType string `json:"msgtype"`
+	Text    struct {
+		Content string `json:"content"`
+	} `json:"text"`
+}
+
+type wxCommonError struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+func NewWxCustomSender(getAccessToken func() (string, error)) *WxCustomSender {
+	return &WxCustomSender{
+		BaseUrl:        config.GetWxApiBaseUrl(),
+		GetAccessToken: getAccessToken,
+		Client:         &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WxCustomSender) SendText(toUser, content string) error {
+	if s.GetAccessToken == nil {
+		return errors.New("未设置获取access token的方法")
+	}
+	accessToken, err := s.GetAccessToken()
+	if err != nil {
+		return fmt.Errorf("获取微信 access token 失败: %w", err)
+	}
+
+	msg := wxCustomTextMessage{ToUser: toUser, MsgType: "text"}
+	msg.Text.Content = content
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/cgi-bin/message/custom/send?access_token=%s", s.BaseUrl, accessToken)
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("发送客服消息失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	rpnBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取客服消息响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("发送客服消息失败，状态码: %d", resp.StatusCode)
+	}
+	var wxErr wxCommonError
+	if err = json.Unmarshal(rpnBody, &wxErr); err != nil {
+		return fmt.Errorf("解析客服消息响应失败: %w", err)
+	}
+	if wxErr.ErrCode != 0 {
+		return fmt.Errorf("发送客服消息失败，errcode=%d, errmsg=%s", wxErr.ErrCode, wxErr.ErrMsg)
+	}
+	return nil
+}
+
+// deliverAsync 等待迟到的回复并推送给用户，未配置发送者时回退为缓存，等待用户重发相同消息时取回。
+// 已经被动回复了流式输出的一部分时只送达剩余部分，未配置发送者时追加到 /more 的剩余内容中
+func deliverAsync(ctx context.Context, userId, msg string, resChan <-chan string, stream *ReplyStream) {
+	sender := replySenderOf(ctx)
+	goAsync(ctx, func() {
+		res := <-resChan
+		partial := stream.partial()
+		if partial {
+			if res = stream.remainder(res); res == "" {
+				return
+			}
+		}
+		chunks := SplitReply(res, config.GetWxReplyMaxBytes())
+		switch {
+		case sender == nil && partial:
+			appendMoreReply(userId, chunks)
+		case sender == nil:
+			config.Cache.Store(userId+msg, res)
+		default:
+			// 推送失败的部分会保存起来，用户发送 /more 即可获取
+			sendChunks(sender, userId, chunks)
+		}
+	})
+}
+
+// lateReplyHint 告诉用户稍后如何获取迟到的回复
+func lateReplyHint(ctx context.Context) string {
+	if replySenderOf(ctx) == nil {
+		return "稍后回复“继续”或 /more 查看"
+	}
+	return "稍后推送"
+}
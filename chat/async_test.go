@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSender struct {
+	mu   sync.Mutex
+	sent map[string]string
+}
+
+func (f *fakeSender) SendText(toUser, content string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.sent == nil {
+		f.sent = map[string]string{}
+	}
+	f.sent[toUser] = content
+	return nil
+}
+
+func TestWxCustomSender(t *testing.T) {
+	var got wxCustomTextMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cgi-bin/message/custom/send" || r.URL.Query().Get("access_token") != "token" {
+			w.Write([]byte(`{"errcode":40001,"errmsg":"invalid credential"}`))
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer srv.Close()
+
+	sender := &WxCustomSender{
+		BaseUrl:        srv.URL,
+		GetAccessToken: func() (string, error) { return "token", nil },
+	}
+	if err := sender.SendText("user1", "你好"); err != nil {
+		t.Fatal(err)
+	}
+	if got.ToUser != "user1" || got.MsgType != "text" || got.Text.Content != "你好" {
+		t.Errorf("unexpected message: %+v", got)
+	}
+
+	sender.GetAccessToken = func() (string, error) { return "bad", nil }
+	if err := sender.SendText("user1", "你好"); err == nil {
+		t.Error("expected errcode error")
+	}
+}
+
+func TestWithTimeChatAsync(t *testing.T) {
+	sender := &fakeSender{}
+	ctx, wait := WithAsyncTasks(context.Background(), sender)
+	timeout := ChatTimeout
+	ChatTimeout = 10 * time.Millisecond
+	defer func() { ChatTimeout = timeout }()
+
+	res := WithTimeChat(ctx, "user1", "hi", func(ctx context.Context, userId, msg string, stream *ReplyStream) string {
+		time.Sleep(50 * time.Millisecond)
+		return "late answer"
+	})
+	if res != "" {
+		t.Fatalf("expected empty passive reply, got %q", res)
+	}
+	wait()
+	if sender.sent["user1"] != "late answer" {
+		t.Errorf("expected late answer to be pushed, got %q", sender.sent["user1"])
+	}
+
+	res = WithTimeChat(ctx, "user1", "hi", func(ctx context.Context, userId, msg string, stream *ReplyStream) string {
+		return "fast answer"
+	})
+	if res != "fast answer" {
+		t.Errorf("expected fast answer, got %q", res)
+	}
+}
+
+func TestWithTimeChatContext(t *testing.T) {
+	sender := &fakeSender{}
+	timeout := ChatTimeout
+	ChatTimeout = 10 * time.Millisecond
+	defer func() { ChatTimeout = timeout }()
+
+	// 请求取消后调用仍继续，直到请求的截止时间才被取消，结果交由异步回复
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	ctx, wait := WithAsyncTasks(ctx, sender)
+	cancel()
+	start := time.Now()
+	res := WithTimeChat(ctx, "user2", "hi", func(ctx context.Context, userId, msg string, stream *ReplyStream) string {
+		<-ctx.Done()
+		return ctx.Err().Error()
+	})
+	if res != "" {
+		t.Fatalf("expected empty passive reply, got %q", res)
+	}
+	wait()
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("call should run until the deadline, cancelled after %v", elapsed)
+	}
+	if sender.sent["user2"] != context.DeadlineExceeded.Error() {
+		t.Errorf("expected the call to end at the deadline, got %q", sender.sent["user2"])
+	}
+}
+
+func TestAsyncTasksPerRequest(t *testing.T) {
+	ctx1, wait1 := WithAsyncTasks(context.Background(), nil)
+	ctx2, wait2 := WithAsyncTasks(context.Background(), &fakeSender{})
+	release := make(chan struct{})
+	goAsync(ctx1, func() { <-release })
+
+	// 请求只等待自己的后台任务
+	done := make(chan struct{})
+	go func() {
+		wait2()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a request should not wait for other requests' tasks")
+	}
+	if replySenderOf(ctx1) != nil || replySenderOf(ctx2) == nil {
+		t.Error("each request should use its own sender")
+	}
+	close(release)
+	wait1()
+}
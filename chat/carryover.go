@@ -0,0 +1,137 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	// imagePlaceholder 无法保留图片时用来代替图片的文字
+	imagePlaceholder = "[图片]"
+
+	carryOverCommandHelp = "/carry on：切换机器人时携带当前会话的对话历史\n/carry off：切换机器人时不携带\n/carry default：使用全局配置"
+)
+
+// CarryOverEnabled 用户切换机器人时是否携带对话历史，用户的设置优先于 MSG_CARRY_OVER
+func CarryOverEnabled(userId string) bool {
+	if enabled, err := db.GetCarryOver(userId); err == nil && enabled != nil {
+		return *enabled
+	}
+	return config.IsMsgCarryOverEnabled()
+}
+
+// carryOverHistory 切换机器人时把当前会话在 from 上的对话历史和摘要转换后保存到 to，
+// 覆盖 to 在该会话中原有的对话历史，两边继续的是同一段对话
+func carryOverHistory(userId, from, to string) {
+	if from == to || IsLocalBot(from) || IsLocalBot(to) || db.ChatDbInstance == nil || !CarryOverEnabled(userId) {
+		return
+	}
+	sessionId := activeSession(userId)
+	list, err := db.ChatDbInstance.GetMsgList(from, userId, sessionId)
+	if err != nil {
+		fmt.Printf("carry over history from %s to %s error: %v\n", from, to, err)
+		return
+	}
+	summary, _ := db.ChatDbInstance.GetSummary(from, userId, sessionId)
+	if len(list) == 0 && summary == "" {
+		return
+	}
+	db.ChatDbInstance.SetMsgList(to, userId, sessionId, ConvertHistory(list, to))
+	db.ChatDbInstance.SetSummary(to, userId, sessionId, summary)
+}
+
+// ConvertHistory 将对话历史转换为目标机器人使用的格式：去掉 system 消息(目标机器人会使用自己的 prompt)，
+// Gemini 的 model 角色与其它机器人的 assistant 互相转换，目标机器人不支持图片时图片替换为文字说明。
+// 转换后连续同角色的消息会被合并，开头的回复会被去掉，以满足 Claude 等要求一问一答交替的接口
+func ConvertHistory(list []db.Msg, to string) []db.Msg {
+	botRole := ClaudeBot
+	if to == config.Bot_Type_Gemini {
+		botRole = GeminiBot
+	}
+	keepImage := GetCapabilities(to).Image
+	res := make([]db.Msg, 0, len(list))
+	for _, msg := range list {
+		var role string
+		switch msg.Role {
+		case ClaudeUser:
+			role = ClaudeUser
+		case ClaudeBot, GeminiBot:
+			role = botRole
+		default:
+			continue
+		}
+		parts := convertParts(msg.Parts, keepImage)
+		if len(parts) == 0 {
+			continue
+		}
+		if n := len(res); n > 0 && res[n-1].Role == role {
+			res[n-1].Parts = convertParts(append(res[n-1].Parts, parts...), keepImage)
+			continue
+		}
+		if len(res) == 0 && role != ClaudeUser {
+			continue
+		}
+		res = append(res, db.Msg{Role: role, Parts: parts})
+	}
+	return res
+}
+
+// convertParts 支持图片时原样保留，否则合并为一段文字，便于只读取第一段内容的机器人使用
+func convertParts(parts []db.ContentPart, keepImage bool) []db.ContentPart {
+	if keepImage {
+		return append([]db.ContentPart(nil), parts...)
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			if part.Data != "" {
+				texts = append(texts, part.Data)
+			}
+		} else {
+			texts = append(texts, imagePlaceholder)
+		}
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	return []db.ContentPart{{Type: "text", Data: strings.Join(texts, "\n\n")}}
+}
+
+// CarryOverCommand /carry on|off|default：设置切换机器人时是否携带对话历史
+func CarryOverCommand(param, userId string) string {
+	var enabled *bool
+	switch strings.ToLower(strings.TrimSpace(param)) {
+	case "":
+		source := "全局配置"
+		if v, err := db.GetCarryOver(userId); err == nil && v != nil {
+			source = "个人设置"
+		}
+		state := "关闭"
+		if CarryOverEnabled(userId) {
+			state = "开启"
+		}
+		return fmt.Sprintf("切换机器人时携带对话历史：%s(%s)\n%s", state, source, carryOverCommandHelp)
+	case "on":
+		enabled = new(bool)
+		*enabled = true
+	case "off":
+		enabled = new(bool)
+	case "default":
+	default:
+		return carryOverCommandHelp
+	}
+	if err := db.SetCarryOver(userId, enabled); err != nil {
+		return fmt.Sprintf("设置失败：%v", err)
+	}
+	switch {
+	case enabled == nil:
+		return "已恢复全局配置，" + CarryOverCommand("", userId)
+	case *enabled:
+		return "已开启，切换机器人时会携带当前会话的对话历史，新的机器人会覆盖原有的对话历史"
+	default:
+		return "已关闭，切换机器人时不携带对话历史"
+	}
+}
@@ -0,0 +1,74 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func TestConvertHistory(t *testing.T) {
+	gemini := []db.Msg{
+		{Role: GeminiUser, Parts: []db.ContentPart{{Type: "text", Data: "这是什么"}, {Type: "image", Data: "base64", MIMEType: "image/png"}}},
+		textMsg(GeminiBot, "一只猫"),
+		textMsg(GeminiBot, "很可爱"),
+		textMsg(GeminiUser, "谢谢"),
+	}
+	got := ConvertHistory(gemini, config.Bot_Type_Gpt)
+	if len(got) != 3 {
+		t.Fatalf("expected consecutive replies merged into 3 messages, got %v", got)
+	}
+	if got[0].Role != "user" || len(got[0].Parts) != 1 || got[0].Parts[0].Data != "这是什么\n\n"+imagePlaceholder {
+		t.Errorf("image should be described as text, got %v", got[0])
+	}
+	if got[1].Role != ClaudeBot || got[1].Parts[0].Data != "一只猫\n\n很可爱" {
+		t.Errorf("model role should become assistant, got %v", got[1])
+	}
+
+	gpt := []db.Msg{textMsg("system", "prompt"), textMsg("assistant", "欢迎"), textMsg("user", "你好"), textMsg("assistant", "你好！")}
+	got = ConvertHistory(gpt, config.Bot_Type_Gemini)
+	if len(got) != 2 || got[0].Role != GeminiUser || got[1].Role != GeminiBot {
+		t.Errorf("expected system and leading reply dropped and roles mapped, got %v", got)
+	}
+	if back := ConvertHistory(ConvertHistory(gemini, config.Bot_Type_Gemini), config.Bot_Type_Gemini); len(back[0].Parts) != 2 {
+		t.Errorf("gemini should keep image parts, got %v", back[0])
+	}
+}
+
+func TestCarryOverOnSwitch(t *testing.T) {
+	oldStore, oldDb := db.StoreInstance, db.ChatDbInstance
+	db.StoreInstance = db.NewMemoryStore()
+	db.ChatDbInstance = db.NewStoreChatDb(db.StoreInstance)
+	defer func() { db.StoreInstance, db.ChatDbInstance = oldStore, oldDb }()
+	t.Setenv("WX_TOKEN", "token")
+	t.Setenv("GPT_TOKEN", "sk-test")
+	t.Setenv("geminiKey", "key")
+	t.Setenv("botType", config.Bot_Type_Gpt)
+	t.Setenv(config.Msg_Carry_Over_Key, "")
+	const user = "carry-user"
+	history := []db.Msg{textMsg("user", "你好"), textMsg("assistant", "你好！")}
+	db.ChatDbInstance.SetMsgList(config.Bot_Type_Gpt, user, db.DefaultSessionId, history)
+
+	SwitchUserBot(user, config.Bot_Type_Gemini)
+	if list, _ := db.ChatDbInstance.GetMsgList(config.Bot_Type_Gemini, user, db.DefaultSessionId); len(list) != 0 {
+		t.Errorf("history should not be carried when disabled, got %v", list)
+	}
+
+	CarryOverCommand("on", user)
+	SwitchUserBot(user, config.Bot_Type_Gpt)
+	SwitchUserBot(user, config.Bot_Type_Gemini)
+	list, _ := db.ChatDbInstance.GetMsgList(config.Bot_Type_Gemini, user, db.DefaultSessionId)
+	if len(list) != 2 || list[1].Role != GeminiBot {
+		t.Errorf("expected history carried to gemini, got %v", list)
+	}
+
+	t.Setenv(config.Msg_Carry_Over_Key, "true")
+	CarryOverCommand("off", user)
+	if CarryOverEnabled(user) {
+		t.Error("user setting should override the global config")
+	}
+	CarryOverCommand("default", user)
+	if !CarryOverEnabled(user) {
+		t.Error("default should fall back to the global config")
+	}
+}
@@ -1,8 +1,8 @@
 package chat
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"slices"
 	"strconv"
 	"strings"
@@ -16,121 +16,145 @@ import (
 	"github.com/silenceper/wechat/v2/officialaccount/message"
 )
 
-var actionMap = map[string]func(param, userId string) string{
-	config.Wx_Command_Help: func(param, userId string) string {
-		return config.GetWxHelpReply()
-	},
-	config.Wx_Command_Gpt: func(param, userId string) string {
-		return SwitchUserBot(userId, config.Bot_Type_Gpt)
-	},
-	config.Wx_Command_Spark: func(param, userId string) string {
-		return SwitchUserBot(userId, config.Bot_Type_Spark)
-	},
-	config.Wx_Command_Qwen: func(param, userId string) string {
-		return SwitchUserBot(userId, config.Bot_Type_Qwen)
-	},
-	config.Wx_Command_Gemini: func(param, userId string) string {
-		return SwitchUserBot(userId, config.Bot_Type_Gemini)
-	},
-	config.Wx_Command_Keyword: func(param, userId string) string {
-		return SwitchUserBot(userId, config.Bot_Type_Keyword)
-	},
-	config.Wx_Command_AI: func(param, userId string) string {
+// actionFunc 命令的处理方法，ctx 为请求的 context
+type actionFunc func(ctx context.Context, param, userId string) string
+
+// simpleAction 不需要请求 context 的命令
+func simpleAction(f func(param, userId string) string) actionFunc {
+	return func(_ context.Context, param, userId string) string {
+		return f(param, userId)
+	}
+}
+
+// actionEntry 命令的处理方法及帮助中的说明
+type actionEntry struct {
+	run   actionFunc
+	usage string // 帮助中显示的用法，为空时为命令本身
+	help  string // 帮助中的说明，为空时不在帮助中列出
+}
+
+// actionMap 内置命令在 init 中注册，切换机器人的命令由 RegisterProvider 注册
+var actionMap = map[string]actionEntry{}
+
+// helpCommands 帮助中列出的命令，按注册顺序
+var helpCommands []string
+
+// registerAction 注册命令，同名命令会被覆盖
+func registerAction(command, usage, help string, run actionFunc) {
+	actionMap[command] = actionEntry{run: run, usage: usage, help: help}
+	if help != "" && !slices.Contains(helpCommands, command) {
+		helpCommands = append(helpCommands, command)
+	}
+}
+
+func init() {
+	registerAction(config.Wx_Command_Help, "", "查看帮助", simpleAction(func(param, userId string) string {
+		return GetHelpReply(userId)
+	}))
+	registerAction(config.Wx_Command_AI, "", "切换到AI对话模式", simpleAction(func(param, userId string) string {
 		// 切换回默认AI模式
 		lastAIBot, err := db.GetLastAIBot(userId)
-		if err == nil && slices.Contains(config.Support_Bots, lastAIBot) && lastAIBot != config.Bot_Type_Keyword && lastAIBot != config.Bot_Type_Echo {
+		if err == nil && !IsLocalBot(lastAIBot) {
 			return SwitchUserBot(userId, lastAIBot)
 		}
 
 		defaultBotType := config.GetBotType()
-		if !slices.Contains(config.Support_Bots, defaultBotType) || defaultBotType == config.Bot_Type_Keyword || defaultBotType == config.Bot_Type_Echo {
+		if IsLocalBot(defaultBotType) {
 			defaultBotType = config.Bot_Type_Echo
 		}
 		return SwitchUserBot(userId, defaultBotType)
-	},
-	config.Wx_Command_AddKeyword: func(param, userId string) string {
-		return AddKeyword(param, userId)
-	},
-	config.Wx_Command_DelKeyword: func(param, userId string) string {
-		return DelKeyword(param, userId)
-	},
-	config.Wx_Command_ListKeywords: func(param, userId string) string {
-		return ListKeywords(param, userId)
-	},
-	config.Wx_Command_Claude: func(param, userId string) string {
-		return SwitchUserBot(userId, config.Bot_Type_Claude)
-	},
-
-	config.Wx_Command_Prompt:    SetPrompt,
-	config.Wx_Command_RmPrompt:  RmPrompt,
-	config.Wx_Command_GetPrompt: GetPrompt,
-
-	config.Wx_Command_SetModel: SetModel,
-	config.Wx_Command_GetModel: GetModel,
-	config.Wx_Command_Clear:    ClearMsg,
-
-	config.Wx_Todo_List: GetTodoList,
-	config.Wx_Todo_Add:  AddTodo,
-	config.Wx_Todo_Del:  DelTodo,
-
-	config.Wx_Coin:          GetCoin,
-	config.Wx_Command_AddMe: AddMe,
-	
-	// Wx_Command_Movie 命令已被移除，其功能逻辑已迁移到 chat/keyword.go 文件中
-}
+	}))
+	registerAction(config.Wx_Command_AddKeyword, "/addkeyword 关键词:回复内容", "添加关键词", simpleAction(AddKeyword))
+	registerAction(config.Wx_Command_DelKeyword, "/delkeyword 关键词", "删除关键词", simpleAction(DelKeyword))
+	registerAction(config.Wx_Command_ListKeywords, "", "查看关键词列表", simpleAction(ListKeywords))
+
+	registerAction(config.Wx_Command_Prompt, "/prompt 你的prompt", "设置system prompt", simpleAction(SetPrompt))
+	registerAction(config.Wx_Command_GetPrompt, "", "获取当前设置prompt", simpleAction(GetPrompt))
+	registerAction(config.Wx_Command_RmPrompt, "", "清除当前设置prompt", simpleAction(RmPrompt))
+
+	registerAction(config.Wx_Command_SetModel, "/setmodel model", "设置自定义model，不带model时重置为默认值", simpleAction(SetModel))
+	registerAction(config.Wx_Command_GetModel, "", "获取当前model", simpleAction(GetModel))
+	registerAction(config.Wx_Command_Models, "", "查看可选model", simpleAction(ListModels))
+	registerAction(config.Wx_Command_Set, "/set 参数 值", "设置temperature等生成参数", simpleAction(SetParamCommand))
+	registerAction(config.Wx_Command_Params, "", "查看生成参数", simpleAction(ShowParamsCommand))
+	registerAction(config.Wx_Command_Persona, "", "查看和使用预设角色", simpleAction(PersonaCommand))
+
+	registerAction(config.Wx_Command_NewSession, "/new 名称", "新建会话", simpleAction(NewSessionCommand))
+	registerAction(config.Wx_Command_Sessions, "", "查看会话", simpleAction(ListSessionsCommand))
+	registerAction(config.Wx_Command_SwitchSession, "/switch 名称或序号", "切换会话", simpleAction(SwitchSessionCommand))
+	registerAction(config.Wx_Command_RenameSession, "/rename 名称", "重命名当前会话", simpleAction(RenameSessionCommand))
+	registerAction(config.Wx_Command_DelSession, "/delsession 名称或序号", "删除会话", simpleAction(DeleteSessionCommand))
+	registerAction(config.Wx_Command_Export, "", "导出当前会话", simpleAction(ExportCommand))
+	registerAction(config.Wx_Command_Carry, "/carry on|off", "切换机器人时是否携带对话历史", simpleAction(CarryOverCommand))
+	registerAction(config.Wx_Command_Compare, "/compare 问题", "同时询问多个机器人", CompareCommand)
+	registerAction(config.Wx_Command_Ask, "/ask 机器人 问题", "向指定机器人单次提问", AskCommand)
+
+	registerAction(config.Wx_Command_Clear, "", "清除历史对话", simpleAction(ClearMsg))
+
+	registerAction(config.Wx_Todo_Add, "/ta 代办事项", "设置todo", simpleAction(AddTodo))
+	registerAction(config.Wx_Todo_List, "", "获取代办列表", simpleAction(GetTodoList))
+	registerAction(config.Wx_Todo_Del, "/td 序号", "删除索引代办事件", simpleAction(DelTodo))
+
+	registerAction(config.Wx_Coin, "/cb 代币对", "查询价格", simpleAction(GetCoin))
+	registerAction(config.Wx_Command_More, "/more 或 继续", "查看超长回复的剩余部分", simpleAction(MoreReply))
+	registerAction(config.Wx_Command_Quota, "", "查看今日额度", simpleAction(GetQuotaReply))
+	registerAction(config.Wx_Command_AddMe, "/addme 密码", "认证用户", simpleAction(AddMe))
+
+	registerAction(config.Wx_Command_Tier, "/tier 用户openid 等级", "设置用户额度等级", simpleAction(SetUserTier))
+	registerAction(config.Wx_Command_Usage, "/usage 天数 用户openid", "查看用量", simpleAction(GetUsageReply))
+
+	registerAction(config.Wx_Command_Grant, "/grant 用户openid 角色", "授予角色", simpleAction(GrantRoleCommand))
+	registerAction(config.Wx_Command_Revoke, "/revoke 用户openid", "撤销角色", simpleAction(RevokeRoleCommand))
+	registerAction(config.Wx_Command_Roles, "", "查看已授予的角色", simpleAction(ListRolesCommand))
+
+	registerAction(config.Wx_Command_Invite, "/invite uses=次数 role=角色", "生成邀请码", simpleAction(CreateInviteCommand))
+	registerAction(config.Wx_Command_Invites, "", "查看邀请码", simpleAction(ListInvitesCommand))
+	registerAction(config.Wx_Command_DelInvite, "/delinvite 邀请码", "删除邀请码", simpleAction(DelInviteCommand))
 
-// isAdmin 检查用户是否为管理员
-func isAdmin(userId string) bool {
-	adminUsers := config.GetAdminUsers()
-	for _, admin := range adminUsers {
-		if admin == userId {
-			return true
-		}
-	}
-	return false
+	// Wx_Command_Movie 命令已被移除，其功能逻辑已迁移到 chat/keyword.go 文件中
 }
 
-func DoAction(userId, msg string) (r string, flag bool) {
+// DoAction 按最长前缀匹配命令并执行，用户的角色低于命令所需的角色时返回无权限的提示
+func DoAction(ctx context.Context, userId, msg string) (r string, flag bool) {
 	action, param, flag := isAction(msg)
 	if flag {
-		// 管理员权限检查
-		switch action {
-		case config.Wx_Command_AddKeyword,
-			config.Wx_Command_DelKeyword,
-			config.Wx_Command_Prompt,
-			config.Wx_Command_RmPrompt,
-			config.Wx_Command_SetModel,
-			config.Wx_Command_Clear,
-			config.Wx_Todo_Add,
-			config.Wx_Todo_Del:
-			if !isAdmin(userId) {
-				return "对不起，您没有权限执行此操作。", true
+		// 角色权限检查，命令所需的角色见 commandRoles
+		if role := GetUserRole(userId); roleLevel(role) < roleLevel(requiredRole(action)) {
+			if role == RoleGuest {
+				return guestReply, true
 			}
+			return noPermissionReply, true
 		}
 
-		f := actionMap[action]
-		r = f(param, userId)
+		r = actionMap[action].run(ctx, param, userId)
 	}
 	return
 }
 
+// isAction 按最长前缀匹配命令，避免 /invite 抢先匹配 /invites 之类的命令
 func isAction(msg string) (string, string, bool) {
+	config.LoadBots()
+	action := ""
 	for key := range actionMap {
-		if strings.HasPrefix(msg, key) {
-			return msg[:len(key)], strings.TrimSpace(msg[len(key):]), true
+		if strings.HasPrefix(msg, key) && len(key) > len(action) {
+			action = key
 		}
 	}
-	return "", "", false
+	if action == "" {
+		return "", "", false
+	}
+	return action, strings.TrimSpace(msg[len(action):]), true
 }
 
+// BaseChat 机器人，ctx 一般来自 http 请求并带有截止时间，超过后取消对AI接口的调用
 type BaseChat interface {
-	Chat(userId string, msg string, imageURL ...string) string
+	Chat(ctx context.Context, userId string, msg string, imageURL ...string) string
 	HandleMediaMsg(msg *message.MixMessage) string
 }
 type SimpleChat struct {
 }
 
-func (s SimpleChat) Chat(userId string, msg string, imageURL ...string) string {
+func (s SimpleChat) Chat(ctx context.Context, userId string, msg string, imageURL ...string) string {
 	panic("implement me")
 }
 
@@ -147,16 +171,10 @@ func (s SimpleChat) HandleMediaMsg(msg *message.MixMessage) string {
 			}
 			return subText
 		} else if msg.Event == message.EventClick {
-			switch msg.EventKey {
-			case config.GetWxEventKeyChatGpt():
-				return SwitchUserBot(string(msg.FromUserName), config.Bot_Type_Gpt)
-			case config.GetWxEventKeyChatSpark():
-				return SwitchUserBot(string(msg.FromUserName), config.Bot_Type_Spark)
-			case config.GetWxEventKeyChatQwen():
-				return SwitchUserBot(string(msg.FromUserName), config.Bot_Type_Qwen)
-			default:
-				return fmt.Sprintf("unkown event key=%v", msg.EventKey)
+			if p, ok := getBotByEventKey(msg.EventKey); ok {
+				return SwitchUserBot(string(msg.FromUserName), p.Name)
 			}
+			return fmt.Sprintf("unkown event key=%v", msg.EventKey)
 		} else {
 			return "不支持的类型"
 		}
@@ -167,30 +185,23 @@ func (s SimpleChat) HandleMediaMsg(msg *message.MixMessage) string {
 
 func SwitchUserBot(userId string, botType string) string {
 	// 如果是切换到AI模型，则保存上次使用的AI模型
-	if botType != config.Bot_Type_Keyword && botType != config.Bot_Type_Echo {
+	if !IsLocalBot(botType) {
 		db.SetLastAIBot(userId, botType)
 	}
-	if _, err := config.CheckBotConfig(botType); err != nil {
+	if _, err := CheckBotConfig(botType); err != nil {
 		return err.Error()
 	}
+	carryOverHistory(userId, config.GetUserBotType(userId), botType)
 	db.SetValue(fmt.Sprintf("%v:%v", config.Bot_Type_Key, userId), botType, 0)
-	return config.GetBotWelcomeReply(botType)
+	return GetBotWelcomeReply(botType)
 }
 
 func SetPrompt(param, userId string) string {
 	botType := config.GetUserBotType(userId)
-	switch botType {
-	case config.Bot_Type_Gpt:
-		db.SetPrompt(userId, botType, param)
-	case config.Bot_Type_Qwen:
-		db.SetPrompt(userId, botType, param)
-	case config.Bot_Type_Spark:
-		db.SetPrompt(userId, botType, param)
-	case config.Bot_Type_Claude:
-		db.SetPrompt(userId, botType, param)
-	default:
+	if !IsSupportPrompt(botType) {
 		return fmt.Sprintf("%s 不支持设置system prompt", botType)
 	}
+	db.SetPrompt(userId, botType, param)
 	return fmt.Sprintf("%s 设置prompt成功", botType)
 }
 
@@ -202,6 +213,9 @@ func RmPrompt(param string, userId string) string {
 
 func GetPrompt(param string, userId string) string {
 	botType := config.GetUserBotType(userId)
+	if persona := ActivePersona(userId); persona != nil && persona.Prompt != "" {
+		return fmt.Sprintf("%s 正在使用角色 %s，prompt：%s", botType, persona.Name, persona.Prompt)
+	}
 	prompt, err := db.GetPrompt(userId, botType)
 	if err != nil {
 		return fmt.Sprintf("%s 当前未设置prompt", botType)
@@ -287,65 +301,70 @@ func GetCoin(param, userId string) string {
 	return fmt.Sprintf("代币对:%s 价格:%s", coinPrice.Symbol, coinPrice.Price)
 }
 
-func SetModel(param, userId string) string {
-	botType := config.GetUserBotType(userId)
-	if botType == config.Bot_Type_Gpt || botType == config.Bot_Type_Gemini || botType == config.Bot_Type_Qwen {
-		if err := db.SetModel(userId, botType, param); err != nil {
-			return fmt.Sprintf("%s 设置model失败", botType)
-		}
-		return fmt.Sprintf("%s 设置model成功", botType)
-	}
-	return fmt.Sprintf("%s 不支持设置model", botType)
-}
-
-func GetModel(param string, userId string) string {
-	botType := config.GetUserBotType(userId)
-	model, err := db.GetModel(userId, botType)
-	if err != nil || model == "" {
-		return fmt.Sprintf("%s 当前未设置model", botType)
-	}
-	return fmt.Sprintf("%s 获取model成功，model：%s", botType, model)
-}
-
 func ClearMsg(param string, userId string) string {
 	botType := config.GetUserBotType(userId)
-	db.DeleteMsgList(botType, userId)
+	db.DeleteMsgList(botType, userId, activeSession(userId))
 	return fmt.Sprintf("%s 清除消息成功", botType)
 }
 
+// AddMe /addme 密码或邀请码：认证用户
 func AddMe(param, userId string) string {
+	if param == "" {
+		return guestReply
+	}
 	password := config.GetAddMePassword()
-	if password == "" {
-		return "功能还在开发中"
+	if password == "" || param != password {
+		return redeemInvite(param, userId)
 	}
-
-	if param == password {
-		config.AuthenticateUser(userId)
-		return "认证成功！你现在可以使用AI功能了"
-	} else {
-		return "功能还在开发中"
+	// 认证即授予普通用户角色，已有更高角色的用户保持不变
+	if !HasRole(userId, RoleMember) {
+		if err := GrantRole(userId, RoleMember); err != nil {
+			return fmt.Sprintf("认证失败：%v", err)
+		}
 	}
+	return "认证成功！你现在可以使用AI功能了"
 }
 
-// 加入超时控制
-func WithTimeChat(userId, msg string, f func(userId, msg string) string) string {
+// ChatTimeout 被动回复的等待时间，超时后转为异步回复
+var ChatTimeout = 10 * time.Second
+
+// 加入超时控制，超时时回复已经流式收到的部分，没有时返回空字符串，剩余或迟到的回复交由 deliverAsync 处理
+func WithTimeChat(ctx context.Context, userId, msg string, f func(ctx context.Context, userId, msg string, stream *ReplyStream) string) string {
 	if _, ok := config.Cache.Load(userId + msg); ok {
 		rAny, _ := config.Cache.Load(userId + msg)
 		r := rAny.(string)
 		config.Cache.Delete(userId + msg)
 		return r
 	}
-	resChan := make(chan string)
-	go func() {
-		resChan <- f(userId, msg)
-	}()
+	resChan := make(chan string, 1)
+	stream := &ReplyStream{}
+	callCtx, cancel := detachContext(ctx)
+	goAsync(ctx, func() {
+		defer cancel()
+		resChan <- f(callCtx, userId, msg, stream)
+	})
 	select {
 	case res := <-resChan:
 		return res
-	case <-time.After(10 * time.Second):
-		config.Cache.Store(userId+msg, <-resChan)
-		return ""
+	case <-time.After(ChatTimeout):
+		hint := fmt.Sprintf("\n\n（回答未完，剩余部分%s）", lateReplyHint(ctx))
+		partial := stream.take(config.GetWxReplyMaxBytes() - len(hint))
+		deliverAsync(ctx, userId, msg, resChan, stream)
+		if partial == "" {
+			return ""
+		}
+		return partial + hint
+	}
+}
+
+// detachContext 微信 5 秒内未收到回复会断开连接并重试，请求的 context 随之取消，但回复仍要交给重试的请求或异步推送，
+// 因此对AI接口的调用只继承请求的截止时间，不继承取消。没有截止时间时使用 WX_REQUEST_TIMEOUT，保证 deliverAsync 不会一直等待
+func detachContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(config.GetWxRequestTimeout())
 	}
+	return context.WithDeadline(context.WithoutCancel(ctx), deadline)
 }
 
 type ErrorChat struct {
@@ -356,7 +375,7 @@ func (e *ErrorChat) HandleMediaMsg(msg *message.MixMessage) string {
 	return e.errMsg
 }
 
-func (e *ErrorChat) Chat(userId string, msg string, imageURL ...string) string {
+func (e *ErrorChat) Chat(ctx context.Context, userId string, msg string, imageURL ...string) string {
 	return e.errMsg
 }
 
@@ -365,83 +384,35 @@ func GetChatBot(botType string) BaseChat {
 		botType = config.GetBotType()
 	}
 	var err error
-	botType, err = config.CheckBotConfig(botType)
+	botType, err = CheckBotConfig(botType)
 	if err != nil {
 		return &ErrorChat{
 			errMsg: err.Error(),
 		}
 	}
-	maxTokens := config.GetMaxTokens()
-
-	switch botType {
-	case config.Bot_Type_Keyword:
-		return &KeywordChat{}
-	case config.Bot_Type_Gpt:
-		url := os.Getenv("GPT_URL")
-		if url == "" {
-			url = "https://api.openai.com/v1/"
-		}
-		return &SimpleGptChat{
-			token:     config.GetGptToken(),
-			url:       url,
-			maxTokens: maxTokens,
-			BaseChat:  SimpleChat{},
-		}
-	case config.Bot_Type_Gemini:
-		return &GeminiChat{
-			BaseChat:  SimpleChat{},
-			key:       config.GetGeminiKey(),
-			maxTokens: maxTokens,
-		}
-	case config.Bot_Type_Spark:
-		config, _ := config.GetSparkConfig()
-		return &SparkChat{
-			BaseChat:  SimpleChat{},
-			Config:    config,
-			maxTokens: maxTokens,
-		}
-	case config.Bot_Type_Qwen:
-		config, _ := config.GetQwenConfig()
-		return &QwenChat{
-			BaseChat:  SimpleChat{},
-			Config:    config,
-			maxTokens: maxTokens,
-		}
-	case config.Bot_Type_Claude:
-		return &ClaudeChat{
-			BaseChat:  SimpleChat{},
-			key:       config.GetClaudeKey(),
-			url:       config.GetClaudeUrl(),
-			maxTokens: maxTokens,
-		}
-	default:
-		return &Echo{}
+	if p, ok := GetProvider(botType); ok && p.New != nil {
+		return p.New()
 	}
+	return &Echo{}
 }
 
 func GetGeminiChatBot() BaseChat {
 	return &GeminiChat{
-		BaseChat:  SimpleChat{},
-		key:       config.GetGeminiKey(),
-		maxTokens: config.GetMaxTokens(),
+		BaseChat: SimpleChat{},
+		key:      config.GetGeminiKey(),
 	}
 }
 
 
 type ChatMsg interface {
-	openai.ChatCompletionMessage | QwenMessage | SparkMessage | *genai.Content
+	openai.ChatCompletionMessage | QwenMessage | SparkMessage | ClaudeMessage | *genai.Content
 }
 
 func GetMsgListWithDb[T ChatMsg](botType, userId string, msg T, f func(msg T) db.Msg, f2 func(msg db.Msg) T) []T {
 	var dbList []db.Msg
-	isSupportPrompt := config.IsSupportPrompt(botType)
+	isSupportPrompt := IsSupportPrompt(botType)
 	if isSupportPrompt {
-		prompt, err := db.GetPrompt(userId, botType)
-		if err != nil || prompt == "" {
-			prompt = config.GetDefaultSystemPrompt()
-		}
-
-		if prompt != "" {
+		if prompt := systemPrompt(botType, userId); prompt != "" {
 			dbList = append(dbList, db.Msg{
 				Role: "system",
 				Parts: []db.ContentPart{
@@ -451,18 +422,25 @@ func GetMsgListWithDb[T ChatMsg](botType, userId string, msg T, f func(msg T) db
 		}
 	}
 	if db.ChatDbInstance != nil {
-		list, err := db.ChatDbInstance.GetMsgList(botType, userId)
+		sessionId := activeSession(userId)
+		if isSupportPrompt {
+			// 旧对话的摘要紧跟在 system prompt 之后
+			if summary, err := db.ChatDbInstance.GetSummary(botType, userId, sessionId); err == nil && summary != "" {
+				dbList = append(dbList, summaryMsg(summary))
+			}
+		}
+		list, err := db.ChatDbInstance.GetMsgList(botType, userId, sessionId)
 		if err == nil {
-			// check is contain system prompt
-			if len(list) > 0 {
-				if len(list[0].Parts) > 0 && list[0].Role == "system" {
-					list = list[1:]
-				}
+			// 保存的历史中包含 system prompt 和摘要，以最新的为准
+			for len(list) > 0 && list[0].Role == "system" {
+				list = list[1:]
 			}
 			dbList = append(dbList, list...)
 		}
 	}
 	dbList = append(dbList, f(msg))
+	// 按策略裁剪历史，避免超出模型上下文
+	dbList = TrimHistory(dbList, readHistoryPolicy(botType, userId))
 	r := make([]T, 0)
 	for _, msg := range dbList {
 		r = append(r, f2(msg))
@@ -470,14 +448,16 @@ func GetMsgListWithDb[T ChatMsg](botType, userId string, msg T, f func(msg T) db
 	return r
 }
 
-func SaveMsgListWithDb[T ChatMsg](botType, userId string, msgList []T, f func(msg T) db.Msg) {
+func SaveMsgListWithDb[T ChatMsg](ctx context.Context, botType, userId string, msgList []T, f func(msg T) db.Msg) {
 	if db.ChatDbInstance != nil {
-		go func() {
+		sessionId := activeSession(userId)
+		// 生成摘要需要调用机器人，请求结束前需等待其完成
+		goAsync(ctx, func() {
 			list := make([]db.Msg, 0)
 			for _, msg := range msgList {
 				list = append(list, f(msg))
 			}
-			db.ChatDbInstance.SetMsgList(botType, userId, list)
-		}()
+			db.ChatDbInstance.SetMsgList(botType, userId, sessionId, summarizeHistory(ctx, botType, userId, sessionId, list))
+		})
 	}
 }
\ No newline at end of file
@@ -3,11 +3,15 @@ package chat
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/pwh-pwh/aiwechat-vercel/config"
 	"github.com/pwh-pwh/aiwechat-vercel/db"
@@ -18,16 +22,40 @@ const (
 	ClaudeBot  = "assistant"
 )
 
+func init() {
+	RegisterProvider(&Provider{
+		Name:         config.Bot_Type_Claude,
+		Title:        "Claude",
+		Command:      config.Wx_Command_Claude,
+		Description:  "与claude对话",
+		Capabilities: Capabilities{Prompt: true, Model: true, Image: true},
+		Params: map[string]ParamRule{
+			ParamTemperature: {Min: 0, Max: 1, Default: 0.7},
+			ParamTopP:        {Min: 0, Max: 1},
+			ParamTopK:        {Min: 1, Max: 500, Integer: true},
+			ParamMaxTokens:   {Min: 1, Max: 128000, Integer: true, Default: 4096}, // Messages API 要求必须传 max_tokens
+		},
+		CheckConfig:  config.CheckClaudeConfig,
+		Welcome:      config.GetClaudeWelcomeReply,
+		New: func() BaseChat {
+			return &ClaudeChat{
+				BaseChat:  SimpleChat{},
+				key:       config.GetClaudeKey(),
+				url:       config.GetClaudeUrl(),
+			}
+		},
+	})
+}
+
 type ClaudeChat struct {
 	BaseChat
 	key       string
 	url       string
-	maxTokens int
 }
 
 type ClaudeMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string          `json:"role"`
+	Content []ClaudeContent `json:"content"`
 }
 
 type ClaudeRequest struct {
@@ -35,42 +63,113 @@ type ClaudeRequest struct {
 	Messages      []ClaudeMessage `json:"messages"`
 	MaxTokens     int           `json:"max_tokens,omitempty"`
 	System        string        `json:"system,omitempty"`
-	Temperature   float64       `json:"temperature,omitempty"`
+	Temperature   *float64      `json:"temperature,omitempty"`
+	TopP          *float64      `json:"top_p,omitempty"`
+	TopK          *int          `json:"top_k,omitempty"`
 	Stream        bool          `json:"stream"`
 }
 
 type ClaudeResponse struct {
 	Content []ClaudeContent `json:"content"`
 	Model   string          `json:"model"`
+	Usage   ClaudeUsage     `json:"usage"`
+}
+
+type ClaudeUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 }
 
 type ClaudeContent struct {
-	Text string `json:"text"`
-	Type string `json:"type"`
+	Text   string             `json:"text,omitempty"`
+	Type   string             `json:"type"`
+	Source *ClaudeImageSource `json:"source,omitempty"`
+}
+
+// ClaudeImageSource 图片内容，参考：https://docs.anthropic.com/en/docs/build-with-claude/vision
+type ClaudeImageSource struct {
+	Type      string `json:"type"` // 只使用 base64
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// claudeImageTypes Claude 支持的图片格式
+var claudeImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+func claudeText(role, text string) ClaudeMessage {
+	return ClaudeMessage{Role: role, Content: []ClaudeContent{{Type: "text", Text: text}}}
+}
+
+// ClaudeStreamEvent 流式输出的事件，参考：https://docs.anthropic.com/en/api/messages-streaming
+type ClaudeStreamEvent struct {
+	Type    string          `json:"type"`
+	Message *ClaudeResponse `json:"message,omitempty"` // message_start 中包含输入的用量
+	Delta   ClaudeContent   `json:"delta"`             // content_block_delta 中的增量文本
+	Usage   *ClaudeUsage    `json:"usage,omitempty"`   // message_delta 中包含累计的输出用量
+	Error   *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
 }
 
 func (s *ClaudeChat) toDbMsg(msg ClaudeMessage) db.Msg {
-	return db.Msg{
-		Role: msg.Role,
-		Parts: []db.ContentPart{
-			{Type: "text", Data: msg.Content},
-		},
+	dbMsg := db.Msg{
+		Role:  msg.Role,
+		Parts: []db.ContentPart{},
+	}
+	for _, content := range msg.Content {
+		switch content.Type {
+		case "text":
+			dbMsg.Parts = append(dbMsg.Parts, db.ContentPart{Type: "text", Data: content.Text})
+		case "image":
+			// 图片与 gemini 一样以 Base64 字符串保存
+			dbMsg.Parts = append(dbMsg.Parts, db.ContentPart{Type: "image", Data: content.Source.Data, MIMEType: content.Source.MediaType})
+		}
 	}
+	return dbMsg
 }
 
 func (s *ClaudeChat) toChatMsg(msg db.Msg) ClaudeMessage {
-	text := ""
-	if len(msg.Parts) > 0 {
-		text = msg.Parts[0].Data
-	}
-	return ClaudeMessage{
+	chatMsg := ClaudeMessage{
 		Role:    msg.Role,
-		Content: text,
+		Content: []ClaudeContent{},
 	}
+	for _, part := range msg.Parts {
+		switch {
+		case part.Type == "text" && part.Data != "":
+			// 接口不接受空的文本内容
+			chatMsg.Content = append(chatMsg.Content, ClaudeContent{Type: "text", Text: part.Data})
+		case part.Type == "image" && slices.Contains(claudeImageTypes, part.MIMEType):
+			chatMsg.Content = append(chatMsg.Content, ClaudeContent{
+				Type:   "image",
+				Source: &ClaudeImageSource{Type: "base64", MediaType: part.MIMEType, Data: part.Data},
+			})
+		case part.Type == "image":
+			// 从其它机器人携带过来的图片格式可能不受支持
+			chatMsg.Content = append(chatMsg.Content, ClaudeContent{Type: "text", Text: imagePlaceholder})
+		}
+	}
+	return chatMsg
+}
+
+// splitSystem Messages API 的 system prompt 单独传递，取出 system prompt 和摘要，按顺序合并
+func (s *ClaudeChat) splitSystem(msgs []ClaudeMessage) (string, []ClaudeMessage) {
+	var system []string
+	messages := make([]ClaudeMessage, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Role != "system" {
+			messages = append(messages, msg)
+			continue
+		}
+		for _, content := range msg.Content {
+			system = append(system, content.Text)
+		}
+	}
+	return strings.Join(system, "\n\n"), messages
 }
 
 func (s *ClaudeChat) getModel(userId string) string {
-	if model, err := db.GetModel(userId, config.Bot_Type_Claude); err == nil && model != "" {
+	if model := userModel(userId, config.Bot_Type_Claude); model != "" {
 		return model
 	} else if model := os.Getenv("claudeModel"); model != "" {
 		return model
@@ -78,117 +177,141 @@ func (s *ClaudeChat) getModel(userId string) string {
 	return "claude-3-5-sonnet-20241022"
 }
 
-func (s *ClaudeChat) chat(userId, msg string) string {
-	apiUrl := fmt.Sprintf("%s/v1/messages", s.url)
-	
-	// Get conversation history from database
-	var dbMsgs []db.Msg
-	if db.ChatDbInstance != nil {
-		historyMsgs, err := db.ChatDbInstance.GetMsgList(config.Bot_Type_Claude, userId)
-		if err == nil && len(historyMsgs) > 0 {
-			dbMsgs = historyMsgs
+func (s *ClaudeChat) reply(ctx context.Context, userId, msg string, stream *ReplyStream, imageURL ...string) (string, error) {
+	var content []ClaudeContent
+	if len(imageURL) > 0 && imageURL[0] != "" {
+		imageData, mimeType, err := downloadImage(ctx, imageURL[0])
+		if err != nil {
+			return "", err
 		}
+		if !slices.Contains(claudeImageTypes, mimeType) {
+			return "", &ProviderError{Kind: ErrKindBadRequest, BotType: config.Bot_Type_Claude, Err: fmt.Errorf("unsupported image type: %s", mimeType)}
+		}
+		content = append(content, ClaudeContent{
+			Type:   "image",
+			Source: &ClaudeImageSource{Type: "base64", MediaType: mimeType, Data: base64.StdEncoding.EncodeToString(imageData)},
+		})
 	}
-	
-	// Convert database messages to Claude format
-	var messages []ClaudeMessage
-	for _, dbMsg := range dbMsgs {
-		messages = append(messages, s.toChatMsg(dbMsg))
-	}
-	
-	// Add current user message
-	messages = append(messages, ClaudeMessage{
-		Role:    ClaudeUser,
-		Content: msg,
-	})
-	
+	if msg != "" {
+		content = append(content, ClaudeContent{Type: "text", Text: msg})
+	}
+
+	// 历史中包含 system prompt 和摘要，请求时单独传递
+	msgs := GetMsgListWithDb(config.Bot_Type_Claude, userId, ClaudeMessage{Role: ClaudeUser, Content: content}, s.toDbMsg, s.toChatMsg)
+	system, messages := s.splitSystem(msgs)
+	responseText, err := s.complete(ctx, userId, system, messages, stream)
+	if err != nil {
+		return "", err
+	}
+
+	msgs = append(msgs, claudeText(ClaudeBot, responseText))
+	SaveMsgListWithDb(ctx, config.Bot_Type_Claude, userId, msgs, s.toDbMsg)
+	return responseText, nil
+}
+
+// Complete 单次补全，不读取也不保存历史
+func (s *ClaudeChat) Complete(ctx context.Context, userId, prompt string) (string, error) {
+	return s.complete(ctx, userId, "", []ClaudeMessage{claudeText(ClaudeUser, prompt)}, nil)
+}
+
+func (s *ClaudeChat) complete(ctx context.Context, userId, system string, messages []ClaudeMessage, stream *ReplyStream) (string, error) {
+	apiUrl := fmt.Sprintf("%s/v1/messages", s.url)
+
 	// Create request body
+	params := GenerationParams(config.Bot_Type_Claude, userId)
 	reqBody := ClaudeRequest{
-		Model:     s.getModel(userId),
-		Messages:  messages,
-		MaxTokens: s.maxTokens,
-		System:    config.GetDefaultSystemPrompt(),
-		Temperature: 0.7,
-		Stream:    false,
-	}
-	
-	// Remove max_tokens if it's 0 or negative
-	if s.maxTokens <= 0 {
-		reqBody.MaxTokens = 0
-	}
-	
+		Model:       s.getModel(userId),
+		Messages:    messages,
+		System:      system,
+		Temperature: params.Temperature,
+		TopP:        params.TopP,
+		TopK:        params.TopK,
+		Stream:      true,
+	}
+	if params.MaxTokens != nil {
+		reqBody.MaxTokens = *params.MaxTokens
+	}
+
 	// Convert request to JSON
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return fmt.Sprintf("Error creating request: %v", err)
+		return "", fmt.Errorf("Error creating request: %v", err)
 	}
-	
+
 	// Create HTTP request
-	req, err := http.NewRequestWithContext(context.Background(), "POST", apiUrl, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", apiUrl, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Sprintf("Error creating request: %v", err)
+		return "", fmt.Errorf("Error creating request: %v", err)
 	}
-	
+
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("x-api-key", s.key)
 	req.Header.Set("anthropic-version", "2023-06-01")
-	
+
 	// Send request
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Sprintf("Error sending request: %v", err)
+		return "", fmt.Errorf("Error sending request: %w", err)
 	}
 	defer resp.Body.Close()
-	
-	// Read response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Sprintf("Error reading response: %v", err)
-	}
-	
+
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Sprintf("API error (status %d): %s", resp.StatusCode, string(body))
+		body, _ := io.ReadAll(resp.Body)
+		return "", newHTTPError(config.Bot_Type_Claude, resp.StatusCode, body)
 	}
-	
-	// Parse response
-	var claudeResp ClaudeResponse
-	if err := json.Unmarshal(body, &claudeResp); err != nil {
-		return fmt.Sprintf("Error parsing response: %v", err)
-	}
-	
-	// Extract response text
-	if len(claudeResp.Content) == 0 {
-		return "Error: Empty response from Claude"
-	}
-	
-	responseText := claudeResp.Content[0].Text
-	
-	// Save conversation to database
-	messages = append(messages, ClaudeMessage{
-		Role:    ClaudeBot,
-		Content: responseText,
+
+	// Read streaming events
+	var text strings.Builder
+	var usage ClaudeUsage
+	err = readSSE(resp.Body, func(data string) error {
+		var event ClaudeStreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return fmt.Errorf("Error parsing response: %v", err)
+		}
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				usage.InputTokens = event.Message.Usage.InputTokens
+			}
+		case "content_block_delta":
+			text.WriteString(event.Delta.Text)
+			stream.Write(event.Delta.Text)
+		case "message_delta":
+			if event.Usage != nil {
+				usage.OutputTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			if event.Error != nil {
+				return classifyError(config.Bot_Type_Claude, fmt.Errorf("%s: %s", event.Error.Type, event.Error.Message))
+			}
+		}
+		return nil
 	})
-	
-	// Convert back to database format for saving
-	var saveMsgs []db.Msg
-	for _, msg := range messages {
-		saveMsgs = append(saveMsgs, s.toDbMsg(msg))
+	if err != nil {
+		return "", err
 	}
-	
-	if db.ChatDbInstance != nil {
-		db.ChatDbInstance.SetMsgList(config.Bot_Type_Claude, userId, saveMsgs)
+	RecordUsage(userId, config.Bot_Type_Claude, reqBody.Model, TokenUsage{
+		PromptTokens:     usage.InputTokens,
+		CompletionTokens: usage.OutputTokens,
+	})
+
+	// Extract response text
+	if text.Len() == 0 {
+		return "", errors.New("Error: Empty response from Claude")
 	}
-	
-	return responseText
+
+	return text.String(), nil
 }
 
-func (c *ClaudeChat) Chat(userId string, msg string, imageURL ...string) string {
-	r, flag := DoAction(userId, msg)
+func (c *ClaudeChat) Chat(ctx context.Context, userId string, msg string, imageURL ...string) string {
+	r, flag := DoAction(ctx, userId, msg)
 	if flag {
 		return r
 	}
-	return WithTimeChat(userId, msg, c.chat)
+	return WithTimeChat(ctx, userId, msg, func(ctx context.Context, userId, msg string, stream *ReplyStream) string {
+		return chatWithFailover(ctx, config.Bot_Type_Claude, c, userId, msg, stream, imageURL...)
+	})
 }
\ No newline at end of file
@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func TestClaudeStream(t *testing.T) {
+	events := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":5,\"output_tokens\":1}}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"你好\"}}\n\n" +
+		"event: ping\ndata: {\"type\":\"ping\"}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"，世界\"}}\n\n"
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	chat := &ClaudeChat{BaseChat: SimpleChat{}, key: "k", url: srv.URL}
+
+	body = events + "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	stream := &ReplyStream{}
+	res, err := chat.complete(context.Background(), "claude-user", "", []ClaudeMessage{claudeText(ClaudeUser, "你好")}, stream)
+	if err != nil || res != "你好，世界" || stream.buf.String() != res {
+		t.Errorf("unexpected reply %q %v, streamed %q", res, err, stream.buf.String())
+	}
+
+	// 流式输出中途的错误按类型返回
+	body = events + "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"
+	_, err = chat.complete(context.Background(), "claude-user", "", []ClaudeMessage{claudeText(ClaudeUser, "你好")}, nil)
+	if perr := classifyError("claude", err); err == nil || perr.Kind != ErrKindUpstream || !perr.Retryable() {
+		t.Errorf("expected a retryable upstream error, got %v", err)
+	}
+}
+
+func TestClaudeImageAndPrompt(t *testing.T) {
+	oldStore, oldDb := db.StoreInstance, db.ChatDbInstance
+	db.StoreInstance = db.NewMemoryStore()
+	db.ChatDbInstance = db.NewStoreChatDb(db.StoreInstance)
+	defer func() { db.StoreInstance, db.ChatDbInstance = oldStore, oldDb }()
+	t.Setenv(config.Retry_Max_Key, "0")
+	const user = "claude-image-user"
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+
+	var gotReq ClaudeRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cat.png":
+			w.Write(png)
+		case "/note.txt":
+			w.Write([]byte("not an image"))
+		default:
+			json.NewDecoder(r.Body).Decode(&gotReq)
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"一只猫\"}}\n\n"))
+		}
+	}))
+	defer srv.Close()
+	chat := &ClaudeChat{BaseChat: SimpleChat{}, key: "k", url: srv.URL}
+
+	db.SetPrompt(user, config.Bot_Type_Claude, "你是一名动物学家")
+	ctx, wait := WithAsyncTasks(context.Background(), nil)
+	res, err := chat.reply(ctx, user, "这是什么", nil, srv.URL+"/cat.png")
+	wait()
+	if err != nil || res != "一只猫" {
+		t.Fatalf("unexpected reply %q %v", res, err)
+	}
+	if gotReq.System != "你是一名动物学家" {
+		t.Errorf("user prompt should be sent as system, got %q", gotReq.System)
+	}
+	if len(gotReq.Messages) != 1 || len(gotReq.Messages[0].Content) != 2 {
+		t.Fatalf("expected one user message with image and text, got %+v", gotReq.Messages)
+	}
+	image := gotReq.Messages[0].Content[0]
+	if image.Type != "image" || image.Source.MediaType != "image/png" || image.Source.Data != base64.StdEncoding.EncodeToString(png) {
+		t.Errorf("unexpected image block %+v", image)
+	}
+
+	list, _ := db.ChatDbInstance.GetMsgList(config.Bot_Type_Claude, user, db.DefaultSessionId)
+	if len(list) != 3 || list[0].Role != "system" || len(list[1].Parts) != 2 || list[1].Parts[0].Type != "image" || list[1].Parts[0].MIMEType != "image/png" {
+		t.Fatalf("image should be saved in history, got %+v", list)
+	}
+
+	// 历史中的图片和全部文本随下一轮对话发送
+	if _, err = chat.reply(ctx, user, "它多大了", nil); err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	if len(gotReq.Messages) != 3 || gotReq.Messages[0].Content[0].Type != "image" || gotReq.Messages[0].Content[1].Text != "这是什么" {
+		t.Errorf("history should keep the image and text, got %+v", gotReq.Messages)
+	}
+
+	_, err = chat.reply(context.Background(), user, "", nil, srv.URL+"/note.txt")
+	if perr := classifyError(config.Bot_Type_Claude, err); err == nil || perr.Kind != ErrKindBadRequest {
+		t.Errorf("unsupported image type should be a bad request, got %v", err)
+	}
+}
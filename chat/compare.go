@@ -0,0 +1,151 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+)
+
+const (
+	compareCommandHelp = "格式：/compare 问题，同时询问多个机器人并对比回答"
+	askCommandHelp     = "格式：/ask 机器人 问题，例如 /ask gpt 你好，只询问一次，不会切换机器人"
+)
+
+// compareAnswer 一个机器人的回答
+type compareAnswer struct {
+	botType string
+	reply   string
+}
+
+func (a compareAnswer) String() string {
+	return fmt.Sprintf("【%s】\n%s", botTitle(a.botType), a.reply)
+}
+
+// completerOf 获取可以单次补全的机器人，本地机器人或未配置好的机器人返回错误
+func completerOf(botType string) (Completer, error) {
+	if _, ok := GetProvider(botType); !ok || IsLocalBot(botType) {
+		return nil, fmt.Errorf("%s 不是可用的AI机器人", botType)
+	}
+	if _, err := CheckBotConfig(botType); err != nil {
+		return nil, err
+	}
+	completer, ok := GetChatBot(botType).(Completer)
+	if !ok {
+		return nil, fmt.Errorf("%s 不支持单次提问", botType)
+	}
+	return completer, nil
+}
+
+// compareBots 参与 /compare 的机器人：COMPARE_BOTS 中配置好的机器人，未配置时为所有配置好的AI机器人
+func compareBots() []string {
+	names := config.GetCompareBots()
+	if len(names) == 0 {
+		for _, p := range Providers() {
+			names = append(names, p.Name)
+		}
+	}
+	return slices.DeleteFunc(names, func(name string) bool {
+		_, err := completerOf(name)
+		return err != nil
+	})
+}
+
+// askBots 并发向多个机器人单次提问，不读取也不保存对话历史。ChatTimeout 内返回的回答按机器人顺序合并回复，
+// 超时的回答通过 deliverLateAnswers 稍后送达，超过 WX_REQUEST_TIMEOUT 仍未回答的调用会被取消
+func askBots(ctx context.Context, userId, question string, bots []string) string {
+	if reply, ok := CheckQuota(userId, bots[0]); !ok {
+		return reply
+	}
+	// 与 WithTimeChat 一样，调用只继承请求的截止时间，超时的回答仍要稍后送达
+	callCtx, cancel := detachContext(ctx)
+	results := make(chan compareAnswer, len(bots))
+	for _, botType := range bots {
+		completer, err := completerOf(botType)
+		if err != nil {
+			results <- compareAnswer{botType, err.Error()}
+			continue
+		}
+		go func() {
+			reply, err := completer.Complete(callCtx, userId, question)
+			if err != nil {
+				reply = userErrorMessage(botType, err)
+			}
+			results <- compareAnswer{botType, reply}
+		}()
+	}
+
+	answers := make(map[string]compareAnswer, len(bots))
+	timeout := time.After(ChatTimeout)
+collect:
+	for len(answers) < len(bots) {
+		select {
+		case a := <-results:
+			answers[a.botType] = a
+		case <-timeout:
+			break collect
+		}
+	}
+	var parts, pending []string
+	for _, botType := range bots {
+		if a, ok := answers[botType]; ok {
+			parts = append(parts, a.String())
+		} else {
+			pending = append(pending, botTitle(botType))
+		}
+	}
+	if len(pending) == 0 {
+		cancel()
+	} else {
+		deliverLateAnswers(ctx, userId, results, len(pending), cancel)
+		parts = append(parts, fmt.Sprintf("（%s 的回答较慢，%s）", strings.Join(pending, "、"), lateReplyHint(ctx)))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// deliverLateAnswers 等待超时的回答并送达用户，配置了客服消息时直接推送，否则追加到 /more 的剩余内容中。
+// 全部送达后调用 done 释放调用使用的 context
+func deliverLateAnswers(ctx context.Context, userId string, results <-chan compareAnswer, n int, done context.CancelFunc) {
+	sender := replySenderOf(ctx)
+	goAsync(ctx, func() {
+		defer done()
+		for i := 0; i < n; i++ {
+			chunks := SplitReply((<-results).String(), config.GetWxReplyMaxBytes())
+			if sender != nil {
+				sendChunks(sender, userId, chunks)
+				continue
+			}
+			appendMoreReply(userId, chunks)
+		}
+	})
+}
+
+// CompareCommand /compare 问题：同时询问多个机器人，不会切换机器人，也不会写入对话历史
+func CompareCommand(ctx context.Context, param, userId string) string {
+	question := strings.TrimSpace(param)
+	if question == "" {
+		return compareCommandHelp
+	}
+	bots := compareBots()
+	if len(bots) == 0 {
+		return "没有可以对比的机器人，请检查机器人配置或 COMPARE_BOTS"
+	}
+	return askBots(ctx, userId, question, bots)
+}
+
+// AskCommand /ask 机器人 问题：向指定机器人单次提问，不会切换机器人，也不会写入对话历史
+func AskCommand(ctx context.Context, param, userId string) string {
+	botType, question, _ := strings.Cut(strings.TrimSpace(param), " ")
+	botType = strings.TrimPrefix(botType, "/")
+	question = strings.TrimSpace(question)
+	if botType == "" || question == "" {
+		return askCommandHelp
+	}
+	if _, err := completerOf(botType); err != nil {
+		return err.Error()
+	}
+	return askBots(ctx, userId, question, []string{botType})
+}
@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+type compareBot struct {
+	Echo
+	reply string
+	delay time.Duration
+}
+
+func (c *compareBot) Complete(ctx context.Context, userId, prompt string) (string, error) {
+	time.Sleep(c.delay)
+	return c.reply + "：" + prompt, nil
+}
+
+func registerCompareBots() {
+	for _, bot := range []*compareBot{{reply: "快"}, {reply: "慢", delay: 200 * time.Millisecond}} {
+		name := "comparebot-" + bot.reply
+		RegisterProvider(&Provider{Name: name, Title: bot.reply, New: func() BaseChat { return bot }})
+	}
+}
+
+func TestCompareCommand(t *testing.T) {
+	registerCompareBots()
+	oldStore, oldDb := db.StoreInstance, db.ChatDbInstance
+	db.StoreInstance = db.NewMemoryStore()
+	db.ChatDbInstance = db.NewStoreChatDb(db.StoreInstance)
+	defer func() { db.StoreInstance, db.ChatDbInstance = oldStore, oldDb }()
+	t.Setenv(config.Compare_Bots_Key, "comparebot-快, comparebot-慢, keyword, unknown")
+	t.Setenv("botType", config.Bot_Type_Gpt)
+	timeout := ChatTimeout
+	defer func() { ChatTimeout = timeout }()
+	const user = "compare-user"
+
+	ChatTimeout = time.Second
+	r := CompareCommand(context.Background(), "你好", user)
+	if r != "【快】\n快：你好\n\n【慢】\n慢：你好" {
+		t.Errorf("unexpected compare reply %q", r)
+	}
+	if config.GetUserBotType(user) != config.Bot_Type_Gpt {
+		t.Error("compare should not switch the user's bot")
+	}
+	if list, _ := db.ChatDbInstance.GetMsgList("comparebot-快", user, db.DefaultSessionId); len(list) != 0 {
+		t.Errorf("compare should not write history, got %v", list)
+	}
+
+	// 超时的回答稍后推送
+	sender := &fakeSender{}
+	ctx, wait := WithAsyncTasks(context.Background(), sender)
+	ChatTimeout = 50 * time.Millisecond
+	r = CompareCommand(ctx, "在吗", user)
+	if !strings.HasPrefix(r, "【快】\n快：在吗") || !strings.Contains(r, "慢 的回答较慢，稍后推送") {
+		t.Errorf("unexpected reply before timeout %q", r)
+	}
+	wait()
+	if sender.sent[user] != "【慢】\n慢：在吗" {
+		t.Errorf("late answer should be pushed, got %q", sender.sent[user])
+	}
+}
+
+func TestAskCommand(t *testing.T) {
+	registerCompareBots()
+	if r := AskCommand(context.Background(), "comparebot-快 你是谁", "ask-user"); r != "【快】\n快：你是谁" {
+		t.Errorf("unexpected ask reply %q", r)
+	}
+	if r := AskCommand(context.Background(), "keyword 你好", "ask-user"); !strings.Contains(r, "不是可用的AI机器人") {
+		t.Errorf("local bots should be rejected, got %q", r)
+	}
+	if r := AskCommand(context.Background(), "comparebot-快", "ask-user"); r != askCommandHelp {
+		t.Errorf("expected help, got %q", r)
+	}
+}
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+	"github.com/silenceper/wechat/v2/officialaccount/message"
+)
+
+// 微信在 5 秒内未收到回复会重试，最多 3 次，去重记录只需覆盖这段时间
+const dedupExpires = time.Minute
+
+var (
+	inflightMsgs sync.Map // msgKey -> *inflightMsg
+	pollInterval = 200 * time.Millisecond
+	// dedupWait 重试请求等待原请求结果的最长时间，微信每次请求 5 秒后断开，等待更久也无法被动回复
+	dedupWait = 4 * time.Second
+)
+
+type inflightMsg struct {
+	done chan struct{}
+	res  string
+}
+
+// DedupKey 普通消息使用 MsgId，事件消息没有 MsgId，使用 FromUserName+CreateTime
+func DedupKey(msg *message.MixMessage) string {
+	if msg.MsgID != 0 {
+		return fmt.Sprintf("%d", msg.MsgID)
+	}
+	return fmt.Sprintf("%s:%d", msg.FromUserName, msg.CreateTime)
+}
+
+// HandleOnce 保证同一条消息只处理一次，微信的重试请求会加入正在进行的处理并返回其结果
+func HandleOnce(msgKey string, f func() string) string {
+	flight := &inflightMsg{done: make(chan struct{})}
+	if v, loaded := inflightMsgs.LoadOrStore(msgKey, flight); loaded {
+		// 同一实例上已有请求在处理该消息
+		return waitInflight(v.(*inflightMsg))
+	}
+
+	// f 发生 panic 时也要通知等待中的重试请求，避免其一直等到超时
+	defer finishInflight(msgKey, flight)
+
+	locked, err := db.TryLockMsg(msgKey, dedupExpires)
+	if err == nil && !locked {
+		// 其它实例正在处理该消息，轮询其结果
+		flight.res = pollMsgResult(msgKey)
+		return flight.res
+	}
+
+	flight.res = f()
+	if err == nil {
+		if err = db.SetMsgResult(msgKey, flight.res, dedupExpires); err != nil {
+			fmt.Println("save msg result error:", err)
+		}
+	}
+	return flight.res
+}
+
+func waitInflight(flight *inflightMsg) string {
+	select {
+	case <-flight.done:
+		return flight.res
+	case <-time.After(dedupWait):
+		// 原请求仍未完成，回复交给原请求处理
+		return ""
+	}
+}
+
+func pollMsgResult(msgKey string) string {
+	deadline := time.Now().Add(dedupWait)
+	for time.Now().Before(deadline) {
+		res, ok, err := db.GetMsgResult(msgKey)
+		if err != nil {
+			return ""
+		}
+		if ok {
+			return res
+		}
+		time.Sleep(pollInterval)
+	}
+	return ""
+}
+
+// finishInflight 通知等待中的重试请求，并保留结果一段时间供稍后到达的重试使用
+func finishInflight(msgKey string, flight *inflightMsg) {
+	close(flight.done)
+	time.AfterFunc(dedupExpires, func() {
+		inflightMsgs.CompareAndDelete(msgKey, flight)
+	})
+}
@@ -0,0 +1,72 @@
+package chat
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/silenceper/wechat/v2/officialaccount/message"
+)
+
+func TestDedupKey(t *testing.T) {
+	msg := &message.MixMessage{}
+	msg.MsgID = 123
+	if key := DedupKey(msg); key != "123" {
+		t.Errorf("unexpected key %q", key)
+	}
+
+	event := &message.MixMessage{}
+	event.FromUserName = "user1"
+	event.CreateTime = 1700000000
+	if key := DedupKey(event); key != "user1:1700000000" {
+		t.Errorf("unexpected key %q", key)
+	}
+}
+
+func TestHandleOnce(t *testing.T) {
+	var calls int32
+	f := func() string {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		return "reply"
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = HandleOnce("test-msg", f)
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected handler to run once, ran %d times", calls)
+	}
+	for _, res := range results {
+		if res != "reply" {
+			t.Errorf("expected retries to share the reply, got %q", res)
+		}
+	}
+
+	// 处理完成后到达的重试同样复用结果
+	if res := HandleOnce("test-msg", f); res != "reply" || calls != 1 {
+		t.Errorf("late retry got %q, calls=%d", res, calls)
+	}
+}
+
+func TestHandleOncePanic(t *testing.T) {
+	func() {
+		defer func() { recover() }()
+		HandleOnce("test-panic-msg", func() string { panic("boom") })
+	}()
+
+	// 原请求 panic 后，重试请求不应等到超时
+	start := time.Now()
+	if res := HandleOnce("test-panic-msg", func() string { return "reply" }); res != "" || time.Since(start) > time.Second {
+		t.Errorf("retry after panic got %q in %v", res, time.Since(start))
+	}
+}
@@ -1,6 +1,22 @@
 package chat
 
-import "github.com/silenceper/wechat/v2/officialaccount/message"
+import (
+	"context"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/silenceper/wechat/v2/officialaccount/message"
+)
+
+func init() {
+	RegisterProvider(&Provider{
+		Name:  config.Bot_Type_Echo,
+		Title: "Echo",
+		Local: true,
+		New: func() BaseChat {
+			return &Echo{}
+		},
+	})
+}
 
 type Echo struct{}
 
@@ -8,6 +24,6 @@ func (e *Echo) HandleMediaMsg(msg *message.MixMessage) string {
 	return "不支持的消息类型"
 }
 
-func (e *Echo) Chat(userId string, msg string, imageURL ...string) string {
+func (e *Echo) Chat(ctx context.Context, userId string, msg string, imageURL ...string) string {
 	return msg
 }
\ No newline at end of file
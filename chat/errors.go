@@ -0,0 +1,218 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrorKind 机器人调用失败的类型，决定是否重试、是否计入熔断以及回复给用户的提示
+type ErrorKind string
+
+const (
+	ErrKindAuth          ErrorKind = "auth"           // 密钥无效或没有权限
+	ErrKindRateLimit     ErrorKind = "rate_limit"     // 请求太频繁
+	ErrKindQuota         ErrorKind = "quota"          // 接口额度或余额用完
+	ErrKindContentFilter ErrorKind = "content_filter" // 内容未通过审核
+	ErrKindTimeout       ErrorKind = "timeout"        // 请求超时
+	ErrKindBadRequest    ErrorKind = "bad_request"    // 请求有误，例如上下文过长
+	ErrKindUpstream      ErrorKind = "upstream"       // 接口服务异常
+)
+
+// ProviderError 机器人调用失败的错误，Err 中的原始错误只打印到日志，回复给用户的是 UserMessage
+type ProviderError struct {
+	Kind    ErrorKind
+	BotType string
+	Status  int // http 状态码，未知时为 0
+	Err     error
+}
+
+func (e *ProviderError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("%s %s error (status %d): %v", e.BotType, e.Kind, e.Status, e.Err)
+	}
+	return fmt.Sprintf("%s %s error: %v", e.BotType, e.Kind, e.Err)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable 限流、超时和接口异常可以重试
+func (e *ProviderError) Retryable() bool {
+	return e.Kind == ErrKindRateLimit || e.Kind == ErrKindTimeout || e.Kind == ErrKindUpstream
+}
+
+// ProviderFault 是否是机器人自身的问题，内容审核和请求有误与消息有关，不计入熔断也不故障转移
+func (e *ProviderError) ProviderFault() bool {
+	return e.Kind != ErrKindContentFilter && e.Kind != ErrKindBadRequest
+}
+
+// UserMessage 回复给用户的提示
+func (e *ProviderError) UserMessage() string {
+	title := botTitle(e.BotType)
+	switch e.Kind {
+	case ErrKindAuth:
+		return fmt.Sprintf("%s 的接口密钥无效或没有权限，请联系管理员检查配置", title)
+	case ErrKindRateLimit:
+		return fmt.Sprintf("%s 当前请求太多，请稍后再试", title)
+	case ErrKindQuota:
+		return fmt.Sprintf("%s 的接口额度已用完，请联系管理员", title)
+	case ErrKindContentFilter:
+		return fmt.Sprintf("内容未通过 %s 的安全审核，请换个说法试试", title)
+	case ErrKindTimeout:
+		return fmt.Sprintf("%s 响应超时，请稍后再试", title)
+	case ErrKindBadRequest:
+		return fmt.Sprintf("%s 无法处理这条消息，可以换个说法或发送 /clear 清除对话后重试", title)
+	default:
+		return fmt.Sprintf("%s 服务暂时不可用，请稍后再试", title)
+	}
+}
+
+// 按错误信息中的关键字判断类型，接口返回的错误码各不相同，这里只列出常见的，按顺序匹配
+var errorKeywords = []struct {
+	kind     ErrorKind
+	keywords []string
+}{
+	{ErrKindContentFilter, []string{"content_filter", "content_policy", "data_inspection_failed", "inappropriate content", "审核", "敏感", "safety", "blocked"}},
+	{ErrKindRateLimit, []string{"rate_limit", "rate limit", "too many requests", "throttl", "resource_exhausted", "流控", "频率"}},
+	{ErrKindQuota, []string{"insufficient_quota", "quota", "billing", "balance", "arrearage", "credit", "额度", "余额"}},
+	{ErrKindAuth, []string{"invalid_api_key", "api key not valid", "invalid x-api-key", "unauthorized", "permission_denied", "authentication", "鉴权", "授权"}},
+	{ErrKindTimeout, []string{"timeout", "deadline_exceeded", "deadline exceeded", "超时"}},
+	{ErrKindBadRequest, []string{"context_length_exceeded", "maximum context length", "invalid_argument", "invalid_request", "too long", "过长"}},
+}
+
+func kindFromMessage(msg string) (ErrorKind, bool) {
+	msg = strings.ToLower(msg)
+	for _, k := range errorKeywords {
+		for _, keyword := range k.keywords {
+			if strings.Contains(msg, keyword) {
+				return k.kind, true
+			}
+		}
+	}
+	return "", false
+}
+
+// kindFromStatus 按 http 状态码判断类型。同一状态码可能有不同的原因，例如 429 可能是限流也可能是额度不足，
+// 400 可能是审核不通过，因此 4xx 时优先使用错误信息中的关键字
+func kindFromStatus(status int, msg string) ErrorKind {
+	if status >= 400 && status < 500 {
+		if kind, ok := kindFromMessage(msg); ok {
+			return kind
+		}
+	}
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrKindAuth
+	case status == http.StatusPaymentRequired:
+		return ErrKindQuota
+	case status == http.StatusTooManyRequests:
+		return ErrKindRateLimit
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return ErrKindTimeout
+	case status >= 400 && status < 500:
+		return ErrKindBadRequest
+	default:
+		return ErrKindUpstream
+	}
+}
+
+// newHTTPError 接口返回非 200 状态码时的错误，body 只打印到日志
+func newHTTPError(botType string, status int, body []byte) *ProviderError {
+	msg := strings.TrimSpace(string(body))
+	return &ProviderError{Kind: kindFromStatus(status, msg), BotType: botType, Status: status, Err: errors.New(msg)}
+}
+
+// classifyError 将机器人返回的错误转换为 ProviderError
+func classifyError(botType string, err error) *ProviderError {
+	var perr *ProviderError
+	if errors.As(err, &perr) {
+		return perr
+	}
+	res := &ProviderError{Kind: ErrKindUpstream, BotType: botType, Err: err}
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	var blocked *genai.BlockedError
+	// gemini 接口的错误实现了 HTTPCode
+	var httpErr interface{ HTTPCode() int }
+	var netErr net.Error
+	switch {
+	case errors.As(err, &apiErr):
+		res.Status = apiErr.HTTPStatusCode
+		res.Kind = kindFromStatus(apiErr.HTTPStatusCode, fmt.Sprintf("%v %s %s", apiErr.Code, apiErr.Type, apiErr.Message))
+	case errors.As(err, &reqErr):
+		res.Status = reqErr.HTTPStatusCode
+		res.Kind = kindFromStatus(reqErr.HTTPStatusCode, err.Error())
+	case errors.As(err, &blocked):
+		res.Kind = ErrKindContentFilter
+	case errors.As(err, &httpErr) && httpErr.HTTPCode() > 0:
+		res.Status = httpErr.HTTPCode()
+		res.Kind = kindFromStatus(res.Status, err.Error())
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) || errors.As(err, &netErr) && netErr.Timeout():
+		res.Kind = ErrKindTimeout
+	default:
+		if kind, ok := kindFromMessage(err.Error()); ok {
+			res.Kind = kind
+		}
+	}
+	return res
+}
+
+// userErrorMessage 记录原始错误，返回回复给用户的提示
+func userErrorMessage(botType string, err error) string {
+	perr := classifyError(botType, err)
+	fmt.Printf("bot %s error: %v\n", botType, perr)
+	return perr.UserMessage()
+}
+
+// retryWait 等待 d 后返回 true，ctx 先结束时返回 false，便于测试时替换等待
+var retryWait = func(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// withRetry 调用失败且错误可以重试时，按带随机抖动的指数退避重试，最多重试 RETRY_MAX 次，ctx 结束后不再重试
+func withRetry(ctx context.Context, botType string, f func() (string, error)) (string, error) {
+	maxRetries, base := config.GetRetryMax(), config.GetRetryBaseDelay()
+	for attempt := 0; ; attempt++ {
+		res, err := f()
+		if err == nil {
+			return res, nil
+		}
+		perr := classifyError(botType, err)
+		if !perr.Retryable() || attempt >= maxRetries || ctx.Err() != nil {
+			return "", perr
+		}
+		fmt.Printf("bot %s attempt %d failed, retrying: %v\n", botType, attempt+1, perr)
+		if !retryWait(ctx, backoff(base, attempt)) {
+			return "", perr
+		}
+	}
+}
+
+// backoff 第 attempt 次重试前的等待时间，在 [0, base*2^attempt] 之间随机，最多 maxRetryDelay
+func backoff(base time.Duration, attempt int) time.Duration {
+	d := min(base<<attempt, maxRetryDelay)
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+const maxRetryDelay = 5 * time.Second
@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{&openai.APIError{HTTPStatusCode: 401, Message: "Incorrect API key provided"}, ErrKindAuth},
+		{&openai.APIError{HTTPStatusCode: 429, Code: "rate_limit_exceeded", Message: "Rate limit reached"}, ErrKindRateLimit},
+		{&openai.APIError{HTTPStatusCode: 429, Type: "insufficient_quota", Message: "You exceeded your current quota"}, ErrKindQuota},
+		{&openai.APIError{HTTPStatusCode: 400, Code: "context_length_exceeded"}, ErrKindBadRequest},
+		{&openai.APIError{HTTPStatusCode: 400, Code: "data_inspection_failed", Message: "Input data may contain inappropriate content."}, ErrKindContentFilter},
+		{&openai.RequestError{HTTPStatusCode: 502, Err: errors.New("bad gateway")}, ErrKindUpstream},
+		{&genai.BlockedError{}, ErrKindContentFilter},
+		{newHTTPError("claude", 529, []byte(`{"type":"error","error":{"type":"overloaded_error"}}`)), ErrKindUpstream},
+		{newHTTPError("qwen", 400, []byte(`{"code":"Arrearage","message":"Access denied, please make sure your account is in good standing."}`)), ErrKindQuota},
+		{fmt.Errorf("client.Do failed,err:%w", context.DeadlineExceeded), ErrKindTimeout},
+		{sparkError(&SparkResponseHeader{Code: 10013, Message: "input content audit failed"}), ErrKindContentFilter},
+		{sparkError(&SparkResponseHeader{Code: 11202}), ErrKindRateLimit},
+		{errors.New("unexpected EOF"), ErrKindUpstream},
+	}
+	for _, c := range cases {
+		if kind := classifyError("gpt", c.err).Kind; kind != c.kind {
+			t.Errorf("%v: expected %s, got %s", c.err, c.kind, kind)
+		}
+	}
+}
+
+func TestUserErrorMessage(t *testing.T) {
+	err := &openai.APIError{HTTPStatusCode: 401, Message: "Incorrect API key provided: sk-xxx"}
+	if msg := userErrorMessage("gpt", err); msg != "GPT 的接口密钥无效或没有权限，请联系管理员检查配置" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	oldWait := retryWait
+	var delays []time.Duration
+	retryWait = func(ctx context.Context, d time.Duration) bool {
+		delays = append(delays, d)
+		return true
+	}
+	defer func() { retryWait = oldWait }()
+	t.Setenv(config.Retry_Max_Key, "2")
+	t.Setenv(config.Retry_Base_Delay_Key, "100")
+
+	calls := 0
+	res, err := withRetry(context.Background(), "gpt", func() (string, error) {
+		calls++
+		if calls < 3 {
+			return "", newHTTPError("gpt", 429, nil)
+		}
+		return "ok", nil
+	})
+	if res != "ok" || err != nil || calls != 3 {
+		t.Fatalf("expected success after 2 retries, got %q %v after %d calls", res, err, calls)
+	}
+	for i, d := range delays {
+		if d < 0 || d > 100*time.Millisecond<<i {
+			t.Errorf("retry %d waited %v", i+1, d)
+		}
+	}
+
+	// 不可重试的错误直接返回
+	calls = 0
+	_, err = withRetry(context.Background(), "gpt", func() (string, error) {
+		calls++
+		return "", newHTTPError("gpt", 401, nil)
+	})
+	var perr *ProviderError
+	if calls != 1 || !errors.As(err, &perr) || perr.Kind != ErrKindAuth {
+		t.Errorf("auth error should not be retried, got %v after %d calls", err, calls)
+	}
+
+	// 超过重试次数后返回最后的错误
+	calls = 0
+	if _, err = withRetry(context.Background(), "gpt", func() (string, error) {
+		calls++
+		return "", errors.New("connection reset")
+	}); calls != 3 || err == nil {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
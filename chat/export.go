@@ -0,0 +1,292 @@
+package chat
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	ExportFormatMarkdown = "md"
+	ExportFormatText     = "txt"
+
+	// historyExportVersion 管理员导出的 json 格式版本
+	historyExportVersion = 1
+
+	exportCommandHelp = "格式：/export [md|txt]，配置了 EXPORT_SECRET 时回复下载链接，否则直接分段回复对话记录"
+)
+
+var (
+	ErrExportTokenInvalid = errors.New("导出链接无效")
+	ErrExportTokenExpired = errors.New("导出链接已过期")
+	ErrHistoryEmpty       = errors.New("当前会话没有对话记录")
+)
+
+// ExportClaims 导出链接中签名的内容
+type ExportClaims struct {
+	UserId    string `json:"u"`
+	BotType   string `json:"b"`
+	SessionId string `json:"s"`
+	Format    string `json:"f"`
+	ExpireAt  int64  `json:"e"`
+}
+
+// NewExportToken 生成导出链接的 token，格式为 base64(json).base64(hmac-sha256)
+func NewExportToken(claims ExportClaims, secret string) (string, error) {
+	if secret == "" {
+		return "", fmt.Errorf("未配置 %s", config.Export_Secret_Key)
+	}
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + signExport(payload, secret), nil
+}
+
+// ParseExportToken 校验导出链接的签名和有效期
+func ParseExportToken(token, secret string) (*ExportClaims, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || secret == "" || !hmac.Equal([]byte(sig), []byte(signExport(payload, secret))) {
+		return nil, ErrExportTokenInvalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrExportTokenInvalid
+	}
+	var claims ExportClaims
+	if err = json.Unmarshal(raw, &claims); err != nil {
+		return nil, ErrExportTokenInvalid
+	}
+	if time.Now().Unix() > claims.ExpireAt {
+		return nil, ErrExportTokenExpired
+	}
+	return &claims, nil
+}
+
+func signExport(payload, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// exportLink 生成当前会话的导出链接，未配置密钥或域名时返回 false
+func exportLink(claims ExportClaims) (string, bool) {
+	secret, baseUrl := config.GetExportSecret(), config.GetExportBaseUrl()
+	if secret == "" || baseUrl == "" {
+		return "", false
+	}
+	token, err := NewExportToken(claims, secret)
+	if err != nil {
+		fmt.Println("create export token error:", err)
+		return "", false
+	}
+	return baseUrl + "/api/export?token=" + url.QueryEscape(token), true
+}
+
+// ExportCommand /export [md|txt]：导出当前机器人当前会话的对话记录
+func ExportCommand(param, userId string) string {
+	format := strings.ToLower(strings.TrimSpace(param))
+	switch format {
+	case "":
+	case ExportFormatMarkdown, "markdown":
+		format = ExportFormatMarkdown
+	case ExportFormatText, "text":
+		format = ExportFormatText
+	default:
+		return exportCommandHelp
+	}
+	botType := config.GetUserBotType(userId)
+	sessionId := activeSession(userId)
+
+	// 链接默认导出 Markdown 文件，直接回复时默认纯文本，便于在微信中阅读
+	linkFormat := format
+	if linkFormat == "" {
+		linkFormat = ExportFormatMarkdown
+	}
+	if _, err := RenderHistory(botType, userId, sessionId, linkFormat); err != nil {
+		return fmt.Sprintf("导出失败：%v", err)
+	}
+	ttl := config.GetExportLinkTTL()
+	claims := ExportClaims{UserId: userId, BotType: botType, SessionId: sessionId, Format: linkFormat, ExpireAt: time.Now().Add(ttl).Unix()}
+	if link, ok := exportLink(claims); ok {
+		return fmt.Sprintf("对话记录导出链接(%d分钟内有效)：\n%s", int(ttl.Minutes()), link)
+	}
+
+	if format == "" {
+		format = ExportFormatText
+	}
+	// 超长的导出内容由 ShapeReply 分段回复
+	text, err := RenderHistory(botType, userId, sessionId, format)
+	if err != nil {
+		return fmt.Sprintf("导出失败：%v", err)
+	}
+	return text
+}
+
+// RenderHistory 将会话的对话记录渲染为 Markdown 或纯文本
+func RenderHistory(botType, userId, sessionId, format string) (string, error) {
+	chatDb, err := db.GetChatDb()
+	if err != nil {
+		return "", err
+	}
+	list, err := chatDb.GetMsgList(botType, userId, sessionId)
+	if err != nil {
+		return "", err
+	}
+	list = slices.DeleteFunc(list, func(m db.Msg) bool { return m.Role == "system" })
+	summary, _ := chatDb.GetSummary(botType, userId, sessionId)
+	if len(list) == 0 && summary == "" {
+		return "", ErrHistoryEmpty
+	}
+
+	title := botTitle(botType)
+	sessionName := sessionId
+	if sessions, err := db.GetSessions(userId); err == nil {
+		if idx := slices.IndexFunc(sessions, func(s *db.Session) bool { return s.Id == sessionId }); idx >= 0 {
+			sessionName = sessions[idx].Name
+		}
+	}
+	exportedAt := time.Now().Format("2006-01-02 15:04")
+
+	var sb strings.Builder
+	markdown := format == ExportFormatMarkdown
+	if markdown {
+		sb.WriteString(fmt.Sprintf("# 对话记录\n\n- 机器人：%s\n- 会话：%s\n- 导出时间：%s\n\n", title, sessionName, exportedAt))
+		if summary != "" {
+			sb.WriteString("> 较早对话的摘要：" + strings.ReplaceAll(summary, "\n", "\n> ") + "\n\n")
+		}
+	} else {
+		sb.WriteString(fmt.Sprintf("对话记录\n机器人：%s\n会话：%s\n导出时间：%s\n\n", title, sessionName, exportedAt))
+		if summary != "" {
+			sb.WriteString("较早对话的摘要：" + summary + "\n\n")
+		}
+	}
+	for _, msg := range list {
+		speaker := title
+		if msg.Role == "user" {
+			speaker = "用户"
+		}
+		var texts []string
+		for _, part := range msg.Parts {
+			if part.Type == "text" {
+				texts = append(texts, part.Data)
+			} else {
+				// 图片以 base64 保存，导出时只保留占位
+				texts = append(texts, imagePlaceholder)
+			}
+		}
+		if markdown {
+			sb.WriteString(fmt.Sprintf("**%s**：\n\n%s\n\n", speaker, strings.Join(texts, "\n\n")))
+		} else {
+			sb.WriteString(fmt.Sprintf("%s：\n%s\n\n", speaker, strings.Join(texts, "\n")))
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n"), nil
+}
+
+// HistoryExport 用户在所有会话和机器人上的对话记录，用于在部署之间迁移
+type HistoryExport struct {
+	Version       int             `json:"version"`
+	UserId        string          `json:"user_id"`
+	ExportedAt    int64           `json:"exported_at"`
+	ActiveSession string          `json:"active_session,omitempty"`
+	Sessions      []SessionExport `json:"sessions"`
+}
+
+type SessionExport struct {
+	db.Session
+	Histories []BotHistory `json:"histories,omitempty"`
+}
+
+type BotHistory struct {
+	BotType  string   `json:"bot_type"`
+	Summary  string   `json:"summary,omitempty"`
+	Messages []db.Msg `json:"messages"`
+}
+
+// ExportUserHistory 导出用户的全部对话记录，没有对话记录的机器人不导出
+func ExportUserHistory(userId string) (*HistoryExport, error) {
+	chatDb, err := db.GetChatDb()
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := db.GetSessions(userId)
+	if err != nil {
+		return nil, err
+	}
+	res := &HistoryExport{
+		Version:       historyExportVersion,
+		UserId:        userId,
+		ExportedAt:    time.Now().Unix(),
+		ActiveSession: activeSession(userId),
+		Sessions:      make([]SessionExport, 0, len(sessions)),
+	}
+	for _, session := range sessions {
+		se := SessionExport{Session: *session}
+		for _, botType := range config.SupportBots() {
+			list, err := chatDb.GetMsgList(botType, userId, session.Id)
+			if err != nil {
+				return nil, err
+			}
+			summary, _ := chatDb.GetSummary(botType, userId, session.Id)
+			if len(list) == 0 && summary == "" {
+				continue
+			}
+			se.Histories = append(se.Histories, BotHistory{BotType: botType, Summary: summary, Messages: list})
+		}
+		res.Sessions = append(res.Sessions, se)
+	}
+	return res, nil
+}
+
+// ImportUserHistory 导入 ExportUserHistory 导出的对话记录，userId 为空时导入到导出时的用户。
+// 同一会话同一机器人的对话记录会被覆盖，导入的对话记录按 MSG_TIME 过期
+func ImportUserHistory(userId string, data *HistoryExport) (histories int, err error) {
+	if data.Version != historyExportVersion {
+		return 0, fmt.Errorf("不支持的导出版本：%d", data.Version)
+	}
+	if userId == "" {
+		userId = data.UserId
+	}
+	if userId == "" {
+		return 0, errors.New("缺少用户openid")
+	}
+	chatDb, err := db.GetChatDb()
+	if err != nil {
+		return 0, err
+	}
+	for _, se := range data.Sessions {
+		if se.Id == "" {
+			return histories, errors.New("会话id不能为空")
+		}
+		session := se.Session
+		if err = db.SaveSession(userId, &session); err != nil {
+			return histories, err
+		}
+		if err = db.ReserveSessionId(userId, se.Id); err != nil {
+			return histories, err
+		}
+		for _, h := range se.Histories {
+			chatDb.SetMsgList(h.BotType, userId, se.Id, h.Messages)
+			if h.Summary != "" {
+				chatDb.SetSummary(h.BotType, userId, se.Id, h.Summary)
+			}
+			histories++
+		}
+	}
+	if data.ActiveSession != "" && slices.ContainsFunc(data.Sessions, func(s SessionExport) bool { return s.Id == data.ActiveSession }) {
+		err = db.SetActiveSession(userId, data.ActiveSession)
+	}
+	return histories, err
+}
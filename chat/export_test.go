@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func TestExportToken(t *testing.T) {
+	claims := ExportClaims{UserId: "u", BotType: "gpt", SessionId: "s1", Format: ExportFormatMarkdown, ExpireAt: time.Now().Add(time.Minute).Unix()}
+	token, err := NewExportToken(claims, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ParseExportToken(token, "secret"); err != nil || *got != claims {
+		t.Errorf("expected %+v, got %+v, %v", claims, got, err)
+	}
+	if _, err := ParseExportToken(token, "other"); !errors.Is(err, ErrExportTokenInvalid) {
+		t.Errorf("token signed with another secret should be invalid, got %v", err)
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	if _, err := ParseExportToken(payload+"x."+sig, "secret"); !errors.Is(err, ErrExportTokenInvalid) {
+		t.Errorf("tampered token should be invalid, got %v", err)
+	}
+	claims.ExpireAt = time.Now().Add(-time.Second).Unix()
+	token, _ = NewExportToken(claims, "secret")
+	if _, err := ParseExportToken(token, "secret"); !errors.Is(err, ErrExportTokenExpired) {
+		t.Errorf("expected expired error, got %v", err)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	oldStore, oldDb := db.StoreInstance, db.ChatDbInstance
+	db.StoreInstance = db.NewMemoryStore()
+	db.ChatDbInstance = db.NewStoreChatDb(db.StoreInstance)
+	defer func() { db.StoreInstance, db.ChatDbInstance = oldStore, oldDb }()
+	t.Setenv("botType", config.Bot_Type_Gpt)
+	t.Setenv(config.Export_Secret_Key, "")
+	const user = "export-user"
+
+	if r := ExportCommand("", user); !strings.Contains(r, ErrHistoryEmpty.Error()) {
+		t.Errorf("expected empty history reply, got %q", r)
+	}
+	db.ChatDbInstance.SetMsgList(config.Bot_Type_Gpt, user, db.DefaultSessionId, []db.Msg{
+		textMsg("system", "你是助手"),
+		textMsg("user", "你好"),
+		{Role: "assistant", Parts: []db.ContentPart{{Type: "text", Data: "你好！"}, {Type: "image", Data: "base64"}}},
+	})
+	r := ExportCommand("", user)
+	if !strings.Contains(r, "用户：\n你好") || !strings.Contains(r, "你好！\n[图片]") || strings.Contains(r, "你是助手") {
+		t.Errorf("unexpected text export %q", r)
+	}
+	if r = ExportCommand("md", user); !strings.HasPrefix(r, "# 对话记录") || !strings.Contains(r, "**用户**：\n\n你好") {
+		t.Errorf("unexpected markdown export %q", r)
+	}
+
+	t.Setenv(config.Export_Secret_Key, "secret")
+	t.Setenv(config.Export_Base_Url_Key, "https://example.com/")
+	r = ExportCommand("", user)
+	_, link, ok := strings.Cut(r, "https://example.com/api/export?token=")
+	if !ok {
+		t.Fatalf("expected export link, got %q", r)
+	}
+	claims, err := ParseExportToken(link, "secret")
+	if err != nil || claims.UserId != user || claims.Format != ExportFormatMarkdown {
+		t.Errorf("unexpected claims %+v, %v", claims, err)
+	}
+}
+
+func TestExportImportUserHistory(t *testing.T) {
+	oldStore, oldDb := db.StoreInstance, db.ChatDbInstance
+	db.StoreInstance = db.NewMemoryStore()
+	db.ChatDbInstance = db.NewStoreChatDb(db.StoreInstance)
+	defer func() { db.StoreInstance, db.ChatDbInstance = oldStore, oldDb }()
+	const user = "history-user"
+
+	NewSessionCommand("工作", user)
+	work := activeSession(user)
+	db.ChatDbInstance.SetMsgList(config.Bot_Type_Gpt, user, db.DefaultSessionId, []db.Msg{textMsg("user", "个人")})
+	db.ChatDbInstance.SetMsgList(config.Bot_Type_Gpt, user, work, []db.Msg{textMsg("user", "工作")})
+	db.ChatDbInstance.SetSummary(config.Bot_Type_Gpt, user, work, "摘要")
+	data, err := ExportUserHistory(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Sessions) != 2 || data.ActiveSession != work {
+		t.Fatalf("unexpected export %+v", data)
+	}
+
+	// 导入到新的部署
+	db.StoreInstance = db.NewMemoryStore()
+	db.ChatDbInstance = db.NewStoreChatDb(db.StoreInstance)
+	histories, err := ImportUserHistory("", data)
+	if err != nil || histories != 2 {
+		t.Fatalf("expected 2 histories imported, got %d, %v", histories, err)
+	}
+	if activeSession(user) != work {
+		t.Error("active session should be restored")
+	}
+	list, _ := db.ChatDbInstance.GetMsgList(config.Bot_Type_Gpt, user, work)
+	summary, _ := db.ChatDbInstance.GetSummary(config.Bot_Type_Gpt, user, work)
+	if len(list) != 1 || list[0].Parts[0].Data != "工作" || summary != "摘要" {
+		t.Errorf("unexpected imported history %v, summary %q", list, summary)
+	}
+	if r := ListSessionsCommand("", user); !strings.Contains(r, "2. 工作 [当前]") {
+		t.Errorf("unexpected sessions %q", r)
+	}
+	if id, _ := db.NewSessionId(user); id == work {
+		t.Errorf("new session id %s should not reuse imported id", id)
+	}
+}
@@ -0,0 +1,215 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+// replier 读写对话历史并返回回复的机器人，调用失败时返回错误，故障转移依赖它判断是否需要尝试下一个机器人。
+// ctx 取消时应尽快返回错误，且不写入对话历史。stream 不为 nil 时边生成边写入增量文本
+type replier interface {
+	reply(ctx context.Context, userId, msg string, stream *ReplyStream, imageURL ...string) (string, error)
+}
+
+// failoverChain 机器人及其故障转移链，去掉重复的机器人
+func failoverChain(botType string) []string {
+	chain := []string{botType}
+	for _, name := range config.GetFailoverChains()[botType] {
+		if !slices.Contains(chain, name) {
+			chain = append(chain, name)
+		}
+	}
+	return chain
+}
+
+// fallbackReplier 获取故障转移链中的机器人，未配置好、本地机器人或不支持本次消息中的图片时返回 false
+func fallbackReplier(botType string, hasImage bool) (replier, bool) {
+	if IsLocalBot(botType) || (hasImage && !GetCapabilities(botType).Image) {
+		return nil, false
+	}
+	if _, ok := GetProvider(botType); !ok {
+		return nil, false
+	}
+	if _, err := CheckBotConfig(botType); err != nil {
+		return nil, false
+	}
+	r, ok := GetChatBot(botType).(replier)
+	return r, ok
+}
+
+// chatWithFailover 调用用户当前的机器人，失败、超时或熔断中时按 FAILOVER_CHAINS 依次尝试下一个机器人。
+// 接替的机器人使用自己的对话历史，回复前会注明由哪个机器人回答；全部失败时返回当前机器人的错误提示。
+// 内容审核不通过或请求有误与消息本身有关，换机器人也无济于事，直接返回提示。ctx 结束后不再尝试下一个机器人
+func chatWithFailover(ctx context.Context, botType string, primary replier, userId, msg string, stream *ReplyStream, imageURL ...string) string {
+	hasImage := len(imageURL) > 0 && imageURL[0] != ""
+	var firstErr *ProviderError
+	for i, name := range failoverChain(botType) {
+		if ctx.Err() != nil {
+			break
+		}
+		bot := primary
+		if i > 0 {
+			var ok bool
+			if bot, ok = fallbackReplier(name, hasImage); !ok {
+				continue
+			}
+		}
+		if !breakerAllow(name) {
+			if firstErr == nil {
+				firstErr = &ProviderError{Kind: ErrKindUpstream, BotType: name, Err: errors.New("circuit breaker open")}
+			}
+			continue
+		}
+		label := ""
+		if i > 0 {
+			label = fmt.Sprintf("（%s 暂时不可用，以下由 %s 回答）\n", botTitle(botType), botTitle(name))
+		}
+		reply, err := replyWithTimeout(ctx, name, bot, userId, msg, stream, label, imageURL...)
+		if err != nil {
+			perr := classifyError(name, err)
+			fmt.Printf("bot %s reply error: %v\n", name, perr)
+			if !perr.ProviderFault() {
+				return perr.UserMessage()
+			}
+			breakerFailure(name, perr)
+			if firstErr == nil {
+				firstErr = perr
+			}
+			continue
+		}
+		breakerSuccess(name)
+		return label + reply
+	}
+	if firstErr == nil {
+		firstErr = &ProviderError{Kind: ErrKindTimeout, BotType: botType, Err: ctx.Err()}
+	}
+	return firstErr.UserMessage()
+}
+
+// replyWithTimeout 调用机器人，可重试的错误按 RETRY_MAX 重试。重试也计入 FAILOVER_TIMEOUT，超时后取消调用并视为失败。
+// 每次调用都从 label 开始重新流式输出，失败的调用已输出的文本会被丢弃
+func replyWithTimeout(ctx context.Context, botType string, bot replier, userId, msg string, stream *ReplyStream, label string, imageURL ...string) (string, error) {
+	if timeout := config.GetFailoverTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	reply, err := withRetry(ctx, botType, func() (string, error) {
+		stream.Reset()
+		stream.Write(label)
+		reply, err := bot.reply(ctx, userId, msg, stream, imageURL...)
+		if err != nil {
+			stream.Reset()
+		}
+		return reply, err
+	})
+	if err != nil && ctx.Err() != nil {
+		// 取消后接口返回的错误各不相同，统一视为超时
+		return "", &ProviderError{Kind: ErrKindTimeout, BotType: botType, Err: err}
+	}
+	return reply, err
+}
+
+// breakerAllow 熔断中的机器人在冷却结束前不会被调用，冷却结束后放行请求试探是否恢复，再次失败会立即重新熔断
+func breakerAllow(botType string) bool {
+	if config.GetBreakerThreshold() == 0 {
+		return true
+	}
+	state, err := db.GetBreakerState(botType)
+	if err != nil || state == nil {
+		return true
+	}
+	return time.Now().Unix() >= state.OpenUntil
+}
+
+// breakerFailure 记录失败，只保存错误类型和状态码，接口返回的原始错误只打印到日志
+func breakerFailure(botType string, cause *ProviderError) {
+	threshold := config.GetBreakerThreshold()
+	if threshold == 0 {
+		return
+	}
+	now := time.Now()
+	err := db.UpdateBreakerState(botType, func(state *db.BreakerState) {
+		state.Failures++
+		state.LastErrorKind = string(cause.Kind)
+		state.LastStatus = cause.Status
+		state.LastFailureAt = now.Unix()
+		if state.Failures >= threshold {
+			state.OpenUntil = now.Add(config.GetBreakerCooldown()).Unix()
+		}
+	})
+	if err != nil && err != db.ErrStoreNil {
+		fmt.Println("update breaker state error:", err)
+	}
+}
+
+func breakerSuccess(botType string) {
+	if state, err := db.GetBreakerState(botType); err == nil && state != nil {
+		db.ResetBreakerState(botType)
+	}
+}
+
+// ProviderHealth 机器人的健康状态，用于 /api/check
+type ProviderHealth struct {
+	BotType  string
+	Healthy  bool
+	Failures int
+	// RetryIn 熔断中时距离恢复调用的时间
+	RetryIn time.Duration
+	// LastError 最近一次失败的错误类型，/api/check 不需要认证，不展示接口返回的原始错误
+	LastError  ErrorKind
+	LastStatus int
+	Failover   []string
+}
+
+func (h ProviderHealth) String() string {
+	var sb strings.Builder
+	if h.Healthy {
+		sb.WriteString("healthy")
+	} else {
+		sb.WriteString(fmt.Sprintf("unhealthy, retry in %v", h.RetryIn))
+	}
+	if h.Failures > 0 {
+		sb.WriteString(fmt.Sprintf(", %d consecutive failures, last error: %s", h.Failures, h.LastError))
+		if h.LastStatus != 0 {
+			sb.WriteString(fmt.Sprintf(" (status %d)", h.LastStatus))
+		}
+	}
+	if len(h.Failover) > 0 {
+		sb.WriteString(", failover: " + strings.Join(h.Failover, " -> "))
+	}
+	return sb.String()
+}
+
+// GetProviderHealth 获取所有AI机器人的健康状态，按机器人类型排序
+func GetProviderHealth() []ProviderHealth {
+	states, _ := db.GetBreakerStates()
+	now := time.Now()
+	var res []ProviderHealth
+	for _, p := range Providers() {
+		if p.Local {
+			continue
+		}
+		h := ProviderHealth{BotType: p.Name, Healthy: true, Failover: failoverChain(p.Name)[1:]}
+		if state, ok := states[p.Name]; ok {
+			h.Failures = state.Failures
+			h.LastError = ErrorKind(state.LastErrorKind)
+			h.LastStatus = state.LastStatus
+			if openUntil := time.Unix(state.OpenUntil, 0); openUntil.After(now) {
+				h.Healthy = false
+				h.RetryIn = openUntil.Sub(now).Round(time.Second)
+			}
+		}
+		res = append(res, h)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].BotType < res[j].BotType })
+	return res
+}
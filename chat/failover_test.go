@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+type failoverBot struct {
+	Echo
+	err   error
+	calls int
+}
+
+func (f *failoverBot) reply(ctx context.Context, userId, msg string, stream *ReplyStream, imageURL ...string) (string, error) {
+	f.calls++
+	stream.Write("好的：")
+	if f.err != nil {
+		return "", f.err
+	}
+	stream.Write(msg)
+	return "好的：" + msg, nil
+}
+
+func TestChatWithFailover(t *testing.T) {
+	oldStore := db.StoreInstance
+	db.StoreInstance = db.NewMemoryStore()
+	defer func() { db.StoreInstance = oldStore }()
+	primary := &failoverBot{err: newHTTPError("failover-a", 429, nil)}
+	backup := &failoverBot{}
+	RegisterProvider(&Provider{Name: "failover-a", Title: "A", New: func() BaseChat { return primary }})
+	RegisterProvider(&Provider{Name: "failover-b", Title: "B", New: func() BaseChat { return backup }})
+	t.Setenv(config.Failover_Chains_Key, `{"failover-a":["failover-a","keyword","unknown","failover-b"]}`)
+	t.Setenv(config.Breaker_Threshold_Key, "2")
+	t.Setenv(config.Breaker_Cooldown_Key, "60")
+	t.Setenv(config.Retry_Max_Key, "0")
+
+	r := chatWithFailover(context.Background(), "failover-a", primary, "u", "你好", nil)
+	if r != "（A 暂时不可用，以下由 B 回答）\n好的：你好" {
+		t.Errorf("unexpected failover reply %q", r)
+	}
+	chatWithFailover(context.Background(), "failover-a", primary, "u", "你好", nil)
+	if primary.calls != 2 {
+		t.Fatalf("expected 2 calls before the breaker opens, got %d", primary.calls)
+	}
+	// 熔断后不再调用
+	chatWithFailover(context.Background(), "failover-a", primary, "u", "你好", nil)
+	if primary.calls != 2 || backup.calls != 3 {
+		t.Errorf("open breaker should skip the primary, calls %d/%d", primary.calls, backup.calls)
+	}
+	health := GetProviderHealth()
+	idx := slices.IndexFunc(health, func(h ProviderHealth) bool { return h.BotType == "failover-a" })
+	if idx < 0 || health[idx].Healthy || health[idx].Failures != 2 || !strings.Contains(health[idx].String(), "failover: keyword -> unknown -> failover-b") {
+		t.Errorf("unexpected health %+v", health)
+	}
+	// 只展示错误类型和状态码，不展示接口返回的原始错误
+	if s := health[idx].String(); !strings.Contains(s, "last error: rate_limit (status 429)") || strings.Contains(s, "failover-a rate_limit error") {
+		t.Errorf("unexpected last error in %q", s)
+	}
+
+	// 备用机器人也失败时返回当前机器人的错误提示
+	backup.err = errors.New("down")
+	db.ResetBreakerState("failover-a")
+	if r = chatWithFailover(context.Background(), "failover-a", primary, "u", "你好", nil); r != "A 当前请求太多，请稍后再试" {
+		t.Errorf("expected the primary error, got %q", r)
+	}
+
+	// 内容审核不通过时不故障转移，也不计入熔断
+	db.ResetBreakerState("failover-a")
+	primary.err = &ProviderError{Kind: ErrKindContentFilter, BotType: "failover-a", Err: errors.New("blocked")}
+	calls := backup.calls
+	if r = chatWithFailover(context.Background(), "failover-a", primary, "u", "你好", nil); r != "内容未通过 A 的安全审核，请换个说法试试" || backup.calls != calls {
+		t.Errorf("content filter should not fail over, got %q", r)
+	}
+	if state, _ := db.GetBreakerState("failover-a"); state != nil {
+		t.Errorf("content filter should not count as a failure, got %+v", state)
+	}
+
+	// 成功后清除失败记录
+	primary.err, backup.err = nil, nil
+	chatWithFailover(context.Background(), "failover-b", backup, "u", "你好", nil)
+	if state, _ := db.GetBreakerState("failover-b"); state != nil {
+		t.Errorf("success should reset the breaker, got %+v", state)
+	}
+}
+
+type blockingBot struct {
+	Echo
+}
+
+func (b *blockingBot) reply(ctx context.Context, userId, msg string, stream *ReplyStream, imageURL ...string) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func TestChatWithFailoverCancel(t *testing.T) {
+	oldStore := db.StoreInstance
+	db.StoreInstance = db.NewMemoryStore()
+	defer func() { db.StoreInstance = oldStore }()
+	backup := &failoverBot{}
+	RegisterProvider(&Provider{Name: "cancel-a", Title: "A", New: func() BaseChat { return &blockingBot{} }})
+	RegisterProvider(&Provider{Name: "cancel-b", Title: "B", New: func() BaseChat { return backup }})
+	t.Setenv(config.Failover_Chains_Key, `{"cancel-a":["cancel-b"]}`)
+
+	// 截止时间到达后取消调用，不再尝试下一个机器人
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if r := chatWithFailover(ctx, "cancel-a", &blockingBot{}, "u", "你好", nil); r != "A 响应超时，请稍后再试" {
+		t.Errorf("expected timeout reply, got %q", r)
+	}
+	if backup.calls != 0 {
+		t.Errorf("should not fail over after the deadline, got %d calls", backup.calls)
+	}
+}
@@ -4,7 +4,9 @@ package chat
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -13,6 +15,7 @@ import (
 	"github.com/google/generative-ai-go/genai"
 	"github.com/pwh-pwh/aiwechat-vercel/config"
 	"github.com/pwh-pwh/aiwechat-vercel/db"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -21,10 +24,28 @@ const (
 	GeminiBot  = "model"
 )
 
+func init() {
+	RegisterProvider(&Provider{
+		Name:         config.Bot_Type_Gemini,
+		Title:        "Gemini",
+		Command:      config.Wx_Command_Gemini,
+		Description:  "与gemini对话",
+		Capabilities: Capabilities{Model: true, Image: true, Voice: true},
+		Params: map[string]ParamRule{
+			ParamTemperature: {Min: 0, Max: 2},
+			ParamTopP:        {Min: 0, Max: 1},
+			ParamTopK:        {Min: 1, Max: 100, Integer: true},
+			ParamMaxTokens:   {Min: 1, Max: 8192, Integer: true},
+		},
+		CheckConfig: config.CheckGeminiConfig,
+		Welcome:     config.GetGeminiWelcomeReply,
+		New:         GetGeminiChatBot,
+	})
+}
+
 type GeminiChat struct {
 	BaseChat
-	key       string
-	maxTokens int
+	key string
 }
 
 func (s *GeminiChat) toDbMsg(msg *genai.Content) db.Msg {
@@ -69,62 +90,61 @@ func (s *GeminiChat) toChatMsg(msg db.Msg) *genai.Content {
 }
 
 func (s *GeminiChat) getModel(userId string) string {
-	if model, err := db.GetModel(userId, config.Bot_Type_Gemini); err == nil && model != "" {
+	if model := userModel(userId, config.Bot_Type_Gemini); model != "" {
 		return model
 	}
 	// Use a valid model name for a recent version
 	return "gemini-1.5-flash-latest"
 }
 
+// newModel 创建模型并应用用户的生成参数
+func (g *GeminiChat) newModel(client *genai.Client, userId string) *genai.GenerativeModel {
+	model := client.GenerativeModel(g.getModel(userId))
+	params := GenerationParams(config.Bot_Type_Gemini, userId)
+	if params.Temperature != nil {
+		model.SetTemperature(float32(*params.Temperature))
+	}
+	if params.TopP != nil {
+		model.SetTopP(float32(*params.TopP))
+	}
+	if params.TopK != nil {
+		model.SetTopK(int32(*params.TopK))
+	}
+	if params.MaxTokens != nil {
+		model.SetMaxOutputTokens(int32(*params.MaxTokens))
+	}
+	return model
+}
+
 // Chat 方法签名与 BaseChat 接口保持一致，用于处理文本和图片
-func (g *GeminiChat) Chat(userId string, msg string, imageURL ...string) string {
-	r, flag := DoAction(userId, msg)
+func (g *GeminiChat) Chat(ctx context.Context, userId string, msg string, imageURL ...string) string {
+	r, flag := DoAction(ctx, userId, msg)
 	if flag {
 		return r
 	}
+	return WithTimeChat(ctx, userId, msg, func(ctx context.Context, userId, msg string, stream *ReplyStream) string {
+		return chatWithFailover(ctx, config.Bot_Type_Gemini, g, userId, msg, stream, imageURL...)
+	})
+}
 
-	ctx := context.Background()
+func (g *GeminiChat) reply(ctx context.Context, userId string, msg string, stream *ReplyStream, imageURL ...string) (string, error) {
 	client, err := genai.NewClient(ctx, option.WithAPIKey(g.key))
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
 	defer client.Close()
-	model := client.GenerativeModel(g.getModel(userId))
-	if g.maxTokens > 0 {
-		model.SetMaxOutputTokens(int32(g.maxTokens))
-	}
+	model := g.newModel(client, userId)
 	cs := model.StartChat()
 
 	var parts []genai.Part
 
 	// 处理图片 URL
 	if len(imageURL) > 0 && imageURL[0] != "" {
-		// 1. 发起HTTP请求下载图片
-		resp, err := http.Get(imageURL[0])
-		if err != nil {
-			return "下载图片失败: " + err.Error()
-		}
-		defer resp.Body.Close()
-
-		// 2. 检查响应状态码
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Sprintf("下载图片失败，状态码: %d", resp.StatusCode)
-		}
-
-		// 3. 读取图片数据到内存
-		imageData, err := io.ReadAll(resp.Body)
+		imageData, mimeType, err := downloadImage(ctx, imageURL[0])
 		if err != nil {
-			return "读取图片数据失败: " + err.Error()
+			return "", err
 		}
-
-		// 4. 获取图片MIME类型
-		mimeType := http.DetectContentType(imageData)
-
-		// 5. 创建genai.Blob
-		imagePart := genai.ImageData(mimeType, imageData)
-
-		// 6. 将图片数据添加到parts中
-		parts = append(parts, imagePart)
+		parts = append(parts, genai.ImageData(mimeType, imageData))
 	}
 
 	// 将文本消息添加到 parts 中，如果文本消息存在
@@ -141,63 +161,145 @@ func (g *GeminiChat) Chat(userId string, msg string, imageURL ...string) string
 		cs.History = msgs[:len(msgs)-1]
 	}
 
-	resp, err := cs.SendMessage(ctx, parts...)
-	if err != nil {
-		return err.Error()
-	}
-
+	// 流式输出，每个响应包含增量文本，最后一个响应包含本次调用的用量
+	iter := cs.SendMessageStream(ctx, parts...)
 	var responseText string
-	for _, part := range resp.Candidates[0].Content.Parts {
-		if text, ok := part.(genai.Text); ok {
-			responseText += string(text)
+	var last *genai.GenerateContentResponse
+	for {
+		resp, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		last = resp
+		if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+			continue
+		}
+		for _, part := range resp.Candidates[0].Content.Parts {
+			if text, ok := part.(genai.Text); ok {
+				responseText += string(text)
+				stream.Write(string(text))
+			}
 		}
 	}
+	if last != nil {
+		g.recordUsage(userId, last)
+	}
+	if responseText == "" {
+		return "", errors.New("empty response candidates")
+	}
 
 	msgs = append(msgs, &genai.Content{
 		Parts: []genai.Part{genai.Text(responseText)},
 		Role:  GeminiBot,
 	})
 
-	SaveMsgListWithDb(config.Bot_Type_Gemini, userId, msgs, g.toDbMsg)
-	return responseText
+	SaveMsgListWithDb(ctx, config.Bot_Type_Gemini, userId, msgs, g.toDbMsg)
+	return responseText, nil
 }
 
-// NOTE: 新增 ChatWithVoice 方法，专门用于处理语音消息
-func (g *GeminiChat) ChatWithVoice(userId string, voiceData []byte) string {
-	ctx := context.Background()
+// downloadImage 下载微信图片消息中的图片，返回图片数据和 MIME 类型
+func downloadImage(ctx context.Context, url string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("下载图片失败: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("下载图片失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("下载图片失败，状态码: %d", resp.StatusCode)
+	}
+	imageData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("读取图片数据失败: %w", err)
+	}
+	return imageData, http.DetectContentType(imageData), nil
+}
+
+// Complete 单次补全，不读取也不保存历史
+func (g *GeminiChat) Complete(ctx context.Context, userId, prompt string) (string, error) {
 	client, err := genai.NewClient(ctx, option.WithAPIKey(g.key))
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
 	defer client.Close()
-	model := client.GenerativeModel(g.getModel(userId))
-	if g.maxTokens > 0 {
-		model.SetMaxOutputTokens(int32(g.maxTokens))
+	model := g.newModel(client, userId)
+	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	if err != nil {
+		return "", err
 	}
+	g.recordUsage(userId, resp)
+	return candidateText(resp), nil
+}
 
-	mimeType := http.DetectContentType(voiceData)
-	// 根据 Gemini API 文档，audio/amr 和 audio/mpeg 都是支持的格式
-	if strings.Contains(mimeType, "audio") {
-		voicePart := genai.Blob{MIMEType: mimeType, Data: voiceData}
-
-		resp, err := model.GenerateContent(ctx, voicePart)
-		if err != nil {
-			return err.Error()
+// candidateText 第一个有内容的候选回答中的文本
+func candidateText(resp *genai.GenerateContentResponse) string {
+	var responseText string
+	for _, cand := range resp.Candidates {
+		if cand.Content == nil {
+			continue
 		}
-
-		var responseText string
-		for _, part := range resp.Candidates[0].Content.Parts {
+		for _, part := range cand.Content.Parts {
 			if text, ok := part.(genai.Text); ok {
 				responseText += string(text)
 			}
 		}
+		break
+	}
+	return responseText
+}
 
-		// 语音消息不保存对话历史，因为Gemini API对多模态输入有额外的token限制，
-		// 频繁使用可能导致请求失败。如果你想保存历史，需要修改toDbMsg和toChatMsg函数
-		// 以支持多模态内容。
-
-		return responseText
-	} else {
+// NOTE: 新增 ChatWithVoice 方法，专门用于处理语音消息
+func (g *GeminiChat) ChatWithVoice(ctx context.Context, userId string, voiceData []byte) string {
+	mimeType := http.DetectContentType(voiceData)
+	// 根据 Gemini API 文档，audio/amr 和 audio/mpeg 都是支持的格式
+	if !strings.Contains(mimeType, "audio") {
 		return fmt.Sprintf("不支持的音频格式: %s", mimeType)
 	}
-}
\ No newline at end of file
+	// 与文字消息一样超时后转为异步回复，迟到的回复按语音内容缓存，避免与其它消息混淆
+	key := fmt.Sprintf("voice:%x", sha256.Sum256(voiceData))
+	return WithTimeChat(ctx, userId, key, func(ctx context.Context, userId, msg string, stream *ReplyStream) string {
+		res, err := withRetry(ctx, config.Bot_Type_Gemini, func() (string, error) {
+			return g.voiceReply(ctx, userId, genai.Blob{MIMEType: mimeType, Data: voiceData})
+		})
+		if err != nil {
+			return userErrorMessage(config.Bot_Type_Gemini, err)
+		}
+		return res
+	})
+}
+
+// voiceReply 语音消息不保存对话历史，因为Gemini API对多模态输入有额外的token限制，
+// 频繁使用可能导致请求失败。如果你想保存历史，需要修改toDbMsg和toChatMsg函数以支持多模态内容
+func (g *GeminiChat) voiceReply(ctx context.Context, userId string, voice genai.Blob) (string, error) {
+	client, err := genai.NewClient(ctx, option.WithAPIKey(g.key))
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	model := g.newModel(client, userId)
+	resp, err := model.GenerateContent(ctx, voice)
+	if err != nil {
+		return "", err
+	}
+	g.recordUsage(userId, resp)
+	responseText := candidateText(resp)
+	if responseText == "" {
+		return "", &ProviderError{Kind: ErrKindUpstream, BotType: config.Bot_Type_Gemini, Err: errors.New("empty response candidates")}
+	}
+	return responseText, nil
+}
+
+func (g *GeminiChat) recordUsage(userId string, resp *genai.GenerateContentResponse) {
+	var usage TokenUsage
+	if resp.UsageMetadata != nil {
+		usage.PromptTokens = int(resp.UsageMetadata.PromptTokenCount)
+		usage.CompletionTokens = int(resp.UsageMetadata.CandidatesTokenCount)
+	}
+	RecordUsage(userId, config.Bot_Type_Gemini, g.getModel(userId), usage)
+}
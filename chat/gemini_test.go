@@ -1,9 +1,11 @@
 package chat
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/google/generative-ai-go/genai"
 	"github.com/joho/godotenv"
 	"github.com/pwh-pwh/aiwechat-vercel/config"
 	"github.com/pwh-pwh/aiwechat-vercel/db"
@@ -18,7 +20,32 @@ func TestGeminiChat(t *testing.T) {
 		key:      config.GetGeminiKey(),
 	}
 
-	res := chat.Chat("testUser", "用10个字描述你的能力")
+	res := chat.Chat(context.Background(), "testUser", "用10个字描述你的能力")
 
 	fmt.Println(res)
 }
+
+func TestGeminiCandidateText(t *testing.T) {
+	empty := []*genai.GenerateContentResponse{
+		{},
+		{Candidates: []*genai.Candidate{{FinishReason: genai.FinishReasonSafety}}},
+	}
+	for _, resp := range empty {
+		if text := candidateText(resp); text != "" {
+			t.Errorf("expected no text, got %q", text)
+		}
+	}
+	resp := &genai.GenerateContentResponse{Candidates: []*genai.Candidate{
+		{},
+		{Content: &genai.Content{Parts: []genai.Part{genai.Text("你好，"), genai.Text("世界")}}},
+	}}
+	if text := candidateText(resp); text != "你好，世界" {
+		t.Errorf("unexpected text %q", text)
+	}
+
+	// 不支持的格式不会调用接口
+	chat := &GeminiChat{BaseChat: SimpleChat{}}
+	if res := chat.ChatWithVoice(context.Background(), "voice-user", []byte("not audio")); res != "不支持的音频格式: text/plain; charset=utf-8" {
+		t.Errorf("unexpected reply %q", res)
+	}
+}
@@ -2,18 +2,51 @@ package chat
 
 import (
 	"context"
+	"errors"
+	"io"
+	"math"
 
 	"os"
+	"strings"
 
 	"github.com/pwh-pwh/aiwechat-vercel/config"
 	"github.com/pwh-pwh/aiwechat-vercel/db"
 	"github.com/sashabaranov/go-openai"
 )
 
+func init() {
+	RegisterProvider(&Provider{
+		Name:         config.Bot_Type_Gpt,
+		Title:        "GPT",
+		Command:      config.Wx_Command_Gpt,
+		Description:  "与GPT对话",
+		EventKey:     config.GetWxEventKeyChatGpt,
+		Capabilities: Capabilities{Prompt: true, Model: true},
+		Params:       openAIParams,
+		CheckConfig:  config.CheckGptConfig,
+		Welcome:      config.GetGptWelcomeReply,
+		New: func() BaseChat {
+			url := os.Getenv("GPT_URL")
+			if url == "" {
+				url = "https://api.openai.com/v1/"
+			}
+			return &SimpleGptChat{
+				token:    config.GetGptToken(),
+				url:      url,
+				botType:  config.Bot_Type_Gpt,
+				model:    os.Getenv("gptModel"),
+				BaseChat: SimpleChat{},
+			}
+		},
+	})
+}
+
+// SimpleGptChat 适用于所有 OpenAI 兼容接口，botType 决定历史记录和自定义 model 的命名空间
 type SimpleGptChat struct {
-	token     string
-	url       string
-	maxTokens int
+	token   string
+	url     string
+	botType string
+	model   string
 	BaseChat
 }
 
@@ -38,39 +71,108 @@ func (s *SimpleGptChat) toChatMsg(msg db.Msg) openai.ChatCompletionMessage {
 }
 
 func (s *SimpleGptChat) getModel(userId string) string {
-	if model, err := db.GetModel(userId, config.Bot_Type_Gpt); err == nil && model != "" {
-		return model
-	} else if model = os.Getenv("gptModel"); model != "" {
+	if model := userModel(userId, s.botType); model != "" {
 		return model
+	} else if s.model != "" {
+		return s.model
 	}
 	return "gpt-3.5-turbo"
 }
 
-func (s *SimpleGptChat) Chat(userId string, msg string, imageURL ...string) string {
-	r, flag := DoAction(userId, msg)
+func (s *SimpleGptChat) Chat(ctx context.Context, userId string, msg string, imageURL ...string) string {
+	r, flag := DoAction(ctx, userId, msg)
 	if flag {
 		return r
 	}
+	return WithTimeChat(ctx, userId, msg, func(ctx context.Context, userId, msg string, stream *ReplyStream) string {
+		return chatWithFailover(ctx, s.botType, s, userId, msg, stream)
+	})
+}
 
+func (s *SimpleGptChat) reply(ctx context.Context, userId, msg string, stream *ReplyStream, imageURL ...string) (string, error) {
+	var msgs = GetMsgListWithDb(s.botType, userId, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: msg}, s.toDbMsg, s.toChatMsg)
+	content, err := s.complete(ctx, userId, msgs, stream)
+	if err != nil {
+		return "", err
+	}
+	msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content})
+	SaveMsgListWithDb(ctx, s.botType, userId, msgs, s.toDbMsg)
+	return content, nil
+}
+
+// Complete 单次补全，不读取也不保存历史
+func (s *SimpleGptChat) Complete(ctx context.Context, userId, prompt string) (string, error) {
+	return s.complete(ctx, userId, []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}}, nil)
+}
+
+// complete stream 为 nil 时使用非流式接口，以获得准确的用量
+func (s *SimpleGptChat) complete(ctx context.Context, userId string, msgs []openai.ChatCompletionMessage, stream *ReplyStream) (string, error) {
 	cfg := openai.DefaultConfig(s.token)
 	cfg.BaseURL = s.url
 	client := openai.NewClientWithConfig(cfg)
 
-	var msgs = GetMsgListWithDb(config.Bot_Type_Gpt, userId, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: msg}, s.toDbMsg, s.toChatMsg)
 	req := openai.ChatCompletionRequest{
 		Model:    s.getModel(userId),
 		Messages: msgs,
 	}
-	// 如果设置了环境变量且合法，则增加maxTokens参数，否则不设置
-	if s.maxTokens > 0 {
-		req.MaxTokens = s.maxTokens // 参数名称参考：https://github.com/sashabaranov/go-openai
+	params := GenerationParams(s.botType, userId)
+	if params.Temperature != nil {
+		// go-openai 会省略值为0的字段，用最小的非零值代替0
+		req.Temperature = max(float32(*params.Temperature), math.SmallestNonzeroFloat32)
+	}
+	if params.TopP != nil {
+		req.TopP = max(float32(*params.TopP), math.SmallestNonzeroFloat32)
 	}
-	resp, err := client.CreateChatCompletion(context.Background(), req)
+	if params.MaxTokens != nil {
+		req.MaxTokens = *params.MaxTokens // 参数名称参考：https://github.com/sashabaranov/go-openai
+	}
+	if stream != nil {
+		return s.completeStream(ctx, client, userId, req, stream)
+	}
+	resp, err := client.CreateChatCompletion(ctx, req)
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
-	content := resp.Choices[0].Message.Content
-	msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content})
-	SaveMsgListWithDb(config.Bot_Type_Gpt, userId, msgs, s.toDbMsg)
-	return content
-}
\ No newline at end of file
+	RecordUsage(userId, s.botType, req.Model, TokenUsage{
+		PromptTokens:     resp.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens,
+	})
+	if len(resp.Choices) == 0 {
+		return "", errors.New("empty response choices")
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+// completeStream 通过 SSE 流式补全。go-openai 的流式响应不包含用量，按 EstimateTokens 估算
+func (s *SimpleGptChat) completeStream(ctx context.Context, client *openai.Client, userId string, req openai.ChatCompletionRequest, stream *ReplyStream) (string, error) {
+	resp, err := client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Close()
+
+	var sb strings.Builder
+	for {
+		chunk, err := resp.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(chunk.Choices) > 0 {
+			sb.WriteString(chunk.Choices[0].Delta.Content)
+			stream.Write(chunk.Choices[0].Delta.Content)
+		}
+	}
+	content := sb.String()
+	usage := TokenUsage{CompletionTokens: EstimateTokens(content)}
+	for _, msg := range req.Messages {
+		usage.PromptTokens += EstimateTokens(msg.Content)
+	}
+	RecordUsage(userId, s.botType, req.Model, usage)
+	if content == "" {
+		return "", errors.New("empty response choices")
+	}
+	return content, nil
+}
@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"unicode"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	// 每条消息的格式开销，参考 OpenAI 的计算方式
+	msgTokenOverhead = 4
+	// 图片按固定数量估算
+	imageTokenEstimate = 300
+)
+
+// EstimateTokens 粗略估算文本的token数：中日韩字符按每字1个token计算，
+// 其它字符按每4个字符1个token计算，不依赖具体模型的分词器
+func EstimateTokens(text string) int {
+	cjk, others := 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r),
+			unicode.Is(unicode.Katakana, r), unicode.Is(unicode.Hangul, r):
+			cjk++
+		case unicode.IsSpace(r):
+			// 空白字符通常与相邻单词合并为一个token
+		default:
+			others++
+		}
+	}
+	return cjk + (others+3)/4
+}
+
+// EstimateMsgTokens 估算一条消息的token数
+func EstimateMsgTokens(msg db.Msg) int {
+	tokens := msgTokenOverhead
+	for _, part := range msg.Parts {
+		if part.Type == "text" {
+			tokens += EstimateTokens(part.Data)
+		} else {
+			tokens += imageTokenEstimate
+		}
+	}
+	return tokens
+}
+
+// historyPolicy 根据用户当前的机器人和自定义模型获取历史保留策略
+func historyPolicy(botType, userId string) config.HistoryPolicy {
+	return config.GetHistoryPolicy(botType, userModel(userId, botType))
+}
+
+// TrimHistory 按策略裁剪对话历史：开头的system prompt总是保留，最后一条消息(本次提问或回答)总是保留，
+// 其余消息从新到旧保留到超出轮数或token上限为止，并保证裁剪后的历史以用户消息开头
+func TrimHistory(msgs []db.Msg, policy config.HistoryPolicy) []db.Msg {
+	if len(msgs) == 0 || (policy.MaxTurns <= 0 && policy.MaxTokens <= 0) {
+		return msgs
+	}
+	var system []db.Msg
+	rest := msgs
+	for len(rest) > 1 && rest[0].Role == "system" {
+		system = append(system, rest[0])
+		rest = rest[1:]
+	}
+
+	tokens := 0
+	for _, msg := range system {
+		tokens += EstimateMsgTokens(msg)
+	}
+	start := len(rest) - 1
+	tokens += EstimateMsgTokens(rest[start])
+	turns := 0
+	if rest[start].Role == "user" {
+		turns = 1
+	}
+	for i := start - 1; i >= 0; i-- {
+		t := EstimateMsgTokens(rest[i])
+		if policy.MaxTokens > 0 && tokens+t > policy.MaxTokens {
+			break
+		}
+		if rest[i].Role == "user" {
+			if policy.MaxTurns > 0 && turns+1 > policy.MaxTurns {
+				break
+			}
+			turns++
+		}
+		tokens += t
+		start = i
+	}
+	// 部分模型要求历史必须以用户消息开头
+	for start < len(rest)-1 && rest[start].Role != "user" {
+		start++
+	}
+
+	trimmed := make([]db.Msg, 0, len(system)+len(rest)-start)
+	trimmed = append(trimmed, system...)
+	return append(trimmed, rest[start:]...)
+}
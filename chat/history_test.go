@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func textMsg(role, text string) db.Msg {
+	return db.Msg{Role: role, Parts: []db.ContentPart{{Type: "text", Data: text}}}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	if n := EstimateTokens("你好世界"); n != 4 {
+		t.Errorf("expected 4 tokens for 4 han characters, got %d", n)
+	}
+	if n := EstimateTokens("hello world"); n != 3 {
+		t.Errorf("expected 3 tokens, got %d", n)
+	}
+	if n := EstimateTokens("こんにちは 안녕 hi"); n != 8 {
+		t.Errorf("expected 8 tokens, got %d", n)
+	}
+}
+
+func TestTrimHistoryTurns(t *testing.T) {
+	msgs := []db.Msg{textMsg("system", "prompt")}
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, textMsg("user", "q"), textMsg("assistant", "a"))
+	}
+	msgs = append(msgs, textMsg("user", "current"))
+
+	trimmed := TrimHistory(msgs, config.HistoryPolicy{MaxTurns: 3})
+	if len(trimmed) != 1+5 || trimmed[0].Role != "system" || trimmed[1].Role != "user" {
+		t.Fatalf("unexpected trimmed history: %+v", trimmed)
+	}
+	if trimmed[len(trimmed)-1].Parts[0].Data != "current" {
+		t.Error("current message must be kept")
+	}
+}
+
+func TestTrimHistoryTokens(t *testing.T) {
+	long := strings.Repeat("长", 100)
+	msgs := []db.Msg{
+		textMsg("system", "prompt"),
+		textMsg("user", long), textMsg("assistant", long),
+		textMsg("user", "短问题"), textMsg("assistant", "短回答"),
+		textMsg("user", "current"),
+	}
+	trimmed := TrimHistory(msgs, config.HistoryPolicy{MaxTokens: 150})
+	if len(trimmed) != 4 || trimmed[0].Role != "system" || trimmed[1].Parts[0].Data != "短问题" {
+		t.Fatalf("unexpected trimmed history: %+v", trimmed)
+	}
+
+	// 超出预算时，回答不能作为历史的第一条消息
+	trimmed = TrimHistory(msgs, config.HistoryPolicy{MaxTokens: 130})
+	for _, msg := range trimmed[1:2] {
+		if msg.Role != "user" {
+			t.Errorf("history must start with a user message, got %s", msg.Role)
+		}
+	}
+
+	// 当前消息本身超出预算也要保留
+	trimmed = TrimHistory([]db.Msg{textMsg("user", long)}, config.HistoryPolicy{MaxTokens: 10})
+	if len(trimmed) != 1 {
+		t.Error("current message must be kept even if over budget")
+	}
+}
+
+func TestGetHistoryPolicy(t *testing.T) {
+	t.Setenv("MSG_MAX_TURNS", "5")
+	t.Setenv("MSG_MAX_TOKENS", "1000")
+	t.Setenv("MSG_MAX_TOKENS_MAP", `{"qwen":3000,"gpt-4o":100000}`)
+	if p := config.GetHistoryPolicy("gpt", "gpt-4o"); p.MaxTokens != 100000 || p.MaxTurns != 5 {
+		t.Errorf("unexpected model policy %+v", p)
+	}
+	if p := config.GetHistoryPolicy("qwen", ""); p.MaxTokens != 3000 {
+		t.Errorf("unexpected bot policy %+v", p)
+	}
+	if p := config.GetHistoryPolicy("spark", ""); p.MaxTokens != 1000 {
+		t.Errorf("unexpected default policy %+v", p)
+	}
+}
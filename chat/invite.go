@@ -0,0 +1,216 @@
+package chat
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	inviteCodeLength   = 8
+	inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // 去掉了容易混淆的 I、O、0、1
+	inviteTimeLayout   = "2006-01-02 15:04"
+
+	defaultInviteUses       = 1
+	defaultInviteExpireDays = 7
+
+	inviteCommandHelp = "格式：/invite [uses=次数] [expire=邀请码有效天数] [days=角色有效天数] [role=角色] [tier=额度等级]\n" +
+		"默认 uses=1 expire=7 days=0(永久) role=member"
+)
+
+func newInviteCode() (string, error) {
+	var sb strings.Builder
+	for i := 0; i < inviteCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(inviteCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(inviteCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// parseInviteParam 解析 /invite 的 key=value 参数
+func parseInviteParam(param string) (*db.Invite, int, error) {
+	invite := &db.Invite{MaxUses: defaultInviteUses, Role: RoleMember}
+	expireDays := defaultInviteExpireDays
+	for _, field := range strings.Fields(param) {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, 0, fmt.Errorf("无法识别的参数：%s", field)
+		}
+		var err error
+		switch key {
+		case "uses":
+			invite.MaxUses, err = strconv.Atoi(val)
+			if err == nil && invite.MaxUses <= 0 {
+				err = errors.New("次数必须大于0")
+			}
+		case "expire":
+			expireDays, err = strconv.Atoi(val)
+			if err == nil && expireDays < 0 {
+				err = errors.New("有效天数不能小于0")
+			}
+		case "days":
+			invite.AccessDays, err = strconv.Atoi(val)
+			if err == nil && invite.AccessDays < 0 {
+				err = errors.New("有效天数不能小于0")
+			}
+		case "role":
+			invite.Role = val
+			// 邀请码流通范围不可控，不能用来授予管理员
+			if roleLevel(val) <= roleLevel(RoleGuest) || roleLevel(val) >= roleLevel(RoleAdmin) {
+				err = fmt.Errorf("邀请码只能授予 %s 或 %s 角色", RoleMember, RoleOperator)
+			}
+		case "tier":
+			invite.Tier = val
+			if _, ok := config.GetQuotaTiers()[val]; !ok {
+				err = fmt.Errorf("未知的额度等级，请先在 %s 中配置", config.Quota_Tiers_Key)
+			}
+		default:
+			err = errors.New("未知的参数")
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s：%v", field, err)
+		}
+	}
+	return invite, expireDays, nil
+}
+
+// CreateInviteCommand /invite：管理员生成邀请码
+func CreateInviteCommand(param, userId string) string {
+	invite, expireDays, err := parseInviteParam(param)
+	if err != nil {
+		return err.Error() + "\n" + inviteCommandHelp
+	}
+	now := time.Now()
+	if invite.Code, err = newInviteCode(); err != nil {
+		return fmt.Sprintf("生成邀请码失败：%v", err)
+	}
+	invite.CreatedBy = userId
+	invite.CreatedAt = now.Unix()
+	if expireDays > 0 {
+		invite.ExpiresAt = now.AddDate(0, 0, expireDays).Unix()
+	}
+	if err = db.SaveInvite(invite); err != nil {
+		return fmt.Sprintf("生成邀请码失败：%v", err)
+	}
+	return fmt.Sprintf("邀请码：%s\n%s\n用户发送 /addme %s 即可使用", invite.Code, formatInvite(invite), invite.Code)
+}
+
+// ListInvitesCommand /invites [邀请码]：查看邀请码及使用情况
+func ListInvitesCommand(param, userId string) string {
+	if code := strings.ToUpper(strings.TrimSpace(param)); code != "" {
+		invite, err := db.GetInvite(code)
+		if err != nil {
+			return fmt.Sprintf("查询邀请码失败：%v", err)
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("邀请码：%s\n%s", invite.Code, formatInvite(invite)))
+		for _, r := range invite.Redeemed {
+			sb.WriteString(fmt.Sprintf("\n%s %s", time.Unix(r.At, 0).Format(inviteTimeLayout), r.UserId))
+		}
+		return sb.String()
+	}
+
+	invites, err := db.GetInvites()
+	if err != nil {
+		return fmt.Sprintf("获取邀请码失败：%v", err)
+	}
+	if len(invites) == 0 {
+		return "还没有生成邀请码，发送 /invite 生成"
+	}
+	var sb strings.Builder
+	for _, invite := range invites {
+		sb.WriteString(fmt.Sprintf("%s：%s\n", invite.Code, formatInvite(invite)))
+		for _, r := range invite.Redeemed {
+			sb.WriteString(fmt.Sprintf("  - %s\n", r.UserId))
+		}
+	}
+	sb.WriteString("发送 /invites 邀请码 查看使用时间")
+	return sb.String()
+}
+
+// DelInviteCommand /delinvite 邀请码：作废邀请码，已使用的用户不受影响，可通过 /revoke 撤销
+func DelInviteCommand(param, userId string) string {
+	code := strings.ToUpper(strings.TrimSpace(param))
+	if code == "" {
+		return "格式：/delinvite 邀请码"
+	}
+	invite, err := db.GetInvite(code)
+	if err != nil {
+		return fmt.Sprintf("作废邀请码失败：%v", err)
+	}
+	invite.Revoked = true
+	if err = db.SaveInvite(invite); err != nil {
+		return fmt.Sprintf("作废邀请码失败：%v", err)
+	}
+	return fmt.Sprintf("已作废邀请码 %s，已使用该邀请码的用户可以通过 /revoke 用户openid 撤销", code)
+}
+
+func formatInvite(invite *db.Invite) string {
+	status := fmt.Sprintf("已使用%d/%d次", len(invite.Redeemed), invite.MaxUses)
+	switch {
+	case invite.Revoked:
+		status += "，已作废"
+	case invite.ExpiresAt > 0 && time.Now().Unix() > invite.ExpiresAt:
+		status += "，已过期"
+	case invite.ExpiresAt > 0:
+		status += "，" + time.Unix(invite.ExpiresAt, 0).Format(inviteTimeLayout) + "过期"
+	}
+	access := "永久"
+	if invite.AccessDays > 0 {
+		access = fmt.Sprintf("%d天", invite.AccessDays)
+	}
+	status += fmt.Sprintf("，授予 %s 角色%s", invite.Role, access)
+	if invite.Tier != "" {
+		status += "，额度等级 " + invite.Tier
+	}
+	return status
+}
+
+// redeemInvite 使用邀请码授予角色和额度等级，已有更高的永久角色时保持不变
+func redeemInvite(code, userId string) string {
+	if db.StoreInstance == nil || isSuperAdmin(userId) {
+		return guestReply
+	}
+	now := time.Now()
+	invite, err := db.RedeemInvite(strings.ToUpper(code), userId, now)
+	if errors.Is(err, db.ErrInviteNotFound) {
+		return guestReply
+	} else if err != nil {
+		return fmt.Sprintf("认证失败：%v", err)
+	}
+
+	var expiresAt time.Time
+	if invite.AccessDays > 0 {
+		expiresAt = now.AddDate(0, 0, invite.AccessDays)
+	}
+	// 未授予过角色、角色更低或同级但有期限时才授予，避免降低已有的角色
+	stored, _ := db.GetUserRole(userId)
+	current := GetUserRole(userId)
+	temporary := false
+	if t, err := db.GetRoleExpire(userId); err == nil && !t.IsZero() {
+		temporary = true
+	}
+	if stored == "" || roleLevel(current) < roleLevel(invite.Role) ||
+		(roleLevel(current) == roleLevel(invite.Role) && temporary) {
+		if err = grantRoleUntil(userId, invite.Role, expiresAt); err != nil {
+			return fmt.Sprintf("认证失败：%v", err)
+		}
+	}
+	if invite.Tier != "" {
+		db.SetUserTier(userId, invite.Tier)
+	}
+	if expiresAt.IsZero() {
+		return "认证成功！你现在可以使用AI功能了"
+	}
+	return fmt.Sprintf("认证成功！你现在可以使用AI功能了，有效期至 %s", expiresAt.Format(inviteTimeLayout))
+}
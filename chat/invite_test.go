@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseInviteParam(t *testing.T) {
+	t.Setenv("QUOTA_TIERS", `{"vip":{"daily_msgs":1000}}`)
+
+	invite, expireDays, err := parseInviteParam("")
+	if err != nil || invite.MaxUses != 1 || invite.Role != RoleMember || invite.AccessDays != 0 || expireDays != 7 {
+		t.Errorf("unexpected defaults: %+v, expire=%d, err=%v", invite, expireDays, err)
+	}
+
+	invite, expireDays, err = parseInviteParam("uses=5 expire=0 days=30 role=operator tier=vip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invite.MaxUses != 5 || expireDays != 0 || invite.AccessDays != 30 || invite.Role != RoleOperator || invite.Tier != "vip" {
+		t.Errorf("unexpected invite: %+v, expire=%d", invite, expireDays)
+	}
+
+	for _, param := range []string{"role=admin", "uses=0", "tier=gold", "foo=bar", "uses"} {
+		if _, _, err := parseInviteParam(param); err == nil {
+			t.Errorf("expected %q to be rejected", param)
+		}
+	}
+}
+
+func TestNewInviteCode(t *testing.T) {
+	code, err := newInviteCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != inviteCodeLength || strings.Trim(code, inviteCodeAlphabet) != "" {
+		t.Errorf("unexpected code %q", code)
+	}
+}
+
+func TestIsActionLongestPrefix(t *testing.T) {
+	if action, param, ok := isAction("/invites ABC"); !ok || action != "/invites" || param != "ABC" {
+		t.Errorf("expected /invites, got %q %q", action, param)
+	}
+	if action, param, ok := isAction("/invite uses=2"); !ok || action != "/invite" || param != "uses=2" {
+		t.Errorf("expected /invite, got %q %q", action, param)
+	}
+}
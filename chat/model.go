@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+// resolveModel 在当前机器人的模型目录中校验模型，返回实际保存的模型名。
+// 目录为空时不限制模型名；管理员可以设置目录之外的模型
+func resolveModel(botType, userId, name string) (string, error) {
+	catalog := config.GetModelCatalog(botType)
+	if len(catalog) == 0 {
+		return name, nil
+	}
+	isAdmin := HasRole(userId, RoleAdmin)
+	model, ok := config.FindModel(catalog, name)
+	if !ok {
+		if isAdmin {
+			return name, nil
+		}
+		return "", fmt.Errorf("%s 不支持模型 %s，发送 %s 查看可选模型", botType, name, config.Wx_Command_Models)
+	}
+	if model.AdminOnly && !isAdmin {
+		return "", fmt.Errorf("模型 %s 仅管理员可以使用", model.Name)
+	}
+	return model.Name, nil
+}
+
+// SetModel /setmodel 模型名或别名：设置当前机器人使用的模型，省略模型名恢复默认
+func SetModel(param, userId string) string {
+	botType := config.GetUserBotType(userId)
+	if !GetCapabilities(botType).Model {
+		return fmt.Sprintf("%s 不支持设置model", botType)
+	}
+	model := param
+	if model != "" {
+		var err error
+		if model, err = resolveModel(botType, userId, param); err != nil {
+			return err.Error()
+		}
+	}
+	if err := db.SetModel(userId, botType, model); err != nil {
+		return fmt.Sprintf("%s 设置model失败", botType)
+	}
+	if model == "" {
+		return fmt.Sprintf("%s 已恢复默认model", botType)
+	}
+	return fmt.Sprintf("%s 设置model成功，model：%s", botType, model)
+}
+
+func GetModel(param string, userId string) string {
+	botType := config.GetUserBotType(userId)
+	model, err := db.GetModel(userId, botType)
+	if err != nil || model == "" {
+		return fmt.Sprintf("%s 当前未设置model", botType)
+	}
+	return fmt.Sprintf("%s 获取model成功，model：%s", botType, model)
+}
+
+// ListModels /models：查看当前机器人可选的模型
+func ListModels(param, userId string) string {
+	botType := config.GetUserBotType(userId)
+	if !GetCapabilities(botType).Model {
+		return fmt.Sprintf("%s 不支持设置model", botType)
+	}
+	current, _ := db.GetModel(userId, botType)
+	catalog := config.GetModelCatalog(botType)
+	if len(catalog) == 0 {
+		return fmt.Sprintf("%s 未配置模型目录，可以通过 %s 模型名 设置任意模型", botType, config.Wx_Command_SetModel)
+	}
+	isAdmin := HasRole(userId, RoleAdmin)
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s 可选模型：\n", botType))
+	for _, m := range catalog {
+		if m.AdminOnly && !isAdmin {
+			continue
+		}
+		sb.WriteString(m.Name)
+		if len(m.Aliases) > 0 {
+			sb.WriteString("(" + strings.Join(m.Aliases, "/") + ")")
+		}
+		if m.Description != "" {
+			sb.WriteString("：" + m.Description)
+		}
+		if m.AdminOnly {
+			sb.WriteString(" [仅管理员]")
+		}
+		if m.Name == current {
+			sb.WriteString(" [当前]")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(fmt.Sprintf("发送 %s 模型名或别名 切换，%s 恢复默认", config.Wx_Command_SetModel, config.Wx_Command_SetModel))
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+)
+
+func TestResolveModel(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root1")
+	t.Setenv("ADDME_PASSWORD", "")
+	t.Setenv(config.Model_Catalog_Key, `{"gpt":[{"name":"gpt-4o-mini","aliases":["mini"]},{"name":"o1","admin_only":true}],"qwen":[]}`)
+
+	if m, err := resolveModel(config.Bot_Type_Gpt, "someone", "MINI"); err != nil || m != "gpt-4o-mini" {
+		t.Errorf("alias should resolve to the model name, got %q %v", m, err)
+	}
+	if _, err := resolveModel(config.Bot_Type_Gpt, "someone", "gpt-4"); err == nil || !strings.Contains(err.Error(), config.Wx_Command_Models) {
+		t.Errorf("models outside the catalog should be rejected, got %v", err)
+	}
+	if _, err := resolveModel(config.Bot_Type_Gpt, "someone", "o1"); err == nil {
+		t.Error("admin only models should be rejected for members")
+	}
+	if m, err := resolveModel(config.Bot_Type_Gpt, "root1", "o1"); err != nil || m != "o1" {
+		t.Errorf("admins may select admin only models, got %q %v", m, err)
+	}
+	if m, err := resolveModel(config.Bot_Type_Gpt, "root1", "gpt-4"); err != nil || m != "gpt-4" {
+		t.Errorf("admins may select models outside the catalog, got %q %v", m, err)
+	}
+	// 配置为空列表时不限制模型名
+	if m, err := resolveModel(config.Bot_Type_Qwen, "someone", "qwen-long"); err != nil || m != "qwen-long" {
+		t.Errorf("empty catalog should allow any model, got %q %v", m, err)
+	}
+	if _, err := resolveModel(config.Bot_Type_Spark, "someone", "ultra"); err != nil {
+		t.Errorf("default spark catalog should be used, got %v", err)
+	}
+}
+
+func TestSparkWithDomain(t *testing.T) {
+	cfg := &config.SparkConfig{HostUrl: "wss://spark-api.xf-yun.com/v3.5/chat", SparkDomainVersion: "generalv3.5"}
+	for _, m := range config.GetModelCatalog(config.Bot_Type_Spark) {
+		if _, err := cfg.WithDomain(m.Name); err != nil {
+			t.Errorf("spark catalog model %s has no url: %v", m.Name, err)
+		}
+	}
+	c, err := cfg.WithDomain("4.0Ultra")
+	if err != nil || c.HostUrl != "wss://spark-api.xf-yun.com/v4.0/chat" || cfg.SparkDomainVersion != "generalv3.5" {
+		t.Errorf("unexpected config %+v %v", c, err)
+	}
+}
@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+)
+
+var openAIProfilesOnce sync.Once
+
+// 通过 config.LoadBots 调用，避免与 actionMap 形成初始化循环
+func init() {
+	config.LoadBots = loadOpenAIProfiles
+}
+
+// loadOpenAIProfiles 在第一次使用机器人或命令时注册 OPENAI_PROFILES。init 按文件名顺序执行，
+// 延迟到此时注册才能保证所有内置机器人及其命令都已注册，冲突检查不会漏掉
+func loadOpenAIProfiles() {
+	openAIProfilesOnce.Do(registerOpenAIProfiles)
+}
+
+// registerOpenAIProfiles 将 OPENAI_PROFILES 中的每个 OpenAI 兼容接口注册为独立的机器人，
+// 与内置机器人同名或切换命令与已有命令相同的 profile 会被跳过
+func registerOpenAIProfiles() {
+	profiles, err := config.GetOpenAIProfiles()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, profile := range profiles {
+		existing, ok := findProvider(profile.Name)
+		if ok && !existing.openAIProfile {
+			fmt.Printf("openai profile %s conflicts with built-in bot, skipped\n", profile.Name)
+			continue
+		}
+		// 重新注册同一个 profile 时命令可以不变
+		if _, taken := actionMap[profile.Command]; taken && !(ok && existing.Command == profile.Command) {
+			fmt.Printf("openai profile %s command %s conflicts with an existing command, skipped\n", profile.Name, profile.Command)
+			continue
+		}
+		profile := profile
+		RegisterProvider(&Provider{
+			Name:          profile.Name,
+			Title:         profile.Title,
+			Command:       profile.Command,
+			Description:   fmt.Sprintf("与%s对话", profile.Title),
+			Capabilities:  Capabilities{Prompt: true, Model: true},
+			Params:        openAIParams,
+			CheckConfig:   profile.Check,
+			Welcome:       func() string { return profile.Welcome },
+			openAIProfile: true,
+			New: func() BaseChat {
+				return &SimpleGptChat{
+					token:    profile.Key,
+					url:      profile.Url,
+					botType:  profile.Name,
+					model:    profile.Model,
+					BaseChat: SimpleChat{},
+				}
+			},
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestOpenAIProfile(t *testing.T) {
+	var gotReq openai.ChatCompletionRequest
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		gotAuth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, delta := range []string{"你好，", "我是deepseek"} {
+			fmt.Fprintf(w, "data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", delta)
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer srv.Close()
+
+	// 内置机器人在 init 中注册，profile 在它们之后注册
+	loadOpenAIProfiles()
+	t.Setenv("OPENAI_PROFILES", fmt.Sprintf(`[{"name":"deepseek","title":"DeepSeek","url":"%s/v1/","key":"sk-test","model":"deepseek-chat"},`+
+		`{"name":"gpt","url":"%[1]s/v1","model":"x"},{"name":"qwen","url":"%[1]s/v1","model":"x"},{"name":"spark","url":"%[1]s/v1","model":"x"},`+
+		`{"name":"myset","command":"/set","url":"%[1]s/v1","model":"x"}]`, srv.URL))
+	registerOpenAIProfiles()
+	registerOpenAIProfiles()
+
+	p, ok := GetProvider("deepseek")
+	if !ok {
+		t.Fatal("deepseek profile is not registered")
+	}
+	if p.Command != "/deepseek" || p.Welcome() != "我是DeepSeek，开始聊天吧！" {
+		t.Errorf("unexpected provider defaults: %s %s", p.Command, p.Welcome())
+	}
+	if _, ok := actionMap["/deepseek"]; !ok {
+		t.Error("switch command is not registered")
+	}
+	for _, name := range []string{"gpt", "qwen", "spark"} {
+		if p, _ := GetProvider(name); p.openAIProfile {
+			t.Errorf("profile must not override built-in %s", name)
+		}
+	}
+	if _, ok := GetProvider("myset"); ok {
+		t.Error("profile must not take over an existing command")
+	}
+
+	res := GetChatBot("deepseek").Chat(context.Background(), "profile-user", "你好")
+	if res != "你好，我是deepseek" {
+		t.Fatalf("unexpected reply %q", res)
+	}
+	if gotReq.Model != "deepseek-chat" || !gotReq.Stream || gotAuth != "Bearer sk-test" {
+		t.Errorf("unexpected request model=%s auth=%s", gotReq.Model, gotAuth)
+	}
+}
@@ -0,0 +1,225 @@
+package chat
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	ParamTemperature = "temperature"
+	ParamTopP        = "top_p"
+	ParamTopK        = "top_k"
+	ParamMaxTokens   = "max_tokens"
+
+	paramReset = "reset"
+
+	setParamHelp = "格式：/set 参数 值，例如 /set temperature 0.3\n/set 参数：恢复该参数的默认值\n/set reset：恢复全部默认值\n发送 /params 查看可设置的参数"
+)
+
+// paramNames 参数的展示顺序
+var paramNames = []string{ParamTemperature, ParamTopP, ParamTopK, ParamMaxTokens}
+
+var paramAliases = map[string]string{
+	"temp":       ParamTemperature,
+	"topp":       ParamTopP,
+	"top-p":      ParamTopP,
+	"topk":       ParamTopK,
+	"top-k":      ParamTopK,
+	"maxtokens":  ParamMaxTokens,
+	"max-tokens": ParamMaxTokens,
+	"maxoutput":  ParamMaxTokens,
+}
+
+// ParamRule 机器人支持的一个生成参数及其取值范围
+type ParamRule struct {
+	Min, Max     float64
+	MinExclusive bool // 取值必须大于 Min
+	MaxExclusive bool // 取值必须小于 Max
+	Integer      bool
+	Default      float64 // 用户未设置时使用的值，0 表示不传，由接口决定
+}
+
+func (r ParamRule) Check(v float64) error {
+	if math.IsNaN(v) || v < r.Min || v > r.Max || (r.MinExclusive && v == r.Min) || (r.MaxExclusive && v == r.Max) {
+		return fmt.Errorf("取值范围为 %s", r.rangeString())
+	}
+	if r.Integer && v != math.Trunc(v) {
+		return fmt.Errorf("必须是整数")
+	}
+	return nil
+}
+
+func (r ParamRule) rangeString() string {
+	left, right := "[", "]"
+	if r.MinExclusive {
+		left = "("
+	}
+	if r.MaxExclusive {
+		right = ")"
+	}
+	return fmt.Sprintf("%s%s, %s%s", left, formatParam(r.Min), formatParam(r.Max), right)
+}
+
+// openAIParams OpenAI 兼容接口支持的参数，gpt 和 OPENAI_PROFILES 注册的机器人共用
+var openAIParams = map[string]ParamRule{
+	ParamTemperature: {Min: 0, Max: 2},
+	ParamTopP:        {Min: 0, Max: 1},
+	ParamMaxTokens:   {Min: 1, Max: 128000, Integer: true},
+}
+
+func formatParam(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func normalizeParamName(name string) string {
+	name = strings.ToLower(name)
+	if alias, ok := paramAliases[name]; ok {
+		return alias
+	}
+	return name
+}
+
+func providerParams(botType string) map[string]ParamRule {
+	if p, ok := GetProvider(botType); ok {
+		return p.Params
+	}
+	return nil
+}
+
+func getParam(p db.GenParams, name string) (float64, bool) {
+	switch {
+	case name == ParamTemperature && p.Temperature != nil:
+		return *p.Temperature, true
+	case name == ParamTopP && p.TopP != nil:
+		return *p.TopP, true
+	case name == ParamTopK && p.TopK != nil:
+		return float64(*p.TopK), true
+	case name == ParamMaxTokens && p.MaxTokens != nil:
+		return float64(*p.MaxTokens), true
+	}
+	return 0, false
+}
+
+// setParam 设置参数，v 为 nil 时清除
+func setParam(p *db.GenParams, name string, v *float64) {
+	var i *int
+	if v != nil {
+		n := int(*v)
+		i = &n
+	}
+	switch name {
+	case ParamTemperature:
+		p.Temperature = v
+	case ParamTopP:
+		p.TopP = v
+	case ParamTopK:
+		p.TopK = i
+	case ParamMaxTokens:
+		p.MaxTokens = i
+	}
+}
+
+// GenerationParams 获取用户在机器人上生效的生成参数：用户通过 /set 设置的值优先，其次是当前角色的 temperature，
+// 然后 max_tokens 使用全局配置 maxOutput，最后是机器人的默认值。机器人不支持的参数总是 nil
+func GenerationParams(botType, userId string) db.GenParams {
+	var res db.GenParams
+	stored, _ := db.GetGenParams(userId, botType)
+	if persona := ActivePersona(userId); persona != nil && stored.Temperature == nil {
+		stored.Temperature = persona.Temperature
+	}
+	for name, rule := range providerParams(botType) {
+		v, ok := getParam(stored, name)
+		if ok && rule.Check(v) != nil {
+			ok = false
+		}
+		if !ok && name == ParamMaxTokens && config.GetMaxTokens() > 0 {
+			v, ok = float64(config.GetMaxTokens()), true
+		}
+		if !ok && rule.Default != 0 {
+			v, ok = rule.Default, true
+		}
+		if ok {
+			setParam(&res, name, &v)
+		}
+	}
+	return res
+}
+
+// SetParamCommand /set 参数 值：设置当前机器人的生成参数
+func SetParamCommand(param, userId string) string {
+	botType := config.GetUserBotType(userId)
+	rules := providerParams(botType)
+	if len(rules) == 0 {
+		return fmt.Sprintf("%s 不支持设置生成参数", botType)
+	}
+	fields := strings.Fields(param)
+	if len(fields) == 0 || len(fields) > 2 {
+		return setParamHelp
+	}
+	name := normalizeParamName(fields[0])
+	if name == paramReset {
+		if err := db.SetGenParams(userId, botType, db.GenParams{}); err != nil {
+			return fmt.Sprintf("%s 恢复默认参数失败：%v", botType, err)
+		}
+		return fmt.Sprintf("%s 已恢复默认参数", botType)
+	}
+	rule, ok := rules[name]
+	if !ok {
+		return fmt.Sprintf("%s 不支持参数 %s，发送 /params 查看可设置的参数", botType, fields[0])
+	}
+
+	params, _ := db.GetGenParams(userId, botType)
+	var reply string
+	if len(fields) == 1 {
+		setParam(&params, name, nil)
+		reply = fmt.Sprintf("%s 的 %s 已恢复默认值", botType, name)
+	} else {
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return fmt.Sprintf("%s 的值必须是数字", name)
+		}
+		if err = rule.Check(v); err != nil {
+			return fmt.Sprintf("%s 的 %s %v", botType, name, err)
+		}
+		setParam(&params, name, &v)
+		reply = fmt.Sprintf("%s 的 %s 已设置为 %s", botType, name, formatParam(v))
+	}
+	if err := db.SetGenParams(userId, botType, params); err != nil {
+		return fmt.Sprintf("%s 设置参数失败：%v", botType, err)
+	}
+	return reply
+}
+
+// ShowParamsCommand /params：查看当前机器人生效的生成参数
+func ShowParamsCommand(param, userId string) string {
+	botType := config.GetUserBotType(userId)
+	rules := providerParams(botType)
+	if len(rules) == 0 {
+		return fmt.Sprintf("%s 不支持设置生成参数", botType)
+	}
+	stored, _ := db.GetGenParams(userId, botType)
+	effective := GenerationParams(botType, userId)
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s 的生成参数：\n", botType))
+	for _, name := range paramNames {
+		rule, ok := rules[name]
+		if !ok {
+			continue
+		}
+		value := "接口默认"
+		if v, ok := getParam(effective, name); ok {
+			value = formatParam(v)
+			if _, custom := getParam(stored, name); custom {
+				value += "(自定义)"
+			}
+		}
+		sb.WriteString(fmt.Sprintf("%s：%s，范围 %s\n", name, value, rule.rangeString()))
+	}
+	sb.WriteString("发送 /set 参数 值 修改，/set reset 恢复默认")
+	return sb.String()
+}
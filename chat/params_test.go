@@ -0,0 +1,73 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func TestParamRule(t *testing.T) {
+	r := ParamRule{Min: 0, Max: 1, MinExclusive: true}
+	for _, v := range []float64{0, 1.5, -1} {
+		if r.Check(v) == nil {
+			t.Errorf("%v should be out of range %s", v, r.rangeString())
+		}
+	}
+	if err := r.Check(1); err != nil {
+		t.Errorf("1 should be valid: %v", err)
+	}
+	if err := (ParamRule{Min: 1, Max: 6, Integer: true}).Check(2.5); err == nil {
+		t.Error("integer params should reject fractions")
+	}
+}
+
+func TestGenerationParams(t *testing.T) {
+	old := db.StoreInstance
+	db.StoreInstance = db.NewMemoryStore()
+	defer func() { db.StoreInstance = old }()
+	t.Setenv("botType", config.Bot_Type_Qwen)
+	t.Setenv("maxOutput", "500")
+	t.Setenv("ADDME_PASSWORD", "")
+	const user = "params-user"
+
+	p := GenerationParams(config.Bot_Type_Qwen, user)
+	if p.Temperature == nil || *p.Temperature != 0.85 || p.TopP == nil || *p.TopP != 0.8 {
+		t.Errorf("qwen defaults should apply: %+v", p)
+	}
+	if p.MaxTokens == nil || *p.MaxTokens != 500 {
+		t.Errorf("maxOutput should be the default max_tokens: %+v", p.MaxTokens)
+	}
+	if p.TopK != nil {
+		t.Errorf("top_k has no default and should not be sent: %v", *p.TopK)
+	}
+
+	if r := SetParamCommand("temperature 2", user); !strings.Contains(r, "[0, 2)") {
+		t.Errorf("expected range error, got %q", r)
+	}
+	if r := SetParamCommand("temp 0.3", user); !strings.Contains(r, "0.3") {
+		t.Errorf("expected temperature to be set, got %q", r)
+	}
+	SetParamCommand("top_k 20", user)
+	p = GenerationParams(config.Bot_Type_Qwen, user)
+	if *p.Temperature != 0.3 || p.TopK == nil || *p.TopK != 20 {
+		t.Errorf("user params should override defaults: %+v", p)
+	}
+	if r := ShowParamsCommand("", user); !strings.Contains(r, "temperature：0.3(自定义)") {
+		t.Errorf("unexpected /params reply %q", r)
+	}
+	// 其它机器人的参数互不影响
+	if p := GenerationParams(config.Bot_Type_Spark, user); *p.Temperature != 0.8 || *p.TopK != 6 {
+		t.Errorf("spark should keep its own defaults: %+v", p)
+	}
+
+	SetParamCommand("temperature", user)
+	if p = GenerationParams(config.Bot_Type_Qwen, user); *p.Temperature != 0.85 || *p.TopK != 20 {
+		t.Errorf("resetting one param should keep the others: %+v", p)
+	}
+	SetParamCommand("reset", user)
+	if stored, _ := db.GetGenParams(user, config.Bot_Type_Qwen); !stored.IsEmpty() {
+		t.Errorf("reset should clear all params: %+v", stored)
+	}
+}
@@ -0,0 +1,314 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	maxPrivatePersonas  = 10
+	personaPreviewRunes = 20
+
+	personaCommandHelp = "/persona list：查看角色\n/persona use 名称：使用角色\n/persona off：停止使用角色\n/persona show [名称]：查看角色详情\n" +
+		"/persona save 名称 [bot=机器人] [model=模型] [temperature=温度] [welcome=欢迎语] prompt=内容：保存私有角色\n" +
+		"/persona add 名称 ...：添加共享角色(管理员)，参数同 save\n/persona del 名称：删除角色"
+)
+
+// resolvePersona 按名称查找角色，用户私有的角色优先于共享的角色
+func resolvePersona(userId, name string) (persona *db.Persona, private bool, err error) {
+	persona, err = db.GetPersona(userId, name)
+	if err == nil {
+		return persona, true, nil
+	} else if !errors.Is(err, db.ErrPersonaNotFound) {
+		return nil, false, err
+	}
+	persona, err = db.GetPersona("", name)
+	return persona, false, err
+}
+
+// ActivePersona 获取用户当前使用的角色，未使用或角色已被删除时返回 nil
+func ActivePersona(userId string) *db.Persona {
+	name, err := db.GetActivePersona(userId)
+	if err != nil || name == "" {
+		return nil
+	}
+	persona, _, err := resolvePersona(userId, name)
+	if err != nil {
+		return nil
+	}
+	return persona
+}
+
+// systemPrompt 当前角色的 prompt 优先，其次是 /prompt 设置的 prompt，最后是默认 prompt
+func systemPrompt(botType, userId string) string {
+	if persona := ActivePersona(userId); persona != nil && persona.Prompt != "" {
+		return persona.Prompt
+	}
+	prompt, err := db.GetPrompt(userId, botType)
+	if err != nil || prompt == "" {
+		prompt = config.GetDefaultSystemPrompt()
+	}
+	return prompt
+}
+
+// userModel 用户在机器人上使用的模型：/setmodel 设置的模型优先，其次是当前角色为该机器人指定的模型，
+// 都没有时返回空字符串，由机器人使用默认模型
+func userModel(userId, botType string) string {
+	if model, err := db.GetModel(userId, botType); err == nil && model != "" {
+		return model
+	}
+	if persona := ActivePersona(userId); persona != nil && persona.BotType == botType {
+		return persona.Model
+	}
+	return ""
+}
+
+// PersonaCommand /persona 子命令 参数：管理和使用角色
+func PersonaCommand(param, userId string) string {
+	sub, rest, _ := strings.Cut(strings.TrimSpace(param), " ")
+	rest = strings.TrimSpace(rest)
+	switch sub {
+	case "", "list":
+		return listPersonas(userId)
+	case "use":
+		return usePersona(userId, rest)
+	case "off":
+		if err := db.SetActivePersona(userId, ""); err != nil {
+			return fmt.Sprintf("停止使用角色失败：%v", err)
+		}
+		return "已停止使用角色"
+	case "show":
+		return showPersona(userId, rest)
+	case "save":
+		return savePersona(userId, userId, rest)
+	case "add":
+		if !HasRole(userId, RoleAdmin) {
+			return noPermissionReply
+		}
+		return savePersona(userId, "", rest)
+	case "del":
+		return deletePersona(userId, rest)
+	default:
+		return personaCommandHelp
+	}
+}
+
+func listPersonas(userId string) string {
+	shared, err := db.GetPersonas("")
+	if err != nil {
+		return fmt.Sprintf("获取角色失败：%v", err)
+	}
+	private, _ := db.GetPersonas(userId)
+	if len(shared) == 0 && len(private) == 0 {
+		return "还没有角色，发送 /persona save 名称 prompt=内容 保存自己的角色"
+	}
+	active, _ := db.GetActivePersona(userId)
+	var sb strings.Builder
+	write := func(title string, personas []*db.Persona) {
+		if len(personas) == 0 {
+			return
+		}
+		sb.WriteString(title + "\n")
+		for _, p := range personas {
+			sb.WriteString("- " + p.Name)
+			if p.BotType != "" {
+				sb.WriteString("(" + p.BotType + ")")
+			}
+			if preview := personaPreview(p); preview != "" {
+				sb.WriteString("：" + preview)
+			}
+			if p.Name == active {
+				sb.WriteString(" [当前]")
+			}
+			sb.WriteString("\n")
+		}
+	}
+	write("共享角色：", shared)
+	write("我的角色：", private)
+	sb.WriteString("发送 /persona use 名称 使用角色，/persona off 停止使用")
+	return sb.String()
+}
+
+func personaPreview(p *db.Persona) string {
+	r := []rune(p.Prompt)
+	if len(r) > personaPreviewRunes {
+		return string(r[:personaPreviewRunes]) + "..."
+	}
+	return string(r)
+}
+
+func usePersona(userId, name string) string {
+	if name == "" {
+		return "格式：/persona use 名称"
+	}
+	persona, _, err := resolvePersona(userId, name)
+	if err != nil {
+		return fmt.Sprintf("使用角色失败：%v", err)
+	}
+	if persona.BotType != "" && persona.BotType != config.GetUserBotType(userId) {
+		if _, err := CheckBotConfig(persona.BotType); err != nil {
+			return fmt.Sprintf("角色 %s 使用的 %s 不可用：%v", persona.Name, persona.BotType, err)
+		}
+		SwitchUserBot(userId, persona.BotType)
+	}
+	if err = db.SetActivePersona(userId, persona.Name); err != nil {
+		return fmt.Sprintf("使用角色失败：%v", err)
+	}
+	if persona.Welcome != "" {
+		return persona.Welcome
+	}
+	return fmt.Sprintf("已切换到角色 %s", persona.Name)
+}
+
+func showPersona(userId, name string) string {
+	if name == "" {
+		if name, _ = db.GetActivePersona(userId); name == "" {
+			return "当前未使用角色，格式：/persona show 名称"
+		}
+	}
+	persona, private, err := resolvePersona(userId, name)
+	if err != nil {
+		return fmt.Sprintf("查看角色失败：%v", err)
+	}
+	var sb strings.Builder
+	scope := "共享"
+	if private {
+		scope = "私有"
+	}
+	sb.WriteString(fmt.Sprintf("角色：%s(%s)\n", persona.Name, scope))
+	if persona.BotType != "" {
+		sb.WriteString("机器人：" + persona.BotType + "\n")
+	}
+	if persona.Model != "" {
+		sb.WriteString("模型：" + persona.Model + "\n")
+	}
+	if persona.Temperature != nil {
+		sb.WriteString("temperature：" + formatParam(*persona.Temperature) + "\n")
+	}
+	if persona.Welcome != "" {
+		sb.WriteString("欢迎语：" + persona.Welcome + "\n")
+	}
+	sb.WriteString("prompt：" + persona.Prompt)
+	return sb.String()
+}
+
+// parsePersona 解析 名称 [key=value ...] prompt=内容，prompt 之后的内容全部作为 prompt
+func parsePersona(param, userId string) (*db.Persona, error) {
+	head, prompt, _ := strings.Cut(param, "prompt=")
+	fields := strings.Fields(head)
+	if len(fields) == 0 {
+		return nil, errors.New("请填写角色名称")
+	}
+	persona := &db.Persona{Name: fields[0], Prompt: strings.TrimSpace(prompt)}
+	if strings.Contains(persona.Name, "=") {
+		return nil, errors.New("角色名称不能包含 =")
+	}
+	for _, field := range fields[1:] {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("无法识别的参数：%s", field)
+		}
+		switch key {
+		case "bot":
+			if !config.IsSupportBot(val) || IsLocalBot(val) {
+				return nil, fmt.Errorf("%s：不支持的机器人", field)
+			}
+			persona.BotType = val
+		case "model":
+			persona.Model = val
+		case "temperature", "temp":
+			v, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s：必须是数字", field)
+			}
+			persona.Temperature = &v
+		case "welcome":
+			persona.Welcome = val
+		default:
+			return nil, fmt.Errorf("%s：未知的参数", field)
+		}
+	}
+	if persona.Prompt == "" {
+		return nil, errors.New("请填写 prompt=内容")
+	}
+	if persona.Model != "" {
+		if persona.BotType == "" {
+			return nil, errors.New("指定 model 时需要同时指定 bot")
+		}
+		model, err := resolveModel(persona.BotType, userId, persona.Model)
+		if err != nil {
+			return nil, err
+		}
+		persona.Model = model
+	}
+	if persona.Temperature != nil {
+		rule := ParamRule{Min: 0, Max: 2}
+		if persona.BotType != "" {
+			rules := providerParams(persona.BotType)
+			var ok bool
+			if rule, ok = rules[ParamTemperature]; !ok {
+				return nil, fmt.Errorf("%s 不支持设置 temperature", persona.BotType)
+			}
+		}
+		if err := rule.Check(*persona.Temperature); err != nil {
+			return nil, fmt.Errorf("temperature %v", err)
+		}
+	}
+	return persona, nil
+}
+
+// savePersona owner 为空时保存为共享角色
+func savePersona(userId, owner, param string) string {
+	persona, err := parsePersona(param, userId)
+	if err != nil {
+		return err.Error() + "\n" + personaCommandHelp
+	}
+	if owner != "" {
+		personas, err := db.GetPersonas(owner)
+		if err != nil {
+			return fmt.Sprintf("保存角色失败：%v", err)
+		}
+		exists := slices.ContainsFunc(personas, func(p *db.Persona) bool { return p.Name == persona.Name })
+		if !exists && len(personas) >= maxPrivatePersonas {
+			return fmt.Sprintf("最多保存%d个私有角色，请先通过 /persona del 删除", maxPrivatePersonas)
+		}
+	}
+	persona.CreatedBy = userId
+	persona.CreatedAt = time.Now().Unix()
+	if err = db.SavePersona(owner, persona); err != nil {
+		return fmt.Sprintf("保存角色失败：%v", err)
+	}
+	return fmt.Sprintf("已保存角色 %s，发送 /persona use %s 使用", persona.Name, persona.Name)
+}
+
+// deletePersona 优先删除用户私有的角色，删除共享角色需要管理员
+func deletePersona(userId, name string) string {
+	if name == "" {
+		return "格式：/persona del 名称"
+	}
+	_, private, err := resolvePersona(userId, name)
+	if err != nil {
+		return fmt.Sprintf("删除角色失败：%v", err)
+	}
+	owner := userId
+	if !private {
+		if !HasRole(userId, RoleAdmin) {
+			return noPermissionReply
+		}
+		owner = ""
+	}
+	if err = db.DeletePersona(owner, name); err != nil {
+		return fmt.Sprintf("删除角色失败：%v", err)
+	}
+	if active, _ := db.GetActivePersona(userId); active == name {
+		db.SetActivePersona(userId, "")
+	}
+	return fmt.Sprintf("已删除角色 %s", name)
+}
@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func TestPersona(t *testing.T) {
+	old := db.StoreInstance
+	db.StoreInstance = db.NewMemoryStore()
+	defer func() { db.StoreInstance = old }()
+	t.Setenv("ADMIN_USERS", "persona-admin")
+	t.Setenv("ADDME_PASSWORD", "")
+	t.Setenv("WX_TOKEN", "token")
+	t.Setenv("GPT_TOKEN", "sk-test")
+	t.Setenv("botType", config.Bot_Type_Qwen)
+	t.Setenv(config.Model_Catalog_Key, "")
+	const user = "persona-user"
+
+	if r := PersonaCommand("add 翻译官 prompt=你是翻译", user); r != noPermissionReply {
+		t.Errorf("members should not add shared personas, got %q", r)
+	}
+	if r := PersonaCommand("add 翻译官 bot=gpt model=mini temperature=0.2 welcome=Hello prompt=你是一名翻译，把中文翻译成英文", "persona-admin"); !strings.Contains(r, "已保存") {
+		t.Fatalf("admin should add shared persona, got %q", r)
+	}
+	if r := PersonaCommand("save 坏角色 temperature=3 prompt=x", user); !strings.Contains(r, "temperature") {
+		t.Errorf("out of range temperature should be rejected, got %q", r)
+	}
+
+	if r := PersonaCommand("use 翻译官", user); r != "Hello" {
+		t.Fatalf("expected persona welcome, got %q", r)
+	}
+	if bot := config.GetUserBotType(user); bot != config.Bot_Type_Gpt {
+		t.Errorf("persona should switch bot, got %s", bot)
+	}
+	if p := systemPrompt(config.Bot_Type_Gpt, user); !strings.HasPrefix(p, "你是一名翻译") {
+		t.Errorf("persona prompt should be used, got %q", p)
+	}
+	if m := userModel(user, config.Bot_Type_Gpt); m != "gpt-4o-mini" {
+		t.Errorf("persona model should resolve through the catalog, got %q", m)
+	}
+	if m := userModel(user, config.Bot_Type_Qwen); m != "" {
+		t.Errorf("persona model should only apply to its bot, got %q", m)
+	}
+	if p := GenerationParams(config.Bot_Type_Gpt, user); p.Temperature == nil || *p.Temperature != 0.2 {
+		t.Errorf("persona temperature should apply, got %+v", p.Temperature)
+	}
+	if r := PersonaCommand("list", user); !strings.Contains(r, "翻译官(gpt)") || !strings.Contains(r, "[当前]") {
+		t.Errorf("unexpected list %q", r)
+	}
+
+	// 私有角色优先于同名的共享角色
+	PersonaCommand("save 翻译官 prompt=私有翻译", user)
+	if p := systemPrompt(config.Bot_Type_Gpt, user); p != "私有翻译" {
+		t.Errorf("private persona should win, got %q", p)
+	}
+	PersonaCommand("del 翻译官", user)
+	if p := systemPrompt(config.Bot_Type_Gpt, user); p != "" && !strings.HasPrefix(p, config.GetDefaultSystemPrompt()) {
+		t.Errorf("deleting the active persona should stop using it, got %q", p)
+	}
+	if r := PersonaCommand("del 翻译官", user); r != noPermissionReply {
+		t.Errorf("members should not delete shared personas, got %q", r)
+	}
+
+	for i := 0; i < maxPrivatePersonas; i++ {
+		PersonaCommand(fmt.Sprintf("save p%d prompt=x", i), user)
+	}
+	if r := PersonaCommand("save extra prompt=x", user); !strings.Contains(r, "最多") {
+		t.Errorf("private persona limit should apply, got %q", r)
+	}
+}
@@ -0,0 +1,187 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+)
+
+// Capabilities 描述机器人支持的能力
+type Capabilities struct {
+	Prompt bool // 支持 system prompt
+	Model  bool // 支持 /setmodel 自定义模型
+	Image  bool // 支持图片输入
+	Voice  bool // 支持语音输入，机器人需实现 VoiceChat
+}
+
+// Provider 描述一种机器人，新增机器人只需在各自文件的 init 中调用 RegisterProvider
+type Provider struct {
+	Name         string        // 机器人类型，即 botType
+	Title        string        // 展示名称
+	Command      string        // 切换到该机器人的命令，为空则不注册命令
+	Description  string        // 帮助文本中命令的说明
+	EventKey     func() string // 自定义菜单点击事件的 key，可选
+	Local        bool          // 本地实现，不调用 AI 接口，/ai 不会切换到此类机器人
+	Capabilities Capabilities
+	Params       map[string]ParamRule // 支持通过 /set 设置的生成参数
+	CheckConfig  func() error
+	Welcome      func() string
+	New          func() BaseChat
+
+	openAIProfile bool // 由 OPENAI_PROFILES 注册，可被同名 profile 覆盖
+}
+
+// VoiceChat 支持语音输入的机器人需要实现的接口
+type VoiceChat interface {
+	ChatWithVoice(ctx context.Context, userId string, voiceData []byte) string
+}
+
+var providers []*Provider
+
+// RegisterProvider 注册机器人，同名机器人会被覆盖
+func RegisterProvider(p *Provider) {
+	if p.Welcome == nil {
+		p.Welcome = func() string { return p.Name }
+	}
+	if idx := slices.IndexFunc(providers, func(e *Provider) bool { return e.Name == p.Name }); idx >= 0 {
+		providers[idx] = p
+	} else {
+		providers = append(providers, p)
+	}
+	if !slices.Contains(config.Support_Bots, p.Name) {
+		config.Support_Bots = append(config.Support_Bots, p.Name)
+	}
+	if p.Command != "" {
+		// 机器人的命令在帮助中按 Description 列出
+		registerAction(p.Command, "", "", simpleAction(func(param, userId string) string {
+			return SwitchUserBot(userId, p.Name)
+		}))
+	}
+}
+
+// GetProvider 按机器人类型查找已注册的机器人
+func GetProvider(botType string) (*Provider, bool) {
+	config.LoadBots()
+	return findProvider(botType)
+}
+
+// findProvider 查找机器人，不触发 OPENAI_PROFILES 的注册
+func findProvider(botType string) (*Provider, bool) {
+	idx := slices.IndexFunc(providers, func(p *Provider) bool { return p.Name == botType })
+	if idx < 0 {
+		return nil, false
+	}
+	return providers[idx], true
+}
+
+// Providers 返回按注册顺序排列的所有机器人
+func Providers() []*Provider {
+	config.LoadBots()
+	return providers
+}
+
+// GetCapabilities 未注册的机器人不支持任何能力
+func GetCapabilities(botType string) Capabilities {
+	if p, ok := GetProvider(botType); ok {
+		return p.Capabilities
+	}
+	return Capabilities{}
+}
+
+// CommandsWith 返回具备指定能力的机器人的切换命令
+func CommandsWith(f func(c Capabilities) bool) []string {
+	var commands []string
+	for _, p := range Providers() {
+		if p.Command != "" && f(p.Capabilities) {
+			commands = append(commands, p.Command)
+		}
+	}
+	return commands
+}
+
+func IsSupportPrompt(botType string) bool {
+	return GetCapabilities(botType).Prompt
+}
+
+func IsLocalBot(botType string) bool {
+	p, ok := GetProvider(botType)
+	return !ok || p.Local
+}
+
+func CheckBotConfig(botType string) (actualBotType string, err error) {
+	if botType == "" {
+		botType = config.GetBotType()
+	}
+	actualBotType = botType
+	if p, ok := GetProvider(botType); ok && p.CheckConfig != nil {
+		err = p.CheckConfig()
+	}
+	return
+}
+
+func CheckAllBotConfig() (botType string, checkRes map[string]bool) {
+	botType = config.GetBotType()
+	checkRes = make(map[string]bool)
+	for _, p := range Providers() {
+		checkRes[p.Name] = p.CheckConfig == nil || p.CheckConfig() == nil
+	}
+	return
+}
+
+// botTitle 机器人的展示名称，未设置时使用机器人类型
+func botTitle(botType string) string {
+	if p, ok := GetProvider(botType); ok && p.Title != "" {
+		return p.Title
+	}
+	return botType
+}
+
+func GetBotWelcomeReply(botType string) string {
+	if p, ok := GetProvider(botType); ok {
+		return p.Welcome()
+	}
+	return botType
+}
+
+// getBotByEventKey 根据自定义菜单的事件 key 查找对应的机器人
+func getBotByEventKey(eventKey string) (*Provider, bool) {
+	for _, p := range Providers() {
+		if p.EventKey != nil && p.EventKey() != "" && p.EventKey() == eventKey {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// GetHelpReply 未配置 WX_HELP_REPLY 时，根据已注册的机器人和命令生成帮助文本，只列出用户有权限执行的命令
+func GetHelpReply(userId string) string {
+	if helpMsg := config.GetWxHelpReply(); helpMsg != "" {
+		return helpMsg
+	}
+	level := roleLevel(GetUserRole(userId))
+	allowed := func(command string) bool {
+		return level >= roleLevel(requiredRole(command))
+	}
+	var sb strings.Builder
+	sb.WriteString("输入以下命令进行对话")
+	for _, p := range Providers() {
+		if p.Command != "" && allowed(p.Command) {
+			sb.WriteString(fmt.Sprintf("\n%s：%s", p.Command, p.Description))
+		}
+	}
+	for _, command := range helpCommands {
+		if !allowed(command) {
+			continue
+		}
+		entry := actionMap[command]
+		usage := entry.usage
+		if usage == "" {
+			usage = command
+		}
+		sb.WriteString(fmt.Sprintf("\n%s：%s", usage, entry.help))
+	}
+	return sb.String()
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+)
+
+func TestBuiltinProviders(t *testing.T) {
+	for _, botType := range []string{config.Bot_Type_Gpt, config.Bot_Type_Spark, config.Bot_Type_Qwen,
+		config.Bot_Type_Gemini, config.Bot_Type_Claude, config.Bot_Type_Keyword, config.Bot_Type_Echo} {
+		if !slices.Contains(config.Support_Bots, botType) {
+			t.Errorf("%s is not registered", botType)
+		}
+	}
+	if !IsSupportPrompt(config.Bot_Type_Gpt) || IsSupportPrompt(config.Bot_Type_Gemini) {
+		t.Error("unexpected prompt capability")
+	}
+	if !GetCapabilities(config.Bot_Type_Gemini).Image || !IsLocalBot(config.Bot_Type_Keyword) {
+		t.Error("unexpected gemini or keyword capability")
+	}
+}
+
+func TestRegisterProvider(t *testing.T) {
+	RegisterProvider(&Provider{
+		Name:         "inhouse",
+		Title:        "内部模型",
+		Command:      "/inhouse",
+		Description:  "与内部模型对话",
+		Capabilities: Capabilities{Prompt: true},
+		CheckConfig:  func() error { return errors.New("请配置inhouseKey") },
+		Welcome:      func() string { return "我是内部模型" },
+		New:          func() BaseChat { return &Echo{} },
+	})
+
+	if _, ok := actionMap["/inhouse"]; !ok {
+		t.Error("switch command is not registered")
+	}
+	if !strings.Contains(GetHelpReply("someone"), "/inhouse：与内部模型对话") {
+		t.Error("help reply does not list the provider")
+	}
+	if _, res := CheckAllBotConfig(); res["inhouse"] {
+		t.Error("expected config check to fail")
+	}
+	if _, ok := GetChatBot("inhouse").(*ErrorChat); !ok {
+		t.Error("expected ErrorChat for unconfigured provider")
+	}
+	if !IsSupportPrompt("inhouse") {
+		t.Error("expected prompt capability")
+	}
+}
+
+func TestGetHelpReply(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root1")
+	t.Setenv("ADDME_PASSWORD", "")
+	t.Setenv("WX_HELP_REPLY", "")
+
+	help := GetHelpReply("someone")
+	for _, line := range []string{"/help：查看帮助", "/new 名称：新建会话", "/quota：查看今日额度"} {
+		if !strings.Contains(help, line) {
+			t.Errorf("help reply does not list %q", line)
+		}
+	}
+	if strings.Contains(help, config.Wx_Command_Prompt) || strings.Contains(help, config.Wx_Command_Grant) {
+		t.Error("members should not see operator or admin commands")
+	}
+	if !strings.Contains(GetHelpReply("root1"), "/grant 用户openid 角色：授予角色") {
+		t.Error("admins should see admin commands")
+	}
+	// 已注册的命令都应有处理方法
+	for _, command := range helpCommands {
+		if actionMap[command].run == nil {
+			t.Errorf("%s has no handler", command)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	quotaRpmReply         = "您发送得太快了，请稍后再试。"
+	quotaMsgsReply        = "您今天的提问次数已用完(%d次)，明天再来吧。发送 /quota 查看额度。"
+	quotaTokensReply      = "您今天的token额度已用完(%d)，明天再来吧。发送 /quota 查看额度。"
+	quotaGlobalRpmReply   = "当前使用的人太多了，请稍后再试。"
+	quotaGlobalDailyReply = "今天的AI额度已经用完了，明天再来吧。"
+)
+
+// quotaNow 便于测试时替换当前时间
+var quotaNow = time.Now
+
+type quotaScope struct {
+	userId string
+	limit  config.QuotaLimit
+	global bool
+}
+
+func quotaScopes(userId string) []quotaScope {
+	tier, _ := db.GetUserTier(userId)
+	return []quotaScope{
+		{userId: userId, limit: config.GetQuotaLimit(tier)},
+		{userId: db.QUOTA_GLOBAL_USER, limit: config.GetGlobalQuotaLimit(), global: true},
+	}
+}
+
+// CheckQuota 在调用机器人之前检查并占用本次请求的额度，超出限制时返回提示语。
+// 本地机器人和管理员不受限制，存储不可用时不做限制
+func CheckQuota(userId, botType string) (reply string, ok bool) {
+	if IsLocalBot(botType) || HasRole(userId, RoleAdmin) || db.StoreInstance == nil {
+		return "", true
+	}
+	now := quotaNow()
+	scopes := quotaScopes(userId)
+
+	// token 用量在回复之后才知道，这里只检查是否已经用完
+	for _, s := range scopes {
+		if s.limit.DailyTokens <= 0 {
+			continue
+		}
+		used, err := db.GetQuota(db.QuotaTokens, s.userId, now)
+		if err == nil && used >= s.limit.DailyTokens {
+			if s.global {
+				return quotaGlobalDailyReply, false
+			}
+			return fmt.Sprintf(quotaTokensReply, s.limit.DailyTokens), false
+		}
+	}
+
+	// 被拒绝的请求不占用额度，已增加的计数需要回退
+	type incr struct{ counter, userId string }
+	var done []incr
+	rollback := func() {
+		for _, d := range done {
+			db.IncrQuota(d.counter, d.userId, -1, now)
+		}
+	}
+	for _, s := range scopes {
+		for _, c := range []struct {
+			counter string
+			limit   int64
+		}{{db.QuotaRequests, s.limit.Rpm}, {db.QuotaMsgs, s.limit.DailyMsgs}} {
+			if c.limit <= 0 {
+				continue
+			}
+			n, err := db.IncrQuota(c.counter, s.userId, 1, now)
+			if err != nil {
+				fmt.Println("incr quota error:", err)
+				continue
+			}
+			done = append(done, incr{c.counter, s.userId})
+			if n <= c.limit {
+				continue
+			}
+			rollback()
+			switch {
+			case s.global && c.counter == db.QuotaRequests:
+				return quotaGlobalRpmReply, false
+			case s.global:
+				return quotaGlobalDailyReply, false
+			case c.counter == db.QuotaRequests:
+				return quotaRpmReply, false
+			default:
+				return fmt.Sprintf(quotaMsgsReply, c.limit), false
+			}
+		}
+	}
+	return "", true
+}
+
+// RecordTokenUsage 记录用户本次对话消耗的token数，计入每日token额度
+func RecordTokenUsage(userId string, tokens int) {
+	if db.StoreInstance == nil || tokens <= 0 {
+		return
+	}
+	now := quotaNow()
+	for _, id := range []string{userId, db.QUOTA_GLOBAL_USER} {
+		if _, err := db.IncrQuota(db.QuotaTokens, id, int64(tokens), now); err != nil {
+			fmt.Println("record token usage error:", err)
+		}
+	}
+}
+
+// GetQuotaReply /quota 查看自己的额度使用情况
+func GetQuotaReply(param, userId string) string {
+	if db.StoreInstance == nil {
+		return "存储不可用，无法统计额度"
+	}
+	now := quotaNow()
+	tier, _ := db.GetUserTier(userId)
+	if tier == "" {
+		tier = config.Quota_Default_Tier
+	}
+	limit := config.GetQuotaLimit(tier)
+
+	var sb strings.Builder
+	if HasRole(userId, RoleAdmin) {
+		sb.WriteString("您是管理员，不受额度限制\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("额度等级：%s\n", tier))
+	}
+	for _, item := range []struct {
+		title   string
+		counter string
+		limit   int64
+	}{
+		{"每分钟请求", db.QuotaRequests, limit.Rpm},
+		{"今日消息", db.QuotaMsgs, limit.DailyMsgs},
+		{"今日token", db.QuotaTokens, limit.DailyTokens},
+	} {
+		used, _ := db.GetQuota(item.counter, userId, now)
+		if item.limit <= 0 || HasRole(userId, RoleAdmin) {
+			sb.WriteString(fmt.Sprintf("%s：已用%d，不限\n", item.title, used))
+		} else {
+			sb.WriteString(fmt.Sprintf("%s：已用%d，剩余%d\n", item.title, used, max(item.limit-used, 0)))
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// SetUserTier /tier 用户openid 等级：管理员设置用户的额度等级，省略等级时恢复默认
+func SetUserTier(param, userId string) string {
+	fields := strings.Fields(param)
+	if len(fields) == 0 || len(fields) > 2 {
+		return "格式：/tier 用户openid 等级，省略等级则恢复默认等级"
+	}
+	tier := ""
+	if len(fields) == 2 && fields[1] != config.Quota_Default_Tier {
+		tier = fields[1]
+		if _, ok := config.GetQuotaTiers()[tier]; !ok {
+			return fmt.Sprintf("未知的额度等级：%s，请先在 %s 中配置", tier, config.Quota_Tiers_Key)
+		}
+	}
+	if err := db.SetUserTier(fields[0], tier); err != nil {
+		return fmt.Sprintf("设置额度等级失败：%v", err)
+	}
+	if tier == "" {
+		tier = config.Quota_Default_Tier
+	}
+	return fmt.Sprintf("已将用户 %s 的额度等级设置为 %s", fields[0], tier)
+}
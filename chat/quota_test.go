@@ -0,0 +1,64 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func TestQuotaTiers(t *testing.T) {
+	t.Setenv("QUOTA_RPM", "5")
+	t.Setenv("QUOTA_DAILY_MSGS", "100")
+	t.Setenv("QUOTA_TIERS", `{"vip":{"rpm":20,"daily_tokens":200000}}`)
+
+	if l := config.GetQuotaLimit(""); l.Rpm != 5 || l.DailyMsgs != 100 || l.DailyTokens != 0 {
+		t.Errorf("unexpected default tier: %+v", l)
+	}
+	if l := config.GetQuotaLimit("vip"); l.Rpm != 20 || l.DailyMsgs != 0 || l.DailyTokens != 200000 {
+		t.Errorf("unexpected vip tier: %+v", l)
+	}
+	if l := config.GetQuotaLimit("unknown"); l.Rpm != 5 {
+		t.Errorf("unknown tier should fall back to default: %+v", l)
+	}
+	if !config.GetGlobalQuotaLimit().IsUnlimited() {
+		t.Error("global quota should be unlimited when not configured")
+	}
+
+	if res := SetUserTier("openid gold", "admin"); !strings.Contains(res, "未知的额度等级") {
+		t.Errorf("expected unknown tier to be rejected, got %q", res)
+	}
+	if res := SetUserTier("", "admin"); !strings.HasPrefix(res, "格式") {
+		t.Errorf("expected usage hint, got %q", res)
+	}
+}
+
+func TestCheckQuotaLocalBot(t *testing.T) {
+	t.Setenv("QUOTA_RPM", "1")
+	for i := 0; i < 3; i++ {
+		if _, ok := CheckQuota("quota-user", config.Bot_Type_Keyword); !ok {
+			t.Fatal("local bots should not consume quota")
+		}
+	}
+}
+
+func TestCheckQuotaMemoryStore(t *testing.T) {
+	old := db.StoreInstance
+	db.StoreInstance = db.NewMemoryStore()
+	defer func() { db.StoreInstance = old }()
+	t.Setenv("QUOTA_RPM", "2")
+
+	for i := 0; i < 2; i++ {
+		if reply, ok := CheckQuota("quota-mem-user", config.Bot_Type_Gpt); !ok {
+			t.Fatalf("request %d should pass, got %q", i+1, reply)
+		}
+	}
+	if reply, ok := CheckQuota("quota-mem-user", config.Bot_Type_Gpt); ok || reply != quotaRpmReply {
+		t.Fatalf("expected rpm limit, got %q %v", reply, ok)
+	}
+	// 被拒绝的请求已回退，计数保持在上限
+	if n, _ := db.GetQuota(db.QuotaRequests, "quota-mem-user", quotaNow()); n != 2 {
+		t.Errorf("expected rejected request to be rolled back, got %d", n)
+	}
+}
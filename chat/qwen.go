@@ -2,9 +2,12 @@ package chat
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/bytedance/sonic"
 	"github.com/pwh-pwh/aiwechat-vercel/config"
@@ -16,16 +19,51 @@ const (
 	QwenChatBot  = "assistant"
 )
 
+func init() {
+	RegisterProvider(&Provider{
+		Name:         config.Bot_Type_Qwen,
+		Title:        "通义千问",
+		Command:      config.Wx_Command_Qwen,
+		Description:  "与通义千问对话",
+		EventKey:     config.GetWxEventKeyChatQwen,
+		Capabilities: Capabilities{Prompt: true, Model: true},
+		Params: map[string]ParamRule{
+			ParamTemperature: {Min: 0, Max: 2, MaxExclusive: true, Default: 0.85}, // 不建议取值为0，无意义
+			ParamTopP:        {Min: 0, Max: 1, MinExclusive: true, MaxExclusive: true, Default: 0.8},
+			ParamTopK:        {Min: 1, Max: 100, Integer: true},
+			ParamMaxTokens:   {Min: 1, Max: 8192, Integer: true},
+		},
+		CheckConfig: func() error {
+			_, err := config.GetQwenConfig()
+			return err
+		},
+		Welcome: config.GetQwenWelcomeReply,
+		New: func() BaseChat {
+			cfg, _ := config.GetQwenConfig()
+			return &QwenChat{
+				BaseChat: SimpleChat{},
+				Config:   cfg,
+			}
+		},
+	})
+}
+
 type QwenChat struct {
 	BaseChat
-	Config    *config.QwenConfig
-	maxTokens int
+	Config *config.QwenConfig
 }
 
 type QwenRequest struct {
-	Model      string        `json:"model"`
-	Message    []QwenMessage `json:"messages"`
-	Parameters Parameters    `json:"parameters"`
+	Model         string             `json:"model"`
+	Message       []QwenMessage      `json:"messages"`
+	Parameters    Parameters         `json:"parameters"`
+	Stream        bool               `json:"stream,omitempty"`
+	StreamOptions *QwenStreamOptions `json:"stream_options,omitempty"`
+}
+
+// QwenStreamOptions 兼容模式流式输出时在最后返回用量
+type QwenStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type Input struct {
@@ -37,7 +75,7 @@ type Parameters struct {
 	Seed              int      `json:"seed"`
 	MaxTokens         int      `json:"max_tokens"`
 	TopP              float64  `json:"top_p"`
-	TopK              float64  `json:"top_k"`
+	TopK              float64  `json:"top_k,omitempty"`
 	RepetitionPenalty float64  `json:"repetition_penalty"`
 	Temperature       float64  `json:"temperature"`
 	Stop              string   `json:"stop"`
@@ -51,20 +89,43 @@ type QwenMessage struct {
 	Content string `json:"content"`
 }
 
+// QwenResponse 兼容模式的回复在 choices 中，原生接口的回复在 output.choices 中
 type QwenResponse struct {
-	Choices   []Choices `json:"choices"`
-	Usage     Usage     `json:"usage"`
-	RequestID string    `json:"id"`
+	Choices []Choices `json:"choices"`
+	Output  struct {
+		Choices []Choices `json:"choices"`
+	} `json:"output"`
+	Usage     Usage  `json:"usage"`
+	RequestID string `json:"id"`
+	// 原生接口流式输出中途出错时返回错误码和原因
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Text 回复的文本，流式输出时为增量文本
+func (r *QwenResponse) Text() string {
+	choices := r.Choices
+	if len(choices) == 0 {
+		choices = r.Output.Choices
+	}
+	if len(choices) == 0 {
+		return ""
+	}
+	return choices[0].Delta.Content + choices[0].Message.Content
 }
 
 type Choices struct {
 	Message      QwenMessage `json:"message"`
+	Delta        QwenMessage `json:"delta"` // 兼容模式流式输出的增量
 	FinishReason string      `json:"finish_reason"`
 }
 
+// Usage 原生接口返回 input_tokens/output_tokens，兼容模式返回 prompt_tokens/completion_tokens
 type Usage struct {
-	OutputTokens int `json:"output_tokens"`
-	InputTokens  int `json:"input_tokens"`
+	OutputTokens     int `json:"output_tokens"`
+	InputTokens      int `json:"input_tokens"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
 }
 
 func (s *QwenChat) toDbMsg(msg QwenMessage) db.Msg {
@@ -88,83 +149,132 @@ func (s *QwenChat) toChatMsg(msg db.Msg) QwenMessage {
 }
 
 func (chat *QwenChat) getModel(userId string) string {
-	if model, err := db.GetModel(userId, config.Bot_Type_Qwen); err == nil && model != "" {
+	if model := userModel(userId, config.Bot_Type_Qwen); model != "" {
 		return model
 	}
 	return chat.Config.ModelVersion
 }
 
-func (chat *QwenChat) Chat(userId, message string, imageURL ...string) (res string) {
-	r, flag := DoAction(userId, message)
+func (chat *QwenChat) Chat(ctx context.Context, userId, message string, imageURL ...string) (res string) {
+	r, flag := DoAction(ctx, userId, message)
 	if flag {
 		return r
 	}
-	return WithTimeChat(userId, message, chat.chat)
+	return WithTimeChat(ctx, userId, message, func(ctx context.Context, userId, message string, stream *ReplyStream) string {
+		return chatWithFailover(ctx, config.Bot_Type_Qwen, chat, userId, message, stream)
+	})
 }
 
-func (chat *QwenChat) chat(userId string, message string) (res string) {
+func (chat *QwenChat) reply(ctx context.Context, userId string, message string, stream *ReplyStream, imageURL ...string) (res string, err error) {
 	var msgs = GetMsgListWithDb(config.Bot_Type_Qwen, userId, QwenMessage{
 		Role:    QwenChatUser,
 		Content: message,
 	}, chat.toDbMsg, chat.toChatMsg)
 
+	res, err = chat.complete(ctx, userId, msgs, stream)
+	if err != nil {
+		return "", err
+	}
+
+	msgs = append(msgs, QwenMessage{
+		Role:    QwenChatBot,
+		Content: res,
+	})
+	SaveMsgListWithDb(ctx, config.Bot_Type_Qwen, userId, msgs, chat.toDbMsg)
+	return
+}
+
+// Complete 单次补全，不读取也不保存历史
+func (chat *QwenChat) Complete(ctx context.Context, userId, prompt string) (string, error) {
+	return chat.complete(ctx, userId, []QwenMessage{{Role: QwenChatUser, Content: prompt}}, nil)
+}
+
+// complete 流式补全：原生接口通过 X-DashScope-SSE 和 incremental_output 开启，兼容模式通过 stream 开启
+func (chat *QwenChat) complete(ctx context.Context, userId string, msgs []QwenMessage, stream *ReplyStream) (string, error) {
 	qwenReq := QwenRequest{
-		Model:   chat.getModel(userId),
-		Message: msgs,
+		Model:         chat.getModel(userId),
+		Message:       msgs,
+		Stream:        true,
+		StreamOptions: &QwenStreamOptions{IncludeUsage: true},
 	}
-	// 如果设置了环境变量且合法，则增加maxTokens参数，否则不设置
-	if chat.maxTokens > 0 {
-		qwenReq.Parameters.MaxTokens = chat.maxTokens // 参数名称参考：https://help.aliyun.com/zh/dashscope/developer-reference/api-details
+	qwenReq.Parameters.IncrementalOutput = true
+	// 参数名称参考：https://help.aliyun.com/zh/dashscope/developer-reference/api-details
+	params := GenerationParams(config.Bot_Type_Qwen, userId)
+	if params.MaxTokens != nil {
+		qwenReq.Parameters.MaxTokens = *params.MaxTokens
+	}
+	if params.TopP != nil {
+		qwenReq.Parameters.TopP = *params.TopP
+	}
+	if params.TopK != nil {
+		qwenReq.Parameters.TopK = float64(*params.TopK)
+	}
+	if params.Temperature != nil {
+		qwenReq.Parameters.Temperature = *params.Temperature
 	}
-	qwenReq.Parameters.TopP = 0.8              // 通义千问要求top_p ∈ (0,1)
 	qwenReq.Parameters.RepetitionPenalty = 1.1 // 用于控制模型生成时的重复度，需要大于0。提高repetition_penalty时可以降低模型生成的重复度。1.0表示不做惩罚。默认为1.1。
-	qwenReq.Parameters.Temperature = 0.85      // 取值范围：[0, 2)，系统默认值0.85。不建议取值为0，无意义。
 
 	body, _ := sonic.Marshal(qwenReq)
 
 	fmt.Println(string(body))
-	req, err := http.NewRequest("POST", chat.Config.HostUrl, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", chat.Config.HostUrl, bytes.NewReader(body))
 	if err != nil {
-		res = fmt.Sprintf("NewRequest failed,err:%v", err.Error())
-		return
+		return "", fmt.Errorf("NewRequest failed,err:%v", err.Error())
 	}
 	// 设置请求头
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+chat.Config.ApiKey)
+	req.Header.Set("X-DashScope-SSE", "enable")
 	client := http.Client{}
 	// 发送请求
-	var resp *http.Response
-	resp, err = client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		res = fmt.Sprintf("client.Do failed,err:%v", err.Error())
+		return "", fmt.Errorf("client.Do failed,err:%w", err)
 	}
 	defer resp.Body.Close()
 
-	var rpnBody []byte
-	rpnBody, err = io.ReadAll(resp.Body)
-	if err != nil {
-		res = fmt.Sprintf("read http response failed,error=%v", err.Error())
-		return
-	}
 	if resp.StatusCode != http.StatusOK {
-		res = string(rpnBody)
-		return
+		rpnBody, _ := io.ReadAll(resp.Body)
+		return "", newHTTPError(config.Bot_Type_Qwen, resp.StatusCode, rpnBody)
 	}
 
-	// 读取响应
-	var qwenRpn QwenResponse
-	err = sonic.Unmarshal(rpnBody, &qwenRpn)
+	// 读取响应，未开启流式输出的接口直接返回完整的 json
+	var content strings.Builder
+	var usage Usage
+	handle := func(data []byte) error {
+		var qwenRpn QwenResponse
+		if err := sonic.Unmarshal(data, &qwenRpn); err != nil {
+			return fmt.Errorf("Unmarshal response body failed,err:%v", err.Error())
+		}
+		if qwenRpn.Code != "" {
+			return classifyError(config.Bot_Type_Qwen, fmt.Errorf("%s: %s", qwenRpn.Code, qwenRpn.Message))
+		}
+		text := qwenRpn.Text()
+		content.WriteString(text)
+		stream.Write(text)
+		// 原生接口每条都返回累计的用量，兼容模式只在最后一条返回
+		if qwenRpn.Usage != (Usage{}) {
+			usage = qwenRpn.Usage
+		}
+		return nil
+	}
+	if strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+		err = readSSE(resp.Body, func(data string) error { return handle([]byte(data)) })
+	} else {
+		var rpnBody []byte
+		if rpnBody, err = io.ReadAll(resp.Body); err == nil {
+			err = handle(rpnBody)
+		}
+	}
 	if err != nil {
-		res = fmt.Sprintf("Unmarshal response body failed,err:%v", err.Error())
-		return
+		return "", err
 	}
-
-	res = qwenRpn.Choices[0].Message.Content
-
-	msgs = append(msgs, QwenMessage{
-		Role:    QwenChatBot,
-		Content: res,
+	RecordUsage(userId, config.Bot_Type_Qwen, qwenReq.Model, TokenUsage{
+		PromptTokens:     usage.InputTokens + usage.PromptTokens,
+		CompletionTokens: usage.OutputTokens + usage.CompletionTokens,
 	})
-	SaveMsgListWithDb(config.Bot_Type_Qwen, userId, msgs, chat.toDbMsg)
-	return
-}
\ No newline at end of file
+	if content.Len() == 0 {
+		return "", errors.New("empty response choices")
+	}
+	return content.String(), nil
+}
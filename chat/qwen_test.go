@@ -1,7 +1,10 @@
 package chat
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/joho/godotenv"
@@ -19,7 +22,33 @@ func TestQwenChat(t *testing.T) {
 		Config:   config,
 	}
 
-	res := chat.Chat("testUser", "用10个字描述你的能力")
+	res := chat.Chat(context.Background(), "testUser", "用10个字描述你的能力")
 
 	fmt.Println(res)
 }
+
+func TestQwenStream(t *testing.T) {
+	// 原生接口每条返回增量文本和累计用量，兼容模式返回 delta 并以 [DONE] 结束
+	bodies := map[string]string{
+		"native": "id:1\nevent:result\ndata:{\"output\":{\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":\"你好\"}}]},\"usage\":{\"input_tokens\":3,\"output_tokens\":1}}\n\n" +
+			"id:2\nevent:result\ndata:{\"output\":{\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":\"，世界\"}}]},\"usage\":{\"input_tokens\":3,\"output_tokens\":2}}\n\n",
+		"compatible": "data: {\"choices\":[{\"delta\":{\"content\":\"你好\"}}]}\n\n" +
+			"data: {\"choices\":[{\"delta\":{\"content\":\"，世界\"}}]}\n\ndata: [DONE]\n\n",
+	}
+	for name, body := range bodies {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-DashScope-SSE") != "enable" {
+				t.Errorf("%s: streaming is not enabled", name)
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte(body))
+		}))
+		chat := &QwenChat{BaseChat: SimpleChat{}, Config: &config.QwenConfig{HostUrl: srv.URL, ApiKey: "k", ModelVersion: "qwen-max"}}
+		stream := &ReplyStream{}
+		res, err := chat.complete(context.Background(), "qwen-user", []QwenMessage{{Role: QwenChatUser, Content: "你好"}}, stream)
+		if err != nil || res != "你好，世界" || stream.buf.String() != res {
+			t.Errorf("%s: unexpected reply %q %v, streamed %q", name, res, err, stream.buf.String())
+		}
+		srv.Close()
+	}
+}
@@ -0,0 +1,270 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	Continue_Msg    = "继续"
+	moreReplyHint   = "\n\n（内容较长，回复“继续”或 /more 查看剩余部分）"
+	codeFence       = "```"
+	noMoreReplyText = "没有更多内容了"
+)
+
+// ShapeReply 将超过微信长度限制的回复拆分，返回可以被动回复的第一段。
+// 配置了客服消息时剩余部分由 SendOverflowReplies 推送，否则保存起来等待用户发送“继续”或 /more
+func ShapeReply(ctx context.Context, userId, reply string) string {
+	maxBytes := config.GetWxReplyMaxBytes()
+	if len(reply) <= maxBytes {
+		return reply
+	}
+	if tasks := asyncTasksOf(ctx); tasks != nil && tasks.sender != nil {
+		chunks := SplitReply(reply, maxBytes)
+		if len(chunks) > 1 {
+			// 剩余分段暂存在本次请求中，等被动回复发出后再推送
+			tasks.mu.Lock()
+			if tasks.overflow == nil {
+				tasks.overflow = make(map[string][]string)
+			}
+			tasks.overflow[userId] = chunks[1:]
+			tasks.mu.Unlock()
+		}
+		return chunks[0]
+	}
+	chunks := SplitReply(reply, maxBytes-len(moreReplyHint))
+	if err := db.SetMoreReply(userId, chunks[1:]); err != nil {
+		fmt.Println("save more reply error:", err)
+	}
+	if len(chunks) > 1 {
+		return chunks[0] + moreReplyHint
+	}
+	return chunks[0]
+}
+
+// SendOverflowReplies 在被动回复发出后推送剩余分段，需在回复刷给微信之后调用以保证顺序
+func SendOverflowReplies(ctx context.Context, userId string) {
+	tasks := asyncTasksOf(ctx)
+	if tasks == nil {
+		return
+	}
+	tasks.mu.Lock()
+	chunks, ok := tasks.overflow[userId]
+	delete(tasks.overflow, userId)
+	tasks.mu.Unlock()
+	if !ok {
+		return
+	}
+	goAsync(ctx, func() {
+		sendChunks(tasks.sender, userId, chunks)
+	})
+}
+
+// sendChunks 依次推送分段，推送失败时把剩余分段保存起来，用户可以通过 /more 获取
+func sendChunks(sender ReplySender, userId string, chunks []string) {
+	for i, chunk := range chunks {
+		if sender == nil {
+			db.SetMoreReply(userId, chunks[i:])
+			return
+		}
+		if err := sender.SendText(userId, chunk); err != nil {
+			fmt.Printf("send reply chunk to user %s failed: %v\n", userId, err)
+			db.SetMoreReply(userId, chunks[i:])
+			return
+		}
+	}
+}
+
+// appendMoreReply 将分段追加到 /more 的剩余内容之后
+func appendMoreReply(userId string, chunks []string) {
+	more, _ := db.GetMoreReply(userId)
+	if err := db.SetMoreReply(userId, append(more, chunks...)); err != nil {
+		fmt.Println("save more reply error:", err)
+	}
+}
+
+// IsContinueMsg 只有存在未发送的回复时，“继续”才被当作获取剩余内容的命令
+func IsContinueMsg(userId, msg string) bool {
+	if strings.TrimSpace(msg) != Continue_Msg {
+		return false
+	}
+	chunks, err := db.GetMoreReply(userId)
+	return err == nil && len(chunks) > 0
+}
+
+// MoreReply 返回超长回复的下一段
+func MoreReply(param, userId string) string {
+	chunks, err := db.GetMoreReply(userId)
+	if err != nil || len(chunks) == 0 {
+		return noMoreReplyText
+	}
+	db.SetMoreReply(userId, chunks[1:])
+	if len(chunks) > 1 {
+		return chunks[0] + moreReplyHint
+	}
+	return chunks[0]
+}
+
+// SplitReply 按 UTF-8 字节长度拆分回复，优先在段落、句子处断开，且不会把代码块从中间截断
+func SplitReply(reply string, maxBytes int) []string {
+	if maxBytes <= 0 || len(reply) <= maxBytes {
+		return []string{reply}
+	}
+	var chunks []string
+	var cur strings.Builder
+	flush := func() {
+		if s := strings.Trim(cur.String(), "\n"); s != "" {
+			chunks = append(chunks, s)
+		}
+		cur.Reset()
+	}
+	for _, seg := range splitSegments(reply, maxBytes) {
+		if cur.Len()+len(seg) > maxBytes {
+			flush()
+		}
+		cur.WriteString(seg)
+	}
+	flush()
+	if len(chunks) == 0 {
+		return []string{reply}
+	}
+	return chunks
+}
+
+// splitSegments 将文本拆成不超过 maxBytes 的片段：代码块整体作为一个片段，普通文本按段落拆分
+func splitSegments(text string, maxBytes int) []string {
+	var segments []string
+	var block strings.Builder
+	inCode := false
+	addBlock := func() {
+		if block.Len() == 0 {
+			return
+		}
+		s := block.String()
+		block.Reset()
+		if len(s) <= maxBytes {
+			segments = append(segments, s)
+		} else if strings.HasPrefix(strings.TrimSpace(s), codeFence) {
+			segments = append(segments, splitCodeBlock(s, maxBytes)...)
+		} else {
+			segments = append(segments, splitSentences(s, maxBytes)...)
+		}
+	}
+	for _, line := range strings.SplitAfter(text, "\n") {
+		isFence := strings.HasPrefix(strings.TrimSpace(line), codeFence)
+		switch {
+		case isFence && !inCode:
+			addBlock()
+			inCode = true
+			block.WriteString(line)
+		case isFence && inCode:
+			block.WriteString(line)
+			inCode = false
+			addBlock()
+		case inCode:
+			block.WriteString(line)
+		default:
+			block.WriteString(line)
+			if strings.TrimSpace(line) == "" {
+				// 空行作为段落结束
+				addBlock()
+			}
+		}
+	}
+	addBlock()
+	return segments
+}
+
+// splitCodeBlock 拆分超长代码块，每一段都补全开头和结尾的 ``` 标记
+func splitCodeBlock(block string, maxBytes int) []string {
+	lines := strings.SplitAfter(block, "\n")
+	header := lines[0]
+	if !strings.HasSuffix(header, "\n") {
+		header += "\n"
+	}
+	body := lines[1:]
+	for len(body) > 0 && body[len(body)-1] == "" {
+		body = body[:len(body)-1]
+	}
+	if n := len(body); n > 0 && strings.HasPrefix(strings.TrimSpace(body[n-1]), codeFence) {
+		body = body[:n-1]
+	}
+	closer := codeFence + "\n"
+	limit := maxBytes - len(header) - len(closer) - 1
+	if limit <= 0 {
+		return cutRunes(block, maxBytes)
+	}
+
+	var pieces []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() == 0 {
+			return
+		}
+		s := cur.String()
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		pieces = append(pieces, header+s+closer)
+		cur.Reset()
+	}
+	for _, line := range body {
+		for _, part := range cutRunes(line, limit) {
+			if cur.Len()+len(part) > limit {
+				flush()
+			}
+			cur.WriteString(part)
+		}
+	}
+	flush()
+	return pieces
+}
+
+// splitSentences 在句末标点或换行处拆分文本，单句仍超长时按字符截断
+func splitSentences(text string, maxBytes int) []string {
+	var sentences []string
+	start := 0
+	for i, r := range text {
+		switch r {
+		case '。', '！', '？', '；', '!', '?', ';', '\n':
+		case '.':
+			if next := i + 1; next < len(text) && text[next] != ' ' && text[next] != '\n' {
+				continue
+			}
+		default:
+			continue
+		}
+		end := i + utf8.RuneLen(r)
+		sentences = append(sentences, cutRunes(text[start:end], maxBytes)...)
+		start = end
+	}
+	if start < len(text) {
+		sentences = append(sentences, cutRunes(text[start:], maxBytes)...)
+	}
+	return sentences
+}
+
+// cutRunes 按字节长度截断文本，保证不会截断多字节字符
+func cutRunes(text string, maxBytes int) []string {
+	var parts []string
+	for len(text) > maxBytes {
+		cut := maxBytes
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+		parts = append(parts, text[:cut])
+		text = text[cut:]
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
@@ -0,0 +1,113 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitReply(t *testing.T) {
+	text := strings.Repeat("这是一个很长的句子。", 30) + "\n\n" + strings.Repeat("Another sentence here. ", 20)
+	chunks := SplitReply(text, 200)
+	if len(chunks) < 2 {
+		t.Fatalf("expected multiple chunks, got %d", len(chunks))
+	}
+	for _, c := range chunks {
+		if len(c) > 200 {
+			t.Errorf("chunk exceeds limit: %d bytes", len(c))
+		}
+		if !utf8.ValidString(c) {
+			t.Errorf("chunk is not valid utf-8: %q", c)
+		}
+	}
+	if !strings.HasSuffix(chunks[0], "。") {
+		t.Errorf("expected chunk to end at sentence boundary, got %q", chunks[0])
+	}
+
+	if chunks := SplitReply("short", 200); len(chunks) != 1 || chunks[0] != "short" {
+		t.Errorf("short reply should not be split: %v", chunks)
+	}
+}
+
+func TestSplitReplyCodeBlock(t *testing.T) {
+	code := "```go\n" + strings.Repeat("fmt.Println(\"hello world\")\n", 20) + "```\n"
+	text := "示例代码如下：\n\n" + code + "\n以上。"
+	chunks := SplitReply(text, 200)
+	for _, c := range chunks {
+		if len(c) > 200 {
+			t.Errorf("chunk exceeds limit: %d bytes", len(c))
+		}
+		if strings.Count(c, codeFence)%2 != 0 {
+			t.Errorf("chunk cuts a code block: %q", c)
+		}
+	}
+
+	// 能放下的代码块不应被拆开
+	small := "前言\n\n```\na\nb\n```\n\n结尾"
+	chunks = SplitReply(small, 16)
+	found := false
+	for _, c := range chunks {
+		if c == "```\na\nb\n```" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected code block kept whole, got %q", chunks)
+	}
+}
+
+func TestShapeReplyMore(t *testing.T) {
+	t.Setenv("WX_REPLY_MAX_BYTES", "200")
+	userId := "shape-more-user"
+	text := strings.Repeat("这是一个很长的句子。", 40)
+
+	first := ShapeReply(context.Background(), userId, text)
+	if !strings.HasSuffix(first, moreReplyHint) || len(first) > 200 {
+		t.Fatalf("unexpected first chunk %q", first)
+	}
+	if !IsContinueMsg(userId, "继续") {
+		t.Fatal("expected 继续 to be treated as continue command")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(strings.TrimSuffix(first, moreReplyHint))
+	for i := 0; i < 10; i++ {
+		next := MoreReply("", userId)
+		if next == noMoreReplyText {
+			break
+		}
+		sb.WriteString(strings.TrimSuffix(next, moreReplyHint))
+	}
+	if sb.String() != text {
+		t.Errorf("reassembled reply mismatch")
+	}
+	if IsContinueMsg(userId, "继续") {
+		t.Error("继续 should pass through once nothing is pending")
+	}
+}
+
+func TestShapeReplyOverflowSender(t *testing.T) {
+	t.Setenv("WX_REPLY_MAX_BYTES", "200")
+	sender := &recordSender{}
+	ctx, wait := WithAsyncTasks(context.Background(), sender)
+	userId := "shape-sender-user"
+	text := strings.Repeat("这是一个很长的句子。", 40)
+
+	first := ShapeReply(ctx, userId, text)
+	SendOverflowReplies(ctx, userId)
+	wait()
+
+	if got := first + strings.Join(sender.msgs, ""); got != text {
+		t.Errorf("pushed chunks do not reassemble the reply")
+	}
+}
+
+type recordSender struct {
+	msgs []string
+}
+
+func (r *recordSender) SendText(toUser, content string) error {
+	r.msgs = append(r.msgs, content)
+	return nil
+}
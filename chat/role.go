@@ -0,0 +1,196 @@
+package chat
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	RoleAdmin    = "admin"    // 管理员，可以授予和撤销角色
+	RoleOperator = "operator" // 运营，可以管理关键词、prompt、模型等
+	RoleMember   = "member"   // 普通用户，可以使用AI对话
+	RoleGuest    = "guest"    // 访客，只能认证
+
+	noPermissionReply = "对不起，您没有权限执行此操作。"
+	// 访客看不到真实的提示，避免暴露功能
+	guestReply = "功能还在开发中"
+
+	// 旧版本 /addme 认证的标记，视为普通用户
+	legacyAuthKey = "addme_auth:%s"
+)
+
+// roleLevels 角色按权限从低到高排列
+var roleLevels = []string{RoleGuest, RoleMember, RoleOperator, RoleAdmin}
+
+// commandRoles 声明执行命令所需的最低角色，未声明的命令普通用户即可执行
+var commandRoles = map[string]string{
+	config.Wx_Command_Help:  RoleGuest,
+	config.Wx_Command_AddMe: RoleGuest,
+
+	config.Wx_Command_AddKeyword: RoleOperator,
+	config.Wx_Command_DelKeyword: RoleOperator,
+	config.Wx_Command_Prompt:     RoleOperator,
+	config.Wx_Command_RmPrompt:   RoleOperator,
+	config.Wx_Command_Clear:      RoleOperator,
+	config.Wx_Todo_Add:           RoleOperator,
+	config.Wx_Todo_Del:           RoleOperator,
+
+	config.Wx_Command_Tier:   RoleAdmin,
+	config.Wx_Command_Usage:  RoleAdmin,
+	config.Wx_Command_Grant:  RoleAdmin,
+	config.Wx_Command_Revoke: RoleAdmin,
+	config.Wx_Command_Roles:  RoleAdmin,
+
+	config.Wx_Command_Invite:    RoleAdmin,
+	config.Wx_Command_Invites:   RoleAdmin,
+	config.Wx_Command_DelInvite: RoleAdmin,
+}
+
+func requiredRole(command string) string {
+	if role, ok := commandRoles[command]; ok {
+		return role
+	}
+	return RoleMember
+}
+
+func roleLevel(role string) int {
+	return slices.Index(roleLevels, role)
+}
+
+func isValidRole(role string) bool {
+	return roleLevel(role) >= 0
+}
+
+// isSuperAdmin ADMIN_USERS 中的用户始终是管理员，角色不能被撤销
+func isSuperAdmin(userId string) bool {
+	return slices.Contains(config.GetAdminUsers(), userId)
+}
+
+// defaultRole 未授予角色的用户，开启认证(ADDME_PASSWORD 或 INVITE_ONLY)时是访客，否则是普通用户
+func defaultRole() string {
+	if config.GetAddMePassword() != "" || config.IsInviteOnly() {
+		return RoleGuest
+	}
+	return RoleMember
+}
+
+// GetUserRole 获取用户的角色：ADMIN_USERS 中的用户是管理员，其次是授予的且未过期的角色
+func GetUserRole(userId string) string {
+	if isSuperAdmin(userId) {
+		return RoleAdmin
+	}
+	if role, err := db.GetUserRole(userId); err == nil && isValidRole(role) {
+		if expiresAt, err := db.GetRoleExpire(userId); err == nil && !expiresAt.IsZero() && time.Now().After(expiresAt) {
+			return defaultRole()
+		}
+		return role
+	}
+	if auth, err := db.GetValue(fmt.Sprintf(legacyAuthKey, userId)); err == nil && auth == "true" {
+		return RoleMember
+	}
+	return defaultRole()
+}
+
+// HasRole 用户的角色是否不低于指定角色
+func HasRole(userId, role string) bool {
+	return roleLevel(GetUserRole(userId)) >= roleLevel(role)
+}
+
+// GrantRole 永久授予用户角色，ADMIN_USERS 中的用户不需要授予
+func GrantRole(userId, role string) error {
+	return grantRoleUntil(userId, role, time.Time{})
+}
+
+// grantRoleUntil 授予用户角色，expiresAt 为零值时永久有效
+func grantRoleUntil(userId, role string, expiresAt time.Time) error {
+	if !isValidRole(role) {
+		return fmt.Errorf("未知的角色：%s", role)
+	}
+	if isSuperAdmin(userId) {
+		return fmt.Errorf("%s 是 %s 中的管理员，不能修改角色", userId, config.AdminUsersKey)
+	}
+	if err := db.SetUserRole(userId, role); err != nil {
+		return err
+	}
+	if err := db.SetRoleExpire(userId, expiresAt); err != nil {
+		return err
+	}
+	db.DeleteKey(fmt.Sprintf(legacyAuthKey, userId))
+	return nil
+}
+
+// GrantRoleCommand /grant 用户openid 角色：授予用户角色
+func GrantRoleCommand(param, userId string) string {
+	fields := strings.Fields(param)
+	if len(fields) != 2 {
+		return fmt.Sprintf("格式：/grant 用户openid 角色，角色可选：%s", strings.Join(roleLevels, "、"))
+	}
+	if err := GrantRole(fields[0], fields[1]); err != nil {
+		return fmt.Sprintf("授予角色失败：%v", err)
+	}
+	return fmt.Sprintf("已授予用户 %s %s 角色", fields[0], fields[1])
+}
+
+// RevokeRoleCommand /revoke 用户openid：撤销用户的角色，用户将无法使用AI对话
+func RevokeRoleCommand(param, userId string) string {
+	target := strings.TrimSpace(param)
+	if target == "" || strings.ContainsAny(target, " \t") {
+		return "格式：/revoke 用户openid"
+	}
+	if target == userId {
+		return "不能撤销自己的角色"
+	}
+	if err := GrantRole(target, RoleGuest); err != nil {
+		return fmt.Sprintf("撤销角色失败：%v", err)
+	}
+	return fmt.Sprintf("已撤销用户 %s 的角色", target)
+}
+
+// ListRolesCommand /roles [用户openid]：查看所有被授予角色的用户，或指定用户的角色
+func ListRolesCommand(param, userId string) string {
+	if target := strings.TrimSpace(param); target != "" {
+		res := fmt.Sprintf("用户 %s 的角色：%s", target, GetUserRole(target))
+		if expiresAt, err := db.GetRoleExpire(target); err == nil && !expiresAt.IsZero() {
+			res += fmt.Sprintf("，有效期至 %s", expiresAt.Format(inviteTimeLayout))
+		}
+		return res
+	}
+	roles, err := db.GetUserRoles()
+	if err != nil {
+		return fmt.Sprintf("获取角色列表失败：%v", err)
+	}
+	byRole := make(map[string][]string)
+	for _, admin := range config.GetAdminUsers() {
+		byRole[RoleAdmin] = append(byRole[RoleAdmin], admin+"(超级管理员)")
+	}
+	users := make([]string, 0, len(roles))
+	for user := range roles {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		if !isSuperAdmin(user) {
+			byRole[roles[user]] = append(byRole[roles[user]], user)
+		}
+	}
+
+	var sb strings.Builder
+	for i := len(roleLevels) - 1; i >= 0; i-- {
+		role := roleLevels[i]
+		if len(byRole[role]) == 0 {
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%s：\n%s\n", role, strings.Join(byRole[role], "\n")))
+	}
+	if sb.Len() == 0 {
+		return "还没有授予任何角色"
+	}
+	sb.WriteString(fmt.Sprintf("未授予角色的用户默认为 %s", defaultRole()))
+	return sb.String()
+}
@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+)
+
+func TestUserRole(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root1,root2")
+	t.Setenv("ADDME_PASSWORD", "")
+
+	if role := GetUserRole("root2"); role != RoleAdmin {
+		t.Errorf("ADMIN_USERS should be admin, got %s", role)
+	}
+	if role := GetUserRole("someone"); role != RoleMember {
+		t.Errorf("users should be members without ADDME_PASSWORD, got %s", role)
+	}
+	t.Setenv("ADDME_PASSWORD", "secret")
+	if role := GetUserRole("someone"); role != RoleGuest {
+		t.Errorf("users should be guests with ADDME_PASSWORD, got %s", role)
+	}
+	if !HasRole("root1", RoleOperator) || HasRole("someone", RoleMember) {
+		t.Error("unexpected role ordering")
+	}
+	if err := GrantRole("root1", RoleGuest); err == nil {
+		t.Error("super admins should not be modified")
+	}
+	if err := GrantRole("someone", "owner"); err == nil {
+		t.Error("unknown roles should be rejected")
+	}
+}
+
+func TestDoActionPermission(t *testing.T) {
+	t.Setenv("ADMIN_USERS", "root1")
+	t.Setenv("ADDME_PASSWORD", "")
+
+	if r, ok := DoAction(context.Background(), "someone", config.Wx_Command_Grant+" user1 admin"); !ok || r != noPermissionReply {
+		t.Errorf("members should not grant roles, got %q", r)
+	}
+	if r, ok := DoAction(context.Background(), "root1", config.Wx_Command_Grant+" user1"); !ok || r == noPermissionReply {
+		t.Errorf("admins should be able to run /grant, got %q", r)
+	}
+
+	t.Setenv("ADDME_PASSWORD", "secret")
+	if r, ok := DoAction(context.Background(), "someone", config.Wx_Command_GetModel); !ok || r != guestReply {
+		t.Errorf("guests should get the guest reply, got %q", r)
+	}
+	if r, _ := DoAction(context.Background(), "someone", config.Wx_Command_AddMe+" wrong"); r != guestReply {
+		t.Errorf("wrong password should be rejected, got %q", r)
+	}
+}
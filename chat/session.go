@@ -0,0 +1,162 @@
+package chat
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+// maxSessions 每个用户最多的会话数，包含默认会话
+const maxSessions = 10
+
+// activeSession 用户当前的会话id
+func activeSession(userId string) string {
+	id, _ := db.GetActiveSession(userId)
+	return id
+}
+
+// findSession 按名称或 /sessions 中的序号查找会话，名称优先，便于使用数字命名的会话
+func findSession(sessions []*db.Session, key string) (*db.Session, bool) {
+	if idx := slices.IndexFunc(sessions, func(s *db.Session) bool { return s.Name == key }); idx >= 0 {
+		return sessions[idx], true
+	}
+	if i, err := strconv.Atoi(key); err == nil && i >= 1 && i <= len(sessions) {
+		return sessions[i-1], true
+	}
+	return nil, false
+}
+
+func sessionNameTaken(sessions []*db.Session, name string) bool {
+	return slices.ContainsFunc(sessions, func(s *db.Session) bool { return s.Name == name })
+}
+
+// NewSessionCommand /new [名称]：新建会话并切换过去
+func NewSessionCommand(param, userId string) string {
+	sessions, err := db.GetSessions(userId)
+	if err != nil {
+		return fmt.Sprintf("新建会话失败：%v", err)
+	}
+	if len(sessions) >= maxSessions {
+		return fmt.Sprintf("最多保存%d个会话，请先通过 /delsession 删除", maxSessions)
+	}
+	name := strings.TrimSpace(param)
+	if name == "" {
+		for i := len(sessions) + 1; name == "" || sessionNameTaken(sessions, name); i++ {
+			name = fmt.Sprintf("会话%d", i)
+		}
+	} else if sessionNameTaken(sessions, name) {
+		return fmt.Sprintf("会话 %s 已存在，发送 /switch %s 切换", name, name)
+	}
+	id, err := db.NewSessionId(userId)
+	if err != nil {
+		return fmt.Sprintf("新建会话失败：%v", err)
+	}
+	session := &db.Session{Id: id, Name: name, CreatedAt: time.Now().Unix()}
+	if err = db.SaveSession(userId, session); err != nil {
+		return fmt.Sprintf("新建会话失败：%v", err)
+	}
+	if err = db.SetActiveSession(userId, id); err != nil {
+		return fmt.Sprintf("切换会话失败：%v", err)
+	}
+	return fmt.Sprintf("已新建并切换到会话 %s，发送 /sessions 查看所有会话", name)
+}
+
+// ListSessionsCommand /sessions：查看所有会话
+func ListSessionsCommand(param, userId string) string {
+	sessions, err := db.GetSessions(userId)
+	if err != nil {
+		return fmt.Sprintf("获取会话失败：%v", err)
+	}
+	active := activeSession(userId)
+	var sb strings.Builder
+	sb.WriteString("你的会话：\n")
+	for i, s := range sessions {
+		sb.WriteString(fmt.Sprintf("%d. %s", i+1, s.Name))
+		if s.Id == active {
+			sb.WriteString(" [当前]")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("发送 /switch 序号或名称 切换，/new 名称 新建，/rename 名称 重命名当前会话，/delsession 序号或名称 删除")
+	return sb.String()
+}
+
+// SwitchSessionCommand /switch 序号或名称：切换会话
+func SwitchSessionCommand(param, userId string) string {
+	key := strings.TrimSpace(param)
+	if key == "" {
+		return "格式：/switch 序号或名称，发送 /sessions 查看所有会话"
+	}
+	sessions, err := db.GetSessions(userId)
+	if err != nil {
+		return fmt.Sprintf("切换会话失败：%v", err)
+	}
+	session, ok := findSession(sessions, key)
+	if !ok {
+		return fmt.Sprintf("会话 %s 不存在，发送 /sessions 查看所有会话", key)
+	}
+	if err = db.SetActiveSession(userId, session.Id); err != nil {
+		return fmt.Sprintf("切换会话失败：%v", err)
+	}
+	return fmt.Sprintf("已切换到会话 %s", session.Name)
+}
+
+// RenameSessionCommand /rename 名称：重命名当前会话
+func RenameSessionCommand(param, userId string) string {
+	name := strings.TrimSpace(param)
+	if name == "" {
+		return "格式：/rename 新名称"
+	}
+	sessions, err := db.GetSessions(userId)
+	if err != nil {
+		return fmt.Sprintf("重命名会话失败：%v", err)
+	}
+	if sessionNameTaken(sessions, name) {
+		return fmt.Sprintf("会话 %s 已存在", name)
+	}
+	active := activeSession(userId)
+	idx := slices.IndexFunc(sessions, func(s *db.Session) bool { return s.Id == active })
+	if idx < 0 {
+		return "当前会话不存在，发送 /sessions 查看所有会话"
+	}
+	session := sessions[idx]
+	old := session.Name
+	session.Name = name
+	if err = db.SaveSession(userId, session); err != nil {
+		return fmt.Sprintf("重命名会话失败：%v", err)
+	}
+	return fmt.Sprintf("已将会话 %s 重命名为 %s", old, name)
+}
+
+// DeleteSessionCommand /delsession 序号或名称：删除会话及其对话记录。默认会话不能删除，
+// 清空默认会话的对话记录需使用有角色限制的 /clear
+func DeleteSessionCommand(param, userId string) string {
+	key := strings.TrimSpace(param)
+	if key == "" {
+		return "格式：/delsession 序号或名称，发送 /sessions 查看所有会话"
+	}
+	sessions, err := db.GetSessions(userId)
+	if err != nil {
+		return fmt.Sprintf("删除会话失败：%v", err)
+	}
+	session, ok := findSession(sessions, key)
+	if !ok {
+		return fmt.Sprintf("会话 %s 不存在，发送 /sessions 查看所有会话", key)
+	}
+	if session.Id == db.DefaultSessionId {
+		return fmt.Sprintf("默认会话 %s 不能删除，如需清除对话记录请发送 %s", session.Name, config.Wx_Command_Clear)
+	}
+	if err = db.DeleteSession(userId, session.Id, config.SupportBots()); err != nil {
+		return fmt.Sprintf("删除会话失败：%v", err)
+	}
+	if session.Id == activeSession(userId) {
+		db.SetActiveSession(userId, db.DefaultSessionId)
+		return fmt.Sprintf("已删除会话 %s，已切换回默认会话", session.Name)
+	}
+	return fmt.Sprintf("已删除会话 %s", session.Name)
+}
@@ -0,0 +1,71 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func TestSessions(t *testing.T) {
+	oldStore, oldDb := db.StoreInstance, db.ChatDbInstance
+	db.StoreInstance = db.NewMemoryStore()
+	db.ChatDbInstance = db.NewStoreChatDb(db.StoreInstance)
+	defer func() { db.StoreInstance, db.ChatDbInstance = oldStore, oldDb }()
+	const user, bot = "session-user", "gpt"
+
+	db.ChatDbInstance.SetMsgList(bot, user, activeSession(user), []db.Msg{textMsg("user", "个人")})
+	if r := NewSessionCommand("工作", user); !strings.Contains(r, "工作") {
+		t.Fatalf("unexpected reply %q", r)
+	}
+	if r := NewSessionCommand("工作", user); !strings.Contains(r, "已存在") {
+		t.Errorf("duplicate names should be rejected, got %q", r)
+	}
+	work := activeSession(user)
+	if work == db.DefaultSessionId {
+		t.Fatal("new session should become active")
+	}
+	if list, _ := db.ChatDbInstance.GetMsgList(bot, user, work); len(list) != 0 {
+		t.Errorf("new session should start empty, got %v", list)
+	}
+	db.ChatDbInstance.SetMsgList(bot, user, work, []db.Msg{textMsg("user", "工作")})
+
+	if r := SwitchSessionCommand("1", user); !strings.Contains(r, db.DefaultSessionName) {
+		t.Errorf("switch by index failed: %q", r)
+	}
+	if list, _ := db.ChatDbInstance.GetMsgList(bot, user, activeSession(user)); len(list) != 1 || list[0].Parts[0].Data != "个人" {
+		t.Errorf("default session history should be kept, got %v", list)
+	}
+	RenameSessionCommand("个人", user)
+	if r := ListSessionsCommand("", user); !strings.Contains(r, "1. 个人 [当前]") || !strings.Contains(r, "2. 工作") {
+		t.Errorf("unexpected session list %q", r)
+	}
+
+	// 默认会话不能通过 /delsession 清空
+	if r := DeleteSessionCommand("1", user); !strings.Contains(r, "不能删除") {
+		t.Errorf("the default session should not be deleted, got %q", r)
+	}
+	if list, _ := db.ChatDbInstance.GetMsgList(bot, user, db.DefaultSessionId); len(list) != 1 {
+		t.Errorf("default session history should be kept, got %v", list)
+	}
+
+	// 名称优先于序号
+	NewSessionCommand("1", user)
+	if r := SwitchSessionCommand("1", user); r != "已切换到会话 1" {
+		t.Errorf("switch by numeric name failed: %q", r)
+	}
+	if r := DeleteSessionCommand("1", user); !strings.Contains(r, "已删除会话 1") {
+		t.Errorf("delete by numeric name failed: %q", r)
+	}
+
+	SwitchSessionCommand("工作", user)
+	if r := DeleteSessionCommand("工作", user); !strings.Contains(r, "切换回默认会话") {
+		t.Errorf("deleting the active session should switch back, got %q", r)
+	}
+	if activeSession(user) != db.DefaultSessionId {
+		t.Error("active session should be the default one")
+	}
+	if list, _ := db.ChatDbInstance.GetMsgList(bot, user, work); len(list) != 0 {
+		t.Errorf("deleted session history should be removed, got %v", list)
+	}
+}
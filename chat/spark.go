@@ -1,9 +1,11 @@
 package chat
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -18,10 +20,37 @@ import (
 	"github.com/pwh-pwh/aiwechat-vercel/config"
 )
 
+func init() {
+	RegisterProvider(&Provider{
+		Name:         config.Bot_Type_Spark,
+		Title:        "星火",
+		Command:      config.Wx_Command_Spark,
+		Description:  "与星火对话",
+		EventKey:     config.GetWxEventKeyChatSpark,
+		Capabilities: Capabilities{Prompt: true, Model: true},
+		Params: map[string]ParamRule{
+			ParamTemperature: {Min: 0, Max: 1, MinExclusive: true, Default: 0.8},
+			ParamTopK:        {Min: 1, Max: 6, Integer: true, Default: 6},
+			ParamMaxTokens:   {Min: 1, Max: 8192, Integer: true, Default: 2048}, // 参数说明参考 https://www.xfyun.cn/doc/spark/Web.html
+		},
+		CheckConfig: func() error {
+			_, err := config.GetSparkConfig()
+			return err
+		},
+		Welcome: config.GetSparkWelcomeReply,
+		New: func() BaseChat {
+			cfg, _ := config.GetSparkConfig()
+			return &SparkChat{
+				BaseChat: SimpleChat{},
+				Config:   cfg,
+			}
+		},
+	})
+}
+
 type SparkChat struct {
 	BaseChat
-	Config    *config.SparkConfig
-	maxTokens int
+	Config *config.SparkConfig
 }
 
 type SparkResponse struct {
@@ -49,6 +78,27 @@ func (header *SparkResponseHeader) ToString() string {
 	return string(buf)
 }
 
+// sparkErrorKinds 星火接口响应头中常见的错误码
+var sparkErrorKinds = map[int]ErrorKind{
+	10013: ErrKindContentFilter, // 输入内容审核不通过
+	10014: ErrKindContentFilter, // 输出内容涉及敏感信息
+	10019: ErrKindContentFilter, // 疑似敏感
+	10907: ErrKindBadRequest,    // token 数量超过上限
+	11200: ErrKindAuth,          // 授权错误
+	11201: ErrKindQuota,         // 日流控超限
+	11202: ErrKindRateLimit,     // 秒级流控超限
+	11203: ErrKindRateLimit,     // 并发流控超限
+}
+
+// sparkError 将响应头中的错误码转换为 ProviderError，未知的错误码视为接口异常
+func sparkError(header *SparkResponseHeader) *ProviderError {
+	kind, ok := sparkErrorKinds[header.Code]
+	if !ok {
+		kind = ErrKindUpstream
+	}
+	return &ProviderError{Kind: kind, BotType: config.Bot_Type_Spark, Err: fmt.Errorf("code=%d,message=%s", header.Code, header.Message)}
+}
+
 func (s *SparkChat) toDbMsg(msg SparkMessage) db.Msg {
 	return db.Msg{
 		Role: msg.Role,
@@ -69,35 +119,74 @@ func (s *SparkChat) toChatMsg(msg db.Msg) SparkMessage {
 	}
 }
 
-func (chat *SparkChat) Chat(userId, message string, imageURL ...string) (res string) {
-	r, flag := DoAction(userId, message)
+func (chat *SparkChat) Chat(ctx context.Context, userId, message string, imageURL ...string) (res string) {
+	r, flag := DoAction(ctx, userId, message)
 	if flag {
 		return r
 	}
-	return WithTimeChat(userId, message, chat.chat)
+	return WithTimeChat(ctx, userId, message, func(ctx context.Context, userId, message string, stream *ReplyStream) string {
+		return chatWithFailover(ctx, config.Bot_Type_Spark, chat, userId, message, stream)
+	})
+}
+
+func (chat *SparkChat) reply(ctx context.Context, userId string, message string, stream *ReplyStream, imageURL ...string) (res string, err error) {
+	var msgs = GetMsgListWithDb(config.Bot_Type_Spark, userId, SparkMessage{
+		Role:    "user",
+		Content: message,
+	}, chat.toDbMsg, chat.toChatMsg)
+
+	res, err = chat.complete(ctx, userId, msgs, stream)
+	if err != nil {
+		return "", err
+	}
+
+	msgs = append(msgs, SparkMessage{
+		Role:    "assistant",
+		Content: res,
+	})
+	SaveMsgListWithDb(ctx, config.Bot_Type_Spark, userId, msgs, chat.toDbMsg)
+	return
+}
+
+// Complete 单次补全，不读取也不保存历史
+func (chat *SparkChat) Complete(ctx context.Context, userId, prompt string) (string, error) {
+	return chat.complete(ctx, userId, []SparkMessage{{Role: "user", Content: prompt}}, nil)
+}
+
+// getConfig 用户通过 /setmodel 选择了其它版本时，使用该版本对应的接口地址
+func (chat *SparkChat) getConfig(userId string) *config.SparkConfig {
+	if model := userModel(userId, config.Bot_Type_Spark); model != "" {
+		if cfg, err := chat.Config.WithDomain(model); err == nil {
+			return cfg
+		}
+	}
+	return chat.Config
 }
 
-func (chat *SparkChat) chat(userId string, message string) (res string) {
+func (chat *SparkChat) complete(ctx context.Context, userId string, msgs []SparkMessage, stream *ReplyStream) (res string, err error) {
+	cfg := chat.getConfig(userId)
 	dialer := websocket.Dialer{
 		HandshakeTimeout: 5 * time.Second,
 	}
 	//握手并建立websocket 连接
-	conn, resp, err := dialer.Dial(assembleAuthUrl1(chat.Config.HostUrl, chat.Config.ApiKey, chat.Config.ApiSecret), nil)
+	conn, resp, err := dialer.DialContext(ctx, assembleAuthUrl1(cfg.HostUrl, cfg.ApiKey, cfg.ApiSecret), nil)
 	if err != nil {
-		res = readResp(resp) + err.Error()
-		return
+		if resp != nil {
+			// 握手失败时接口返回 401、403 等状态码，body 中是原因
+			body, _ := io.ReadAll(resp.Body)
+			return "", newHTTPError(config.Bot_Type_Spark, resp.StatusCode, body)
+		}
+		return "", err
 	} else if resp.StatusCode != 101 {
-		res = readResp(resp)
-		return
+		return "", errors.New(readResp(resp))
 	}
-
-	var msgs = GetMsgListWithDb(config.Bot_Type_Spark, userId, SparkMessage{
-		Role:    "user",
-		Content: message,
-	}, chat.toDbMsg, chat.toChatMsg)
+	defer conn.Close()
+	// websocket 的读取不受 ctx 控制，ctx 取消时关闭连接以结束 ReadMessage
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
 
 	go func() {
-		data := generateRequestBody(chat.Config.AppId, chat.Config.SparkDomainVersion, msgs, chat.maxTokens)
+		data := generateRequestBody(cfg.AppId, cfg.SparkDomainVersion, msgs, GenerationParams(config.Bot_Type_Spark, userId))
 		conn.WriteJSON(data)
 	}()
 
@@ -106,6 +195,12 @@ func (chat *SparkChat) chat(userId string, message string) (res string) {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
 			fmt.Println("read message error:", err)
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+			if res == "" {
+				return "", err
+			}
 			break
 		}
 
@@ -115,12 +210,11 @@ func (chat *SparkChat) chat(userId string, message string) (res string) {
 		err = sonic.Unmarshal(msg, &rpn)
 		if err != nil {
 			fmt.Println("Error parsing JSON:", err)
-			return
+			return "", err
 		}
 		if rpn.Header.IsFailed() {
 			// res = rpn.Header.ToString()
-			res = rpn.Header.Message
-			return
+			return "", sparkError(rpn.Header)
 		}
 		//解析数据
 		choices := rpn.Payload["choices"].(map[string]interface{})
@@ -128,6 +222,7 @@ func (chat *SparkChat) chat(userId string, message string) (res string) {
 		fmt.Println(status)
 		text := choices["text"].([]interface{})
 		content := text[0].(map[string]interface{})["content"].(string)
+		stream.Write(content)
 		if status != 2 {
 			res += content
 		} else {
@@ -137,36 +232,40 @@ func (chat *SparkChat) chat(userId string, message string) (res string) {
 			temp := usage["text"].(map[string]interface{})
 			totalTokens := temp["total_tokens"].(float64)
 			fmt.Println("total_tokens:", totalTokens)
-			conn.Close()
+			promptTokens, _ := temp["prompt_tokens"].(float64)
+			completionTokens, _ := temp["completion_tokens"].(float64)
+			RecordUsage(userId, config.Bot_Type_Spark, cfg.SparkDomainVersion, TokenUsage{
+				PromptTokens:     int(promptTokens),
+				CompletionTokens: int(completionTokens),
+			})
 			break
 		}
 
 	}
-	msgs = append(msgs, SparkMessage{
-		Role:    "assistant",
-		Content: res,
-	})
-	SaveMsgListWithDb(config.Bot_Type_Spark, userId, msgs, chat.toDbMsg)
-	return
+	return res, nil
 }
 
-// 生成参数
-func generateRequestBody(appid string, domain string, messages []SparkMessage, maxTokens int) map[string]interface{} { // 根据实际情况修改返回的数据结构和字段名
-	if maxTokens == 0 {
-		maxTokens = 2048 	// 默认值，参数说明参考 https://www.xfyun.cn/doc/spark/Web.html
+// 生成参数，参数说明参考 https://www.xfyun.cn/doc/spark/Web.html
+func generateRequestBody(appid string, domain string, messages []SparkMessage, params db.GenParams) map[string]interface{} { // 根据实际情况修改返回的数据结构和字段名
+	chatParams := map[string]interface{}{ // 根据实际情况修改返回的数据结构和字段名
+		"domain":   domain,    // 根据实际情况修改返回的数据结构和字段名
+		"auditing": "default", // 根据实际情况修改返回的数据结构和字段名
+	}
+	if params.Temperature != nil {
+		chatParams["temperature"] = *params.Temperature
+	}
+	if params.TopK != nil {
+		chatParams["top_k"] = int64(*params.TopK)
+	}
+	if params.MaxTokens != nil {
+		chatParams["max_tokens"] = int64(*params.MaxTokens)
 	}
 	data := map[string]interface{}{ // 根据实际情况修改返回的数据结构和字段名
 		"header": map[string]interface{}{ // 根据实际情况修改返回的数据结构和字段名
 			"app_id": appid, // 根据实际情况修改返回的数据结构和字段名
 		},
 		"parameter": map[string]interface{}{ // 根据实际情况修改返回的数据结构和字段名
-			"chat": map[string]interface{}{ // 根据实际情况修改返回的数据结构和字段名
-				"domain":      domain,       // 根据实际情况修改返回的数据结构和字段名
-				"temperature": float64(0.8), // 根据实际情况修改返回的数据结构和字段名
-				"top_k":       int64(6),     // 根据实际情况修改返回的数据结构和字段名
-				"max_tokens":  int64(maxTokens),  // 根据实际情况修改返回的数据结构和字段名
-				"auditing":    "default",    // 根据实际情况修改返回的数据结构和字段名
-			},
+			"chat": chatParams,
 		},
 		"payload": map[string]interface{}{ // 根据实际情况修改返回的数据结构和字段名
 			"message": map[string]interface{}{ // 根据实际情况修改返回的数据结构和字段名
@@ -1,6 +1,7 @@
 package chat
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -18,7 +19,7 @@ func TestSparkChat(t *testing.T) {
 		Config:   config,
 	}
 
-	res := chat.Chat("testUser", "用10个字描述你的能力")
+	res := chat.Chat(context.Background(), "testUser", "用10个字描述你的能力")
 
 	fmt.Println(res)
 }
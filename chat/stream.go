@@ -0,0 +1,134 @@
+package chat
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// sentenceEnds 流式回复可以断开的位置
+const sentenceEnds = "\n。！？!?；;"
+
+// ReplyStream 接收流式回复的增量文本。被动回复的等待时间到达时，已收到的完整句子先回复给用户，
+// 剩余部分等回复结束后异步送达。nil 表示不需要流式输出
+type ReplyStream struct {
+	mu    sync.Mutex
+	buf   strings.Builder
+	taken string // 已经被动回复的部分
+}
+
+// Write 追加增量文本
+func (s *ReplyStream) Write(delta string) {
+	if s == nil || delta == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.WriteString(delta)
+}
+
+// Reset 调用失败、重试或改由其它机器人回答时丢弃已收到的文本
+func (s *ReplyStream) Reset() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+}
+
+// take 取出目前可以回复的部分：不超过 maxBytes，在句子或段落处断开，且不会停在代码块中间。没有可回复的内容时返回空字符串
+func (s *ReplyStream) take(maxBytes int) string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	text := s.buf.String()
+	s.taken = text[:streamCut(text, maxBytes)]
+	return strings.TrimSpace(s.taken)
+}
+
+// remainder 回复结束后尚未送达的部分。最终回复不是以已回复的部分开头时(例如中途改由其它机器人回答)，返回完整的回复
+func (s *ReplyStream) remainder(final string) string {
+	if s == nil {
+		return final
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.taken != "" && strings.HasPrefix(final, s.taken) {
+		return strings.TrimSpace(final[len(s.taken):])
+	}
+	return final
+}
+
+// partial 是否已经被动回复了一部分
+func (s *ReplyStream) partial() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return strings.TrimSpace(s.taken) != ""
+}
+
+// streamCut 返回 text 中可以断开的位置：maxBytes 以内最后一个句子或段落的结尾，落在未闭合的代码块中时退回到代码块之前
+func streamCut(text string, maxBytes int) int {
+	limit := len(text)
+	if maxBytes > 0 && limit > maxBytes {
+		limit = maxBytes
+		for limit > 0 && !utf8.RuneStart(text[limit]) {
+			limit--
+		}
+	}
+	cut := 0
+	for i, r := range text[:limit] {
+		if strings.ContainsRune(sentenceEnds, r) {
+			cut = i + utf8.RuneLen(r)
+		}
+	}
+	fenceStart, inCode := 0, false
+	for pos := 0; pos < cut; {
+		end := strings.IndexByte(text[pos:cut], '\n')
+		line := text[pos:cut]
+		if end >= 0 {
+			line = text[pos : pos+end+1]
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), codeFence) {
+			if !inCode {
+				fenceStart = pos
+			}
+			inCode = !inCode
+		}
+		pos += len(line)
+	}
+	if inCode {
+		return fenceStart
+	}
+	return cut
+}
+
+// readSSE 逐条读取 text/event-stream 中的 data，忽略 event 等其它字段，data 为 [DONE] 时结束
+func readSSE(r io.Reader, f func(data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+		if data == "" {
+			continue
+		}
+		if err := f(data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,107 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func TestStreamCut(t *testing.T) {
+	cases := []struct {
+		text     string
+		maxBytes int
+		want     string
+	}{
+		{"你好。今天", 0, "你好。"},
+		{"还没有断句", 0, ""},
+		{"第一句。第二句。", len("第一句。") + 2, "第一句。"},
+		{"说明：\n```go\nfmt.Println(1)\n", 0, "说明：\n"},
+		{"```\na\n```\n后面", 0, "```\na\n```\n"},
+	}
+	for _, c := range cases {
+		if got := c.text[:streamCut(c.text, c.maxBytes)]; got != c.want {
+			t.Errorf("streamCut(%q, %d) = %q, want %q", c.text, c.maxBytes, got, c.want)
+		}
+	}
+}
+
+func TestReplyStream(t *testing.T) {
+	var nilStream *ReplyStream
+	nilStream.Write("忽略")
+	if nilStream.take(0) != "" || nilStream.remainder("完整") != "完整" {
+		t.Error("nil stream should be a no-op")
+	}
+
+	s := &ReplyStream{}
+	s.Write("第一段。\n第二")
+	if got := s.take(0); got != "第一段。" {
+		t.Fatalf("unexpected partial %q", got)
+	}
+	if got := s.remainder("第一段。\n第二段。"); got != "第二段。" {
+		t.Errorf("unexpected remainder %q", got)
+	}
+	// 中途改由其它机器人回答时送达完整的回复
+	if got := s.remainder("（A 暂时不可用，以下由 B 回答）\n另一个回答"); got != "（A 暂时不可用，以下由 B 回答）\n另一个回答" {
+		t.Errorf("unexpected remainder %q", got)
+	}
+}
+
+func TestWithTimeChatStream(t *testing.T) {
+	oldStore := db.StoreInstance
+	db.StoreInstance = db.NewMemoryStore()
+	defer func() { db.StoreInstance = oldStore }()
+	timeout := ChatTimeout
+	ChatTimeout = 20 * time.Millisecond
+	defer func() { ChatTimeout = timeout }()
+	chat := func(ctx context.Context, userId, msg string, stream *ReplyStream) string {
+		stream.Write("第一句。第二")
+		time.Sleep(60 * time.Millisecond)
+		stream.Write("句。")
+		return "第一句。第二句。"
+	}
+
+	sender := &fakeSender{}
+	ctx, wait := WithAsyncTasks(context.Background(), sender)
+	res := WithTimeChat(ctx, "stream-user", "hi", chat)
+	wait()
+	if res != "第一句。\n\n（回答未完，剩余部分稍后推送）" {
+		t.Errorf("unexpected passive reply %q", res)
+	}
+	if sender.sent["stream-user"] != "第二句。" {
+		t.Errorf("expected the remainder to be pushed, got %q", sender.sent["stream-user"])
+	}
+
+	// 未配置客服消息时剩余部分通过 /more 获取
+	ctx, wait = WithAsyncTasks(context.Background(), nil)
+	res = WithTimeChat(ctx, "stream-user", "hi", chat)
+	wait()
+	if res != "第一句。\n\n（回答未完，剩余部分稍后回复“继续”或 /more 查看）" {
+		t.Errorf("unexpected passive reply %q", res)
+	}
+	if more, _ := db.GetMoreReply("stream-user"); len(more) != 1 || more[0] != "第二句。" {
+		t.Errorf("expected the remainder to be saved for /more, got %q", more)
+	}
+}
+
+func TestChatWithFailoverStream(t *testing.T) {
+	oldStore := db.StoreInstance
+	db.StoreInstance = db.NewMemoryStore()
+	defer func() { db.StoreInstance = oldStore }()
+	primary := &failoverBot{err: errors.New("connection reset")}
+	backup := &failoverBot{}
+	RegisterProvider(&Provider{Name: "stream-a", Title: "A", New: func() BaseChat { return primary }})
+	RegisterProvider(&Provider{Name: "stream-b", Title: "B", New: func() BaseChat { return backup }})
+	t.Setenv(config.Failover_Chains_Key, `{"stream-a":["stream-b"]}`)
+	t.Setenv(config.Retry_Max_Key, "0")
+
+	// 失败的调用已输出的文本被丢弃，接替的机器人从标注开始输出
+	stream := &ReplyStream{}
+	r := chatWithFailover(context.Background(), "stream-a", primary, "u", "你好", stream)
+	if want := "（A 暂时不可用，以下由 B 回答）\n好的：你好"; r != want || stream.buf.String() != want {
+		t.Errorf("unexpected reply %q, streamed %q", r, stream.buf.String())
+	}
+}
@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	summaryPromptHeader = "请将以下对话压缩成一段简洁的摘要，保留用户的身份、偏好、提到的关键事实和尚未解决的问题，" +
+		"摘要将作为后续对话的背景资料，只输出摘要本身，不要超过300字。\n\n"
+	summaryMsgPrefix = "以下是之前对话的摘要：\n"
+
+	// summaryTimeout 生成摘要在回复之后的后台任务中进行，不随请求取消，但不超过请求的截止时间，函数需等待其完成
+	summaryTimeout = 30 * time.Second
+)
+
+// Completer 不读写对话历史的单次补全，用于生成摘要等内部任务
+type Completer interface {
+	Complete(ctx context.Context, userId string, prompt string) (string, error)
+}
+
+// summaryMsg 将摘要作为 system 消息放在 system prompt 之后
+func summaryMsg(summary string) db.Msg {
+	return db.Msg{
+		Role:  "system",
+		Parts: []db.ContentPart{{Type: "text", Data: summaryMsgPrefix + summary}},
+	}
+}
+
+// summarizeHistory 在历史超出保留策略时，将要被裁掉的旧对话与已有摘要合并成新的摘要，返回需要保存的历史。
+// 为避免每轮都重新生成摘要，压缩后只保留策略上限一半的对话；生成失败时退回到直接裁剪
+func summarizeHistory(ctx context.Context, botType, userId, sessionId string, list []db.Msg) []db.Msg {
+	policy := historyPolicy(botType, userId)
+	trimmed := TrimHistory(list, policy)
+	if len(trimmed) == len(list) || !isSummaryEnabled(botType) {
+		return trimmed
+	}
+	kept := TrimHistory(list, halfPolicy(policy))
+	if len(kept) >= len(list) {
+		return trimmed
+	}
+	completer, ok := GetChatBot(botType).(Completer)
+	if !ok {
+		return trimmed
+	}
+
+	system := 0
+	for system < len(list)-1 && list[system].Role == "system" {
+		system++
+	}
+	dropped := list[system : len(list)-(len(kept)-system)]
+
+	oldSummary, _ := db.ChatDbInstance.GetSummary(botType, userId, sessionId)
+	ctx, cancel := detachContext(ctx)
+	defer cancel()
+	ctx, cancelSummary := context.WithTimeout(ctx, summaryTimeout)
+	defer cancelSummary()
+	summary, err := completer.Complete(ctx, userId, buildSummaryPrompt(oldSummary, dropped))
+	if err != nil || strings.TrimSpace(summary) == "" {
+		fmt.Printf("summarize history for user %s failed: %v\n", userId, err)
+		return trimmed
+	}
+	db.ChatDbInstance.SetSummary(botType, userId, sessionId, strings.TrimSpace(summary))
+	return kept
+}
+
+func isSummaryEnabled(botType string) bool {
+	return config.IsMsgSummaryEnabled() && IsSupportPrompt(botType)
+}
+
+// readHistoryPolicy 读取历史时的裁剪策略。开启摘要时不按轮数裁剪，否则超出的旧对话在保存前就已丢失，无法压缩成摘要；
+// 保存的历史已由 summarizeHistory 限制在策略之内，发送时最多多出本轮提问
+func readHistoryPolicy(botType, userId string) config.HistoryPolicy {
+	policy := historyPolicy(botType, userId)
+	if isSummaryEnabled(botType) {
+		policy.MaxTurns = 0
+	}
+	return policy
+}
+
+// halfPolicy 策略上限的一半，设置了的上限至少为 1，为 0 时会被 TrimHistory 当作不限制
+func halfPolicy(policy config.HistoryPolicy) config.HistoryPolicy {
+	half := func(n int) int {
+		if n <= 0 {
+			return n
+		}
+		return max(n/2, 1)
+	}
+	return config.HistoryPolicy{MaxTurns: half(policy.MaxTurns), MaxTokens: half(policy.MaxTokens)}
+}
+
+func buildSummaryPrompt(oldSummary string, msgs []db.Msg) string {
+	var sb strings.Builder
+	sb.WriteString(summaryPromptHeader)
+	if oldSummary != "" {
+		sb.WriteString("之前的摘要：\n")
+		sb.WriteString(oldSummary)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("对话内容：\n")
+	for _, msg := range msgs {
+		role := "助手"
+		if msg.Role == "user" {
+			role = "用户"
+		}
+		for _, part := range msg.Parts {
+			if part.Type == "text" && part.Data != "" {
+				sb.WriteString(fmt.Sprintf("%s：%s\n", role, part.Data))
+			} else if part.Type != "text" {
+				sb.WriteString(fmt.Sprintf("%s：[图片]\n", role))
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,158 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+	"github.com/sashabaranov/go-openai"
+)
+
+type memChatDb struct {
+	msgs      map[string][]db.Msg
+	summaries map[string]string
+}
+
+func (m *memChatDb) GetMsgList(botType string, userId string, sessionId string) ([]db.Msg, error) {
+	return m.msgs[botType+userId+sessionId], nil
+}
+
+func (m *memChatDb) SetMsgList(botType string, userId string, sessionId string, msgList []db.Msg) {
+	m.msgs[botType+userId+sessionId] = msgList
+}
+
+func (m *memChatDb) GetSummary(botType string, userId string, sessionId string) (string, error) {
+	return m.summaries[botType+userId+sessionId], nil
+}
+
+func (m *memChatDb) SetSummary(botType string, userId string, sessionId string, summary string) {
+	m.summaries[botType+userId+sessionId] = summary
+}
+
+type summaryBot struct {
+	Echo
+	prompts []string
+}
+
+func (s *summaryBot) Complete(ctx context.Context, userId string, prompt string) (string, error) {
+	s.prompts = append(s.prompts, prompt)
+	return "用户叫小明，喜欢围棋", nil
+}
+
+func TestSummarizeHistory(t *testing.T) {
+	t.Setenv("MSG_SUMMARY", "true")
+	t.Setenv("MSG_MAX_TURNS", "4")
+	t.Setenv("MSG_MAX_TOKENS", "0")
+	bot := &summaryBot{}
+	RegisterProvider(&Provider{
+		Name:         "summarybot",
+		Capabilities: Capabilities{Prompt: true},
+		New:          func() BaseChat { return bot },
+	})
+	memDb := &memChatDb{msgs: map[string][]db.Msg{}, summaries: map[string]string{}}
+	oldDb := db.ChatDbInstance
+	db.ChatDbInstance = memDb
+	defer func() { db.ChatDbInstance = oldDb }()
+
+	list := []db.Msg{textMsg("system", "prompt")}
+	for i := 0; i < 5; i++ {
+		list = append(list, textMsg("user", "q"), textMsg("assistant", "a"))
+	}
+	list[1] = textMsg("user", "我叫小明")
+
+	kept := summarizeHistory(context.Background(), "summarybot", "u1", db.DefaultSessionId, list)
+	if len(bot.prompts) != 1 || !strings.Contains(bot.prompts[0], "用户：我叫小明") {
+		t.Fatalf("expected dropped turns in summary prompt, got %q", bot.prompts)
+	}
+	// 摘要后只保留一半的轮数
+	if len(kept) != 5 || kept[0].Role != "system" || kept[1].Role != "user" {
+		t.Errorf("unexpected kept history: %+v", kept)
+	}
+	if memDb.summaries["summarybotu1"+db.DefaultSessionId] == "" {
+		t.Error("summary is not saved")
+	}
+
+	// 未超出限制时不生成摘要
+	if got := summarizeHistory(context.Background(), "summarybot", "u1", db.DefaultSessionId, kept); len(got) != len(kept) || len(bot.prompts) != 1 {
+		t.Error("history within policy should not be summarized")
+	}
+
+	memDb.SetMsgList("summarybot", "u1", db.DefaultSessionId, kept)
+	msgs := GetMsgListWithDb("summarybot", "u1", openai.ChatCompletionMessage{Role: "user", Content: "我叫什么"},
+		func(msg openai.ChatCompletionMessage) db.Msg { return textMsg(msg.Role, msg.Content) },
+		func(msg db.Msg) openai.ChatCompletionMessage {
+			return openai.ChatCompletionMessage{Role: msg.Role, Content: msg.Parts[0].Data}
+		})
+	if msgs[0].Role != "system" || !strings.Contains(msgs[0].Content, "喜欢围棋") {
+		t.Fatalf("expected summary injected as system message, got %+v", msgs[0])
+	}
+	if strings.Count(msgs[0].Content+msgs[1].Content, "prompt") != 0 {
+		t.Error("stale system messages from history should be dropped")
+	}
+}
+
+func TestSummarizeHistorySmallPolicy(t *testing.T) {
+	t.Setenv("MSG_SUMMARY", "true")
+	t.Setenv("MSG_MAX_TURNS", "1")
+	t.Setenv("MSG_MAX_TOKENS", "")
+	bot := &summaryBot{}
+	RegisterProvider(&Provider{
+		Name:         "summarybot-small",
+		Capabilities: Capabilities{Prompt: true},
+		New:          func() BaseChat { return bot },
+	})
+	memDb := &memChatDb{msgs: map[string][]db.Msg{}, summaries: map[string]string{}}
+	oldDb := db.ChatDbInstance
+	db.ChatDbInstance = memDb
+	defer func() { db.ChatDbInstance = oldDb }()
+
+	list := []db.Msg{textMsg("system", "prompt")}
+	for i := 0; i < 3; i++ {
+		list = append(list, textMsg("user", "q"), textMsg("assistant", "a"))
+	}
+	// 一半的轮数向下取整为 0 时仍保留一轮，旧对话被压缩成摘要
+	kept := summarizeHistory(context.Background(), "summarybot-small", "u1", db.DefaultSessionId, list)
+	if len(bot.prompts) != 1 || len(kept) != 3 || kept[0].Role != "system" {
+		t.Errorf("expected the history summarized down to one turn, got %+v", kept)
+	}
+}
+
+func TestSummarizeHistoryAcrossTurns(t *testing.T) {
+	t.Setenv("MSG_SUMMARY", "true")
+	t.Setenv("MSG_MAX_TURNS", "2")
+	t.Setenv("MSG_MAX_TOKENS", "")
+	bot := &summaryBot{}
+	RegisterProvider(&Provider{
+		Name:         "summarybot-turns",
+		Capabilities: Capabilities{Prompt: true},
+		New:          func() BaseChat { return bot },
+	})
+	memDb := &memChatDb{msgs: map[string][]db.Msg{}, summaries: map[string]string{}}
+	oldDb := db.ChatDbInstance
+	db.ChatDbInstance = memDb
+	defer func() { db.ChatDbInstance = oldDb }()
+
+	toDb := func(msg openai.ChatCompletionMessage) db.Msg { return textMsg(msg.Role, msg.Content) }
+	fromDb := func(msg db.Msg) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: msg.Role, Content: msg.Parts[0].Data}
+	}
+	// 与机器人的 Chat 相同，读取历史、追加回答后保存
+	for _, q := range []string{"我叫小明", "q2", "q3"} {
+		msgs := GetMsgListWithDb("summarybot-turns", "u1", openai.ChatCompletionMessage{Role: "user", Content: q}, toDb, fromDb)
+		msgs = append(msgs, openai.ChatCompletionMessage{Role: "assistant", Content: "a"})
+		ctx, wait := WithAsyncTasks(context.Background(), nil)
+		SaveMsgListWithDb(ctx, "summarybot-turns", "u1", msgs, toDb)
+		wait()
+	}
+
+	if len(bot.prompts) != 1 || !strings.Contains(bot.prompts[0], "用户：我叫小明") {
+		t.Fatalf("expected the turn over MSG_MAX_TURNS summarized, got %q", bot.prompts)
+	}
+	if memDb.summaries["summarybot-turnsu1"+db.DefaultSessionId] == "" {
+		t.Error("summary is not saved")
+	}
+	if saved := memDb.msgs["summarybot-turnsu1"+db.DefaultSessionId]; len(saved) > 4 {
+		t.Errorf("saved history should stay within MSG_MAX_TURNS, got %+v", saved)
+	}
+}
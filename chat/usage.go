@@ -0,0 +1,186 @@
+package chat
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+const (
+	maxUsageDays     = 90
+	usageTopUsers    = 10
+	usageDateLayout  = "2006-01-02"
+	usageCommandHelp = "格式：/usage [天数] [用户openid]，默认查询今天所有用户的用量"
+)
+
+// TokenUsage 一次调用消耗的token数，以接口返回的为准
+type TokenUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+func (u TokenUsage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// RecordUsage 记录一次调用的用量到账本，并计入用户的每日token额度
+func RecordUsage(userId, botType, model string, usage TokenUsage) {
+	if db.StoreInstance == nil {
+		return
+	}
+	record := db.UsageRecord{
+		UserId:           userId,
+		BotType:          botType,
+		Model:            model,
+		Requests:         1,
+		PromptTokens:     int64(usage.PromptTokens),
+		CompletionTokens: int64(usage.CompletionTokens),
+	}
+	expires := time.Duration(config.GetUsageRetentionDays()) * 24 * time.Hour
+	if err := db.AddUsage(quotaNow(), record, expires); err != nil {
+		fmt.Println("record usage error:", err)
+	}
+	RecordTokenUsage(userId, usage.Total())
+}
+
+// UsageStat 汇总的用量及费用
+type UsageStat struct {
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (s *UsageStat) add(r db.UsageRecord, price config.ModelPrice) {
+	s.Requests += r.Requests
+	s.PromptTokens += r.PromptTokens
+	s.CompletionTokens += r.CompletionTokens
+	s.Cost += (float64(r.PromptTokens)*price.Prompt + float64(r.CompletionTokens)*price.Completion) / 1e6
+}
+
+// UsageReport 按用户、机器人、模型和日期汇总的用量报表
+type UsageReport struct {
+	From     string                `json:"from"`
+	To       string                `json:"to"`
+	Currency string                `json:"currency"`
+	Total    UsageStat             `json:"total"`
+	ByUser   map[string]*UsageStat `json:"by_user"`
+	ByBot    map[string]*UsageStat `json:"by_bot"`
+	ByModel  map[string]*UsageStat `json:"by_model"`
+	ByDay    map[string]*UsageStat `json:"by_day"`
+}
+
+// GetUsageReport 汇总截至今天最近 days 天的用量，userId 不为空时只统计该用户
+func GetUsageReport(days int, userId string) (*UsageReport, error) {
+	days = min(max(days, 1), maxUsageDays)
+	// 与账本和每日额度使用相同的时区划分日期
+	now := quotaNow().In(db.QuotaLocation())
+	pricing := config.GetUsagePricing()
+	report := &UsageReport{
+		From:     now.AddDate(0, 0, 1-days).Format(usageDateLayout),
+		To:       now.Format(usageDateLayout),
+		Currency: config.GetUsageCurrency(),
+		ByUser:   make(map[string]*UsageStat),
+		ByBot:    make(map[string]*UsageStat),
+		ByModel:  make(map[string]*UsageStat),
+		ByDay:    make(map[string]*UsageStat),
+	}
+	stat := func(m map[string]*UsageStat, key string) *UsageStat {
+		if m[key] == nil {
+			m[key] = &UsageStat{}
+		}
+		return m[key]
+	}
+	for i := 0; i < days; i++ {
+		day := now.AddDate(0, 0, -i)
+		records, err := db.GetUsage(day)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if userId != "" && r.UserId != userId {
+				continue
+			}
+			price := config.GetModelPrice(pricing, r.BotType, r.Model)
+			report.Total.add(r, price)
+			stat(report.ByUser, r.UserId).add(r, price)
+			stat(report.ByBot, r.BotType).add(r, price)
+			stat(report.ByModel, r.Model).add(r, price)
+			stat(report.ByDay, day.Format(usageDateLayout)).add(r, price)
+		}
+	}
+	return report, nil
+}
+
+// GetUsageReply /usage [天数] [用户openid]：管理员查看用量和费用
+func GetUsageReply(param, userId string) string {
+	if db.StoreInstance == nil {
+		return "存储不可用，无法统计用量"
+	}
+	days, user := 1, ""
+	fields := strings.Fields(param)
+	if len(fields) > 2 {
+		return usageCommandHelp
+	}
+	if len(fields) > 0 {
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || n <= 0 {
+			return usageCommandHelp
+		}
+		days = n
+	}
+	if len(fields) == 2 {
+		user = fields[1]
+	}
+
+	report, err := GetUsageReport(days, user)
+	if err != nil {
+		return fmt.Sprintf("查询用量失败：%v", err)
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s 至 %s 用量\n", report.From, report.To))
+	if user != "" {
+		sb.WriteString(fmt.Sprintf("用户：%s\n", user))
+	}
+	sb.WriteString("合计：" + report.Total.format(report.Currency) + "\n")
+	writeStats := func(title string, stats map[string]*UsageStat, limit int) {
+		if len(stats) == 0 {
+			return
+		}
+		keys := make([]string, 0, len(stats))
+		for k := range stats {
+			keys = append(keys, k)
+		}
+		// 按费用从高到低排序，未配置价格时按token数排序
+		slices.SortFunc(keys, func(a, b string) int {
+			if c := cmp.Compare(stats[b].Cost, stats[a].Cost); c != 0 {
+				return c
+			}
+			return cmp.Compare(stats[b].PromptTokens+stats[b].CompletionTokens, stats[a].PromptTokens+stats[a].CompletionTokens)
+		})
+		sb.WriteString(fmt.Sprintf("\n%s：\n", title))
+		for i, k := range keys {
+			if limit > 0 && i >= limit {
+				sb.WriteString(fmt.Sprintf("...共%d个\n", len(keys)))
+				break
+			}
+			sb.WriteString(fmt.Sprintf("%s：%s\n", k, stats[k].format(report.Currency)))
+		}
+	}
+	writeStats("按机器人", report.ByBot, 0)
+	writeStats("按模型", report.ByModel, 0)
+	if user == "" {
+		writeStats("按用户", report.ByUser, usageTopUsers)
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func (s *UsageStat) format(currency string) string {
+	return fmt.Sprintf("%d次，输入%d，输出%d，费用%s%.4f", s.Requests, s.PromptTokens, s.CompletionTokens, currency, s.Cost)
+}
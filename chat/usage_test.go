@@ -0,0 +1,57 @@
+package chat
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/pwh-pwh/aiwechat-vercel/config"
+	"github.com/pwh-pwh/aiwechat-vercel/db"
+)
+
+func TestUsageCost(t *testing.T) {
+	t.Setenv("USAGE_PRICING", `{"gpt-4o":{"prompt":2.5,"completion":10},"qwen":{"prompt":1,"completion":2}}`)
+	pricing := config.GetUsagePricing()
+
+	var stat UsageStat
+	stat.add(db.UsageRecord{Requests: 1, PromptTokens: 1000, CompletionTokens: 500},
+		config.GetModelPrice(pricing, config.Bot_Type_Gpt, "gpt-4o"))
+	// 未配置模型价格时按机器人类型计价
+	stat.add(db.UsageRecord{Requests: 2, PromptTokens: 1e6, CompletionTokens: 1e6},
+		config.GetModelPrice(pricing, config.Bot_Type_Qwen, "qwen-max"))
+
+	if stat.Requests != 3 || stat.PromptTokens != 1001000 || stat.CompletionTokens != 1000500 {
+		t.Errorf("unexpected totals: %+v", stat)
+	}
+	if want := 0.0025 + 0.005 + 3; math.Abs(stat.Cost-want) > 1e-9 {
+		t.Errorf("expected cost %v, got %v", want, stat.Cost)
+	}
+	if price := config.GetModelPrice(pricing, config.Bot_Type_Spark, "4.0Ultra"); price.Prompt != 0 || price.Completion != 0 {
+		t.Errorf("unpriced model should cost nothing: %+v", price)
+	}
+}
+
+func TestUsageReportDayBoundary(t *testing.T) {
+	t.Setenv(db.Quota_Timezone_Key, "")
+	oldStore, oldNow := db.StoreInstance, quotaNow
+	db.StoreInstance = db.NewMemoryStore()
+	defer func() { db.StoreInstance, quotaNow = oldStore, oldNow }()
+
+	// UTC 16:00 到 24:00 在北京时间已是次日，账本和每日额度都应记到次日
+	for _, hour := range []int{16, 23} {
+		now := time.Date(2026, 10, 17, hour, 30, 0, 0, time.UTC)
+		quotaNow = func() time.Time { return now }
+		RecordUsage("usage-day-user", config.Bot_Type_Gpt, "gpt-4o", TokenUsage{PromptTokens: 10, CompletionTokens: 5})
+	}
+
+	report, err := GetUsageReport(1, "usage-day-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.To != "2026-10-18" || report.ByDay["2026-10-18"] == nil || report.Total.Requests != 2 {
+		t.Errorf("usage should be counted on the Beijing day, got %+v", report)
+	}
+	if n, _ := db.GetQuota(db.QuotaTokens, "usage-day-user", quotaNow()); n != 30 {
+		t.Errorf("daily token quota should match the usage ledger, got %d", n)
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
@@ -34,68 +35,21 @@ const (
 var (
 	Cache sync.Map
 
-	Support_Bots = []string{Bot_Type_Gpt, Bot_Type_Spark, Bot_Type_Qwen, Bot_Type_Gemini, Bot_Type_Claude, Bot_Type_Keyword}
+	// Support_Bots 由 chat.RegisterProvider 填充，新增机器人无需修改此处
+	Support_Bots []string
+	// LoadBots 由 chat 设置，注册 OPENAI_PROFILES 等延迟注册的机器人，读取 Support_Bots 前调用
+	LoadBots = func() {}
 )
 
-func IsSupportPrompt(botType string) bool {
-	return botType == Bot_Type_Gpt || botType == Bot_Type_Qwen || botType == Bot_Type_Spark || botType == Bot_Type_Claude
+// SupportBots 所有已注册的机器人类型
+func SupportBots() []string {
+	LoadBots()
+	return Support_Bots
 }
 
-func CheckBotConfig(botType string) (actualotType string, err error) {
-	if botType == "" {
-		botType = GetBotType()
-	}
-	actualotType = botType
-	switch actualotType {
-	case Bot_Type_Gpt:
-		err = CheckGptConfig()
-	case Bot_Type_Spark:
-		_, err = GetSparkConfig()
-	case Bot_Type_Qwen:
-		_, err = GetQwenConfig()
-	case Bot_Type_Gemini:
-		err = CheckGeminiConfig()
-	case Bot_Type_Keyword:
-		err = nil // 本地实现，不需要外部配置
-	case Bot_Type_Claude:
-		err = CheckClaudeConfig()
-	}
-	return
-}
-
-func CheckAllBotConfig() (botType string, checkRes map[string]bool) {
-	botType = GetBotType()
-	checkRes = map[string]bool{
-		Bot_Type_Echo:    true,
-		Bot_Type_Gpt:     true,
-		Bot_Type_Spark:   true,
-		Bot_Type_Qwen:    true,
-		Bot_Type_Gemini:  true,
-		Bot_Type_Keyword: true, // 增加对关键词模式的检查
-		Bot_Type_Claude:  true,
-	}
-
-	err := CheckGptConfig()
-	if err != nil {
-		checkRes[Bot_Type_Gpt] = false
-	}
-	_, err = GetSparkConfig()
-	if err != nil {
-		checkRes[Bot_Type_Spark] = false
-	}
-	_, err = GetQwenConfig()
-	if err != nil {
-		checkRes[Bot_Type_Qwen] = false
-	}
-	err = CheckGeminiConfig()
-	if err != nil {
-		checkRes[Bot_Type_Gemini] = false
-	}
-	err = CheckClaudeConfig()
-	if err != nil {
-		checkRes[Bot_Type_Claude] = false
-	}
-	return
+// IsSupportBot 机器人类型是否已注册
+func IsSupportBot(botType string) bool {
+	return slices.Contains(SupportBots(), botType)
 }
 
 func CheckGptConfig() error {
@@ -125,7 +79,7 @@ func CheckClaudeConfig() error {
 
 func GetBotType() string {
 	botType := os.Getenv(Bot_Type_Key)
-	if slices.Contains(Support_Bots, botType) {
+	if IsSupportBot(botType) {
 		return botType
 	} else {
 		return Bot_Type_Echo
@@ -137,7 +91,7 @@ func GetUserBotType(userId string) (bot string) {
 	if err != nil {
 		bot = GetBotType()
 	}
-	if !slices.Contains(Support_Bots, bot) {
+	if !IsSupportBot(bot) {
 		bot = GetBotType()
 	}
 	return
@@ -153,6 +107,76 @@ func GetMaxTokens() int {
 	return maxTokens
 }
 
+const (
+	Msg_Max_Turns_Key      = "MSG_MAX_TURNS"
+	Msg_Max_Tokens_Key     = "MSG_MAX_TOKENS"
+	Msg_Max_Tokens_Map_Key = "MSG_MAX_TOKENS_MAP"
+	Msg_Summary_Key        = "MSG_SUMMARY"
+	Msg_Carry_Over_Key     = "MSG_CARRY_OVER"
+	Compare_Bots_Key       = "COMPARE_BOTS"
+
+	DefaultMsgMaxTurns  = 20
+	DefaultMsgMaxTokens = 6000
+)
+
+// HistoryPolicy 对话历史的保留策略，0 表示不限制
+type HistoryPolicy struct {
+	MaxTurns  int // 最多保留的对话轮数，一问一答为一轮
+	MaxTokens int // 历史记录(含system prompt和本次提问)的最大估算token数
+}
+
+// GetHistoryPolicy 获取对话历史的保留策略，MSG_MAX_TOKENS_MAP 可以按模型或机器人类型单独设置token上限，模型优先
+func GetHistoryPolicy(botType, model string) HistoryPolicy {
+	policy := HistoryPolicy{
+		MaxTurns:  getNonNegativeInt(Msg_Max_Turns_Key, DefaultMsgMaxTurns),
+		MaxTokens: getNonNegativeInt(Msg_Max_Tokens_Key, DefaultMsgMaxTokens),
+	}
+	if raw := os.Getenv(Msg_Max_Tokens_Map_Key); raw != "" {
+		var tokensMap map[string]int
+		if err := json.Unmarshal([]byte(raw), &tokensMap); err != nil {
+			fmt.Printf("%s 格式错误: %v\n", Msg_Max_Tokens_Map_Key, err)
+			return policy
+		}
+		if v, ok := tokensMap[model]; ok && model != "" {
+			policy.MaxTokens = v
+		} else if v, ok := tokensMap[botType]; ok {
+			policy.MaxTokens = v
+		}
+	}
+	return policy
+}
+
+// IsMsgSummaryEnabled 开启后超出保留策略的旧对话会被压缩成摘要，而不是直接丢弃
+func IsMsgSummaryEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(Msg_Summary_Key))
+	return enabled
+}
+
+// IsMsgCarryOverEnabled 开启后切换机器人时把当前会话的对话历史带到新的机器人，用户可以通过 /carry 单独设置
+func IsMsgCarryOverEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv(Msg_Carry_Over_Key))
+	return enabled
+}
+
+// GetCompareBots /compare 使用的机器人，COMPARE_BOTS 以逗号分隔，未配置时返回 nil
+func GetCompareBots() []string {
+	var bots []string
+	for _, bot := range strings.Split(os.Getenv(Compare_Bots_Key), ",") {
+		if bot = strings.TrimSpace(bot); bot != "" {
+			bots = append(bots, bot)
+		}
+	}
+	return bots
+}
+
+func getNonNegativeInt(key string, defaultValue int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v < 0 {
+		return defaultValue
+	}
+	return v
+}
+
 func GetDefaultSystemPrompt() string {
 	return os.Getenv("defaultSystemPrompt")
 }
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	Export_Secret_Key   = "EXPORT_SECRET"
+	Export_Base_Url_Key = "EXPORT_BASE_URL"
+	Export_Link_TTL_Key = "EXPORT_LINK_TTL"
+
+	DefaultExportLinkTTL = 10 // 分钟
+)
+
+// GetExportSecret 签名导出链接的密钥，未配置时 /export 直接分段回复对话记录
+func GetExportSecret() string {
+	return strings.TrimSpace(os.Getenv(Export_Secret_Key))
+}
+
+// GetExportBaseUrl 导出链接使用的域名，如 https://example.com，
+// 未配置时使用 vercel 提供的生产环境域名
+func GetExportBaseUrl() string {
+	if url := strings.TrimSpace(os.Getenv(Export_Base_Url_Key)); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	if host := os.Getenv("VERCEL_PROJECT_PRODUCTION_URL"); host != "" {
+		return "https://" + host
+	}
+	return ""
+}
+
+// GetExportLinkTTL 导出链接的有效期，EXPORT_LINK_TTL 单位分钟
+func GetExportLinkTTL() time.Duration {
+	minutes := getNonNegativeInt(Export_Link_TTL_Key, DefaultExportLinkTTL)
+	if minutes == 0 {
+		minutes = DefaultExportLinkTTL
+	}
+	return time.Duration(minutes) * time.Minute
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	Failover_Chains_Key   = "FAILOVER_CHAINS"
+	Failover_Timeout_Key  = "FAILOVER_TIMEOUT"
+	Breaker_Threshold_Key = "BREAKER_THRESHOLD"
+	Breaker_Cooldown_Key  = "BREAKER_COOLDOWN"
+	Retry_Max_Key         = "RETRY_MAX"
+	Retry_Base_Delay_Key  = "RETRY_BASE_DELAY"
+
+	DefaultBreakerThreshold = 3
+	DefaultBreakerCooldown  = 60 // 秒
+	DefaultRetryMax         = 2
+	DefaultRetryBaseDelay   = 500 // 毫秒
+)
+
+// GetFailoverChains 获取故障转移链，FAILOVER_CHAINS 为 JSON 对象，以机器人类型为 key，
+// 值为该机器人调用失败时依次尝试的机器人，例如 {"gpt":["qwen","gemini"]}
+func GetFailoverChains() map[string][]string {
+	chains := make(map[string][]string)
+	if raw := os.Getenv(Failover_Chains_Key); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &chains); err != nil {
+			fmt.Printf("%s 格式错误: %v\n", Failover_Chains_Key, err)
+		}
+	}
+	return chains
+}
+
+// GetFailoverTimeout 单次调用的超时时间，超时后尝试下一个机器人，FAILOVER_TIMEOUT 单位秒，0 为不限制
+func GetFailoverTimeout() time.Duration {
+	return time.Duration(getNonNegativeInt(Failover_Timeout_Key, 0)) * time.Second
+}
+
+// GetBreakerThreshold 连续失败多少次后熔断，0 为不熔断
+func GetBreakerThreshold() int {
+	return getNonNegativeInt(Breaker_Threshold_Key, DefaultBreakerThreshold)
+}
+
+// GetBreakerCooldown 熔断的持续时间，BREAKER_COOLDOWN 单位秒
+func GetBreakerCooldown() time.Duration {
+	return time.Duration(getNonNegativeInt(Breaker_Cooldown_Key, DefaultBreakerCooldown)) * time.Second
+}
+
+// GetRetryMax 限流、超时等可重试的错误最多重试的次数，0 为不重试
+func GetRetryMax() int {
+	return getNonNegativeInt(Retry_Max_Key, DefaultRetryMax)
+}
+
+// GetRetryBaseDelay 第一次重试前的最长等待时间，之后每次翻倍，RETRY_BASE_DELAY 单位毫秒
+func GetRetryBaseDelay() time.Duration {
+	return time.Duration(getNonNegativeInt(Retry_Base_Delay_Key, DefaultRetryBaseDelay)) * time.Millisecond
+}
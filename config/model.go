@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	Model_Catalog_Key = "MODEL_CATALOG"
+)
+
+// ModelInfo 模型目录中的一个可选模型
+type ModelInfo struct {
+	Name        string   `json:"name"`                  // 调用接口时使用的模型名
+	Aliases     []string `json:"aliases,omitempty"`     // 别名，/setmodel 时可以代替模型名
+	Description string   `json:"description,omitempty"` // /models 中展示的说明
+	AdminOnly   bool     `json:"admin_only,omitempty"`  // 仅管理员可以选择
+}
+
+// defaultModelCatalogs 未在 MODEL_CATALOG 中配置时使用的模型目录，
+// 未列出的机器人(例如 OPENAI_PROFILES 注册的机器人)不限制模型名
+var defaultModelCatalogs = map[string][]ModelInfo{
+	Bot_Type_Gpt: {
+		{Name: "gpt-4o-mini", Aliases: []string{"4o-mini", "mini"}, Description: "速度快，价格低"},
+		{Name: "gpt-4o", Aliases: []string{"4o"}, Description: "综合能力强"},
+		{Name: "gpt-3.5-turbo", Aliases: []string{"3.5"}, Description: "默认模型"},
+	},
+	Bot_Type_Qwen: {
+		{Name: "qwen-turbo", Aliases: []string{"turbo"}, Description: "速度快，价格低"},
+		{Name: "qwen-plus", Aliases: []string{"plus"}, Description: "效果和速度均衡"},
+		{Name: "qwen-max", Aliases: []string{"max"}, Description: "效果最好"},
+	},
+	Bot_Type_Gemini: {
+		{Name: "gemini-1.5-flash-latest", Aliases: []string{"flash"}, Description: "默认模型，支持图片和语音"},
+		{Name: "gemini-1.5-pro-latest", Aliases: []string{"pro"}, Description: "效果更好，速度较慢"},
+	},
+	Bot_Type_Claude: {
+		{Name: DefaultClaudeModel, Aliases: []string{"sonnet"}, Description: "默认模型"},
+		{Name: "claude-3-5-haiku-20241022", Aliases: []string{"haiku"}, Description: "速度快，价格低"},
+		{Name: "claude-3-opus-20240229", Aliases: []string{"opus"}, Description: "效果好，价格高"},
+	},
+	// 星火的模型由接口地址决定，只能在以下版本中选择
+	Bot_Type_Spark: {
+		{Name: "4.0Ultra", Aliases: []string{"ultra", "4.0"}, Description: "星火4.0 Ultra"},
+		{Name: "generalv3.5", Aliases: []string{"max", "3.5"}, Description: "星火Max"},
+		{Name: "pro-128k", Aliases: []string{"128k"}, Description: "星火Pro-128K"},
+		{Name: "generalv3", Aliases: []string{"pro", "3.1"}, Description: "星火Pro"},
+		{Name: "general", Aliases: []string{"lite"}, Description: "星火Lite"},
+	},
+}
+
+// GetModelCatalog 获取机器人的模型目录，MODEL_CATALOG 为 JSON 对象，以机器人类型为 key，
+// 配置后覆盖该机器人的默认目录。目录为空表示不限制模型名
+func GetModelCatalog(botType string) []ModelInfo {
+	if raw := strings.TrimSpace(os.Getenv(Model_Catalog_Key)); raw != "" {
+		var catalogs map[string][]ModelInfo
+		if err := json.Unmarshal([]byte(raw), &catalogs); err != nil {
+			fmt.Printf("%s 格式错误: %v\n", Model_Catalog_Key, err)
+		} else if catalog, ok := catalogs[botType]; ok {
+			return catalog
+		}
+	}
+	return defaultModelCatalogs[botType]
+}
+
+// FindModel 按模型名或别名查找模型，不区分大小写
+func FindModel(catalog []ModelInfo, name string) (ModelInfo, bool) {
+	name = strings.TrimSpace(name)
+	idx := slices.IndexFunc(catalog, func(m ModelInfo) bool {
+		return strings.EqualFold(m.Name, name) || slices.ContainsFunc(m.Aliases, func(alias string) bool {
+			return strings.EqualFold(alias, name)
+		})
+	})
+	if idx < 0 {
+		return ModelInfo{}, false
+	}
+	return catalog[idx], true
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	Openai_Profiles_Key = "OPENAI_PROFILES"
+)
+
+// OpenAIProfile 一个 OpenAI 兼容接口的配置，例如 DeepSeek、Moonshot、GLM、Ollama 等，
+// 每个 profile 都会注册为独立的机器人类型
+type OpenAIProfile struct {
+	Name    string `json:"name"`    // 机器人类型，同时作为历史记录的命名空间
+	Title   string `json:"title"`   // 展示名称，默认为 name
+	Url     string `json:"url"`     // 接口地址，例如 https://api.deepseek.com/v1
+	Key     string `json:"key"`     // api key，本地 Ollama 等可不填
+	Model   string `json:"model"`   // 默认模型
+	Welcome string `json:"welcome"` // 切换时的欢迎语
+	Command string `json:"command"` // 切换命令，默认为 /name
+}
+
+// GetOpenAIProfiles 从环境变量 OPENAI_PROFILES 读取 JSON 数组格式的配置
+func GetOpenAIProfiles() ([]OpenAIProfile, error) {
+	raw := strings.TrimSpace(os.Getenv(Openai_Profiles_Key))
+	if raw == "" {
+		return nil, nil
+	}
+	var profiles []OpenAIProfile
+	if err := json.Unmarshal([]byte(raw), &profiles); err != nil {
+		return nil, fmt.Errorf("%s 格式错误: %w", Openai_Profiles_Key, err)
+	}
+	for i := range profiles {
+		p := &profiles[i]
+		p.Name = strings.ToLower(strings.TrimSpace(p.Name))
+		p.Url = strings.TrimRight(strings.TrimSpace(p.Url), "/")
+		if p.Title == "" {
+			p.Title = p.Name
+		}
+		if p.Command == "" {
+			p.Command = "/" + p.Name
+		}
+		if p.Welcome == "" {
+			p.Welcome = fmt.Sprintf("我是%s，开始聊天吧！", p.Title)
+		}
+	}
+	return profiles, nil
+}
+
+// Check 校验 profile 配置
+func (p *OpenAIProfile) Check() error {
+	if p.Name == "" {
+		return errors.New("OPENAI_PROFILES 中的 name 不能为空")
+	}
+	if !strings.HasPrefix(p.Url, "http://") && !strings.HasPrefix(p.Url, "https://") {
+		return fmt.Errorf("请配置 %s 的 url", p.Name)
+	}
+	if p.Model == "" {
+		return fmt.Errorf("请配置 %s 的 model", p.Name)
+	}
+	if !strings.HasPrefix(p.Command, "/") {
+		return fmt.Errorf("%s 的 command 必须以 / 开头", p.Name)
+	}
+	return nil
+}
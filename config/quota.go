@@ -0,0 +1,70 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	Quota_Rpm_Key          = "QUOTA_RPM"
+	Quota_Daily_Msgs_Key   = "QUOTA_DAILY_MSGS"
+	Quota_Daily_Tokens_Key = "QUOTA_DAILY_TOKENS"
+	Quota_Tiers_Key        = "QUOTA_TIERS"
+	Quota_Global_Key       = "QUOTA_GLOBAL"
+
+	Quota_Default_Tier = "default"
+)
+
+// QuotaLimit 额度限制，0 表示不限制
+type QuotaLimit struct {
+	Rpm         int64 `json:"rpm"`          // 每分钟请求数
+	DailyMsgs   int64 `json:"daily_msgs"`   // 每日消息数
+	DailyTokens int64 `json:"daily_tokens"` // 每日token数
+}
+
+func (l QuotaLimit) IsUnlimited() bool {
+	return l.Rpm <= 0 && l.DailyMsgs <= 0 && l.DailyTokens <= 0
+}
+
+// GetQuotaTiers 获取额度等级，QUOTA_RPM 等变量配置的是 default 等级，QUOTA_TIERS 可以覆盖或新增等级
+func GetQuotaTiers() map[string]QuotaLimit {
+	tiers := map[string]QuotaLimit{
+		Quota_Default_Tier: {
+			Rpm:         int64(getNonNegativeInt(Quota_Rpm_Key, 0)),
+			DailyMsgs:   int64(getNonNegativeInt(Quota_Daily_Msgs_Key, 0)),
+			DailyTokens: int64(getNonNegativeInt(Quota_Daily_Tokens_Key, 0)),
+		},
+	}
+	if raw := os.Getenv(Quota_Tiers_Key); raw != "" {
+		var custom map[string]QuotaLimit
+		if err := json.Unmarshal([]byte(raw), &custom); err != nil {
+			fmt.Printf("%s 格式错误: %v\n", Quota_Tiers_Key, err)
+			return tiers
+		}
+		for name, limit := range custom {
+			tiers[name] = limit
+		}
+	}
+	return tiers
+}
+
+// GetQuotaLimit 获取指定等级的额度，未知等级按 default 处理
+func GetQuotaLimit(tier string) QuotaLimit {
+	tiers := GetQuotaTiers()
+	if limit, ok := tiers[tier]; ok && tier != "" {
+		return limit
+	}
+	return tiers[Quota_Default_Tier]
+}
+
+// GetGlobalQuotaLimit 所有用户共享的额度，用于控制整体的接口费用
+func GetGlobalQuotaLimit() QuotaLimit {
+	var limit QuotaLimit
+	if raw := os.Getenv(Quota_Global_Key); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &limit); err != nil {
+			fmt.Printf("%s 格式错误: %v\n", Quota_Global_Key, err)
+		}
+	}
+	return limit
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
 	"os"
 	"regexp"
 	"strings"
@@ -95,6 +97,33 @@ func GetSparkConfig() (cfg *SparkConfig, err error) {
 	return
 }
 
+// sparkDomainPaths 各版本 domain 对应的接口路径
+var sparkDomainPaths = map[string]string{
+	"4.0Ultra":    "/v4.0/chat",
+	"generalv3.5": "/v3.5/chat",
+	"pro-128k":    "/chat/pro-128k",
+	"generalv3":   "/v3.1/chat",
+	"generalv2":   "/v2.1/chat",
+	"general":     "/v1.1/chat",
+}
+
+// WithDomain 返回使用指定版本的配置，接口地址的路径随 domain 替换
+func (cfg *SparkConfig) WithDomain(domain string) (*SparkConfig, error) {
+	path, ok := sparkDomainPaths[domain]
+	if !ok {
+		return nil, fmt.Errorf("不支持的星火版本：%s", domain)
+	}
+	u, err := url.Parse(cfg.HostUrl)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path
+	c := *cfg
+	c.HostUrl = u.String()
+	c.SparkDomainVersion = domain
+	return &c, nil
+}
+
 func GetSparkHostUrl() string {
 	return os.Getenv(Spark_Host_Url_Key)
 }
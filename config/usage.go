@@ -0,0 +1,50 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	Usage_Pricing_Key        = "USAGE_PRICING"
+	Usage_Currency_Key       = "USAGE_CURRENCY"
+	Usage_Retention_Days_Key = "USAGE_RETENTION_DAYS"
+
+	DefaultUsageRetentionDays = 90
+)
+
+// ModelPrice 模型每百万token的价格
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// GetUsagePricing 获取模型价格表，USAGE_PRICING 以模型名或机器人类型为key
+func GetUsagePricing() map[string]ModelPrice {
+	pricing := make(map[string]ModelPrice)
+	if raw := os.Getenv(Usage_Pricing_Key); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &pricing); err != nil {
+			fmt.Printf("%s 格式错误: %v\n", Usage_Pricing_Key, err)
+		}
+	}
+	return pricing
+}
+
+// GetModelPrice 获取模型的价格，模型优先，其次是机器人类型，未配置时价格为0
+func GetModelPrice(pricing map[string]ModelPrice, botType, model string) ModelPrice {
+	if price, ok := pricing[model]; ok && model != "" {
+		return price
+	}
+	return pricing[botType]
+}
+
+// GetUsageCurrency 费用展示时使用的货币符号
+func GetUsageCurrency() string {
+	return os.Getenv(Usage_Currency_Key)
+}
+
+// GetUsageRetentionDays 用量账本的保留天数，0 为永久保留
+func GetUsageRetentionDays() int {
+	return getNonNegativeInt(Usage_Retention_Days_Key, DefaultUsageRetentionDays)
+}
@@ -1,11 +1,10 @@
 package config
 
 import (
-	"fmt"
 	"os"
+	"strconv"
 	"strings"
-
-	"github.com/pwh-pwh/aiwechat-vercel/db"
+	"time"
 )
 
 const (
@@ -14,6 +13,14 @@ const (
 	Wx_App_Secret_key      = "WX_APP_SECRET"
 	Wx_Subscribe_Reply_key = "WX_SUBSCRIBE_REPLY"
 	Wx_Help_Reply_key      = "WX_HELP_REPLY"
+	Wx_Api_Base_Url_key    = "WX_API_BASE_URL"
+	Wx_Encoding_AES_Key    = "WX_ENCODING_AES_KEY"
+	Wx_Reply_Max_Bytes_key = "WX_REPLY_MAX_BYTES"
+	Wx_Request_Timeout_key = "WX_REQUEST_TIMEOUT"
+
+	DefaultWxApiBaseUrl     = "https://api.weixin.qq.com"
+	DefaultWxReplyMaxBytes  = 600
+	DefaultWxRequestTimeout = 25 // 秒，低于 Vercel 函数常见的最长运行时间
 
 	Wx_Event_Key_Chat_Gpt_key   = "AI_CHAT_GPT"
 	Wx_Event_Key_Chat_Spark_key = "AI_CHAT_SPARK"
@@ -30,6 +37,21 @@ const (
 	Wx_Command_GetPrompt = "/getpt"
 	Wx_Command_SetModel  = "/setmodel"
 	Wx_Command_GetModel  = "/getmodel"
+	Wx_Command_Models    = "/models"
+	Wx_Command_Set       = "/set"
+	Wx_Command_Params    = "/params"
+	Wx_Command_Persona   = "/persona"
+
+	Wx_Command_NewSession    = "/new"
+	Wx_Command_Sessions      = "/sessions"
+	Wx_Command_SwitchSession = "/switch"
+	Wx_Command_RenameSession = "/rename"
+	Wx_Command_DelSession    = "/delsession"
+	Wx_Command_Export        = "/export"
+	Wx_Command_Carry         = "/carry"
+	Wx_Command_Compare       = "/compare"
+	Wx_Command_Ask           = "/ask"
+
 	Wx_Command_Clear     = "/clear"
 	Wx_Command_Keyword   = "/keyword" // 切换到关键词自动回复模式
 	Wx_Command_AI        = "/ai"      // 切换回AI对话模式
@@ -44,6 +66,18 @@ const (
 	Wx_Coin = "/cb"
 
 	Wx_Command_AddMe = "/addme"
+	Wx_Command_More  = "/more"
+	Wx_Command_Quota = "/quota"
+	Wx_Command_Tier  = "/tier"
+	Wx_Command_Usage = "/usage"
+
+	Wx_Command_Grant  = "/grant"
+	Wx_Command_Revoke = "/revoke"
+	Wx_Command_Roles  = "/roles"
+
+	Wx_Command_Invite    = "/invite"
+	Wx_Command_Invites   = "/invites"
+	Wx_Command_DelInvite = "/delinvite"
 )
 
 var (
@@ -59,19 +93,48 @@ func GetWxAppId() string {
 func GetWxAppSecret() string {
 	return os.Getenv(Wx_App_Secret_key)
 }
+// GetWxReplyMaxBytes 单条回复的最大字节数，超过后回复会被拆分
+func GetWxReplyMaxBytes() int {
+	maxBytes, err := strconv.Atoi(os.Getenv(Wx_Reply_Max_Bytes_key))
+	if err != nil || maxBytes <= 0 {
+		return DefaultWxReplyMaxBytes
+	}
+	return maxBytes
+}
+
+// GetWxRequestTimeout 处理一条消息的最长时间，包括超时后的异步回复，超过后取消对AI接口的调用。
+// 回复后函数会等待异步回复和保存历史完成，必须小于 Vercel 函数的 maxDuration，否则实例被回收时这些任务会丢失。
+// WX_REQUEST_TIMEOUT 单位秒
+func GetWxRequestTimeout() time.Duration {
+	timeout, err := strconv.Atoi(os.Getenv(Wx_Request_Timeout_key))
+	if err != nil || timeout <= 0 {
+		timeout = DefaultWxRequestTimeout
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// GetWxEncodingAESKey 消息加解密密钥，为空时只支持明文模式
+func GetWxEncodingAESKey() string {
+	return strings.TrimSpace(os.Getenv(Wx_Encoding_AES_Key))
+}
+func GetWxApiBaseUrl() string {
+	if url := strings.TrimSpace(os.Getenv(Wx_Api_Base_Url_key)); url != "" {
+		return strings.TrimRight(url, "/")
+	}
+	return DefaultWxApiBaseUrl
+}
+
+// IsWxCustomMsgEnabled 配置了 AppID 和 AppSecret 才能调用客服消息接口
+func IsWxCustomMsgEnabled() bool {
+	return GetWxAppId() != "" && GetWxAppSecret() != ""
+}
 func GetWxSubscribeReply() string {
 	subscribeMsg := os.Getenv(Wx_Subscribe_Reply_key)
 	return strings.ReplaceAll(subscribeMsg, "\\n", "\n")
 }
+// GetWxHelpReply 自定义帮助文本，为空时由 chat.GetHelpReply 根据已注册的机器人和命令生成
 func GetWxHelpReply() string {
 	helpMsg := os.Getenv(Wx_Help_Reply_key)
-	if helpMsg == "" {
-		helpMsg = "输入以下命令进行对话\n/help：查看帮助\n/gpt：与GPT对话\n/spark：与星火对话\n/qwen：与通义千问对话\n/gemini：与gemini对话\n/claude：与claude对话\n/keyword：切换到关键词回复模式\n/ai：切换到AI对话模式\n/addkeyword 关键词:回复内容：添加关键词\n/delkeyword 关键词：删除关键词\n/listkeywords：查看关键词列表\n" +
-			"/prompt 你的prompt: 设置system prompt\n/getpt: 获取当前设置prompt\n/cpt: 清除当前设置prompt\n" +
-			"/setmodel model: 设置自定义model\n/setmodel: 重置model为默认值\n/getmodel: 获取当前model\n" +
-			"/clear:清除历史对话\n" + "/ta 代办事项1:设置todo\n" + "/tl:获取代办列表\n" + "/td 2:删除索引代办事件\n" + "/cb 代币对:查询价格" +
-			"/addme 密码: 认证用户"
-	}
 	return strings.ReplaceAll(helpMsg, "\\n", "\n")
 }
 func GetWxEventKeyChatGpt() string {
@@ -88,30 +151,8 @@ func GetAddMePassword() string {
 	return os.Getenv("ADDME_PASSWORD")
 }
 
-func IsUserAuthenticated(userId string) bool {
-	authenticated, err := db.GetValue(fmt.Sprintf("addme_auth:%s", userId))
-	return err == nil && authenticated == "true"
+// IsInviteOnly 开启后用户需要通过 /addme 邀请码 认证后才能使用AI功能
+func IsInviteOnly() bool {
+	inviteOnly, _ := strconv.ParseBool(os.Getenv("INVITE_ONLY"))
+	return inviteOnly
 }
-
-func AuthenticateUser(userId string) {
-	db.SetValue(fmt.Sprintf("addme_auth:%s", userId), "true", 0)
-}
-
-func GetBotWelcomeReply(botType string) string {
-	switch botType {
-	case Bot_Type_Gpt:
-		return GetGptWelcomeReply()
-	case Bot_Type_Gemini:
-		return GetGeminiWelcomeReply()
-	case Bot_Type_Spark:
-		return GetSparkWelcomeReply()
-	case Bot_Type_Qwen:
-		return GetQwenWelcomeReply()
-	case Bot_Type_Keyword:
-		return "已切换到关键词回复模式，请发送消息进行匹配。\n使用 /ai 切换回 AI 对话。"
-	case Bot_Type_Claude:
-		return GetClaudeWelcomeReply()
-	}
-
-	return botType
-}
\ No newline at end of file
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"github.com/bytedance/sonic"
+)
+
+// BREAKER_KEY 机器人的健康状态，field 为机器人类型，保存在存储中以便多个实例和 /api/check 共享
+const BREAKER_KEY = "breaker"
+
+// BreakerState 机器人的熔断状态，只保存有过失败的机器人
+type BreakerState struct {
+	Failures      int    `json:"failures"`                  // 连续失败次数
+	OpenUntil     int64  `json:"open_until,omitempty"`      // 熔断结束的时间戳，之前不再调用该机器人
+	LastErrorKind string `json:"last_error_kind,omitempty"` // 最近一次失败的错误类型
+	LastStatus    int    `json:"last_status,omitempty"`     // 最近一次失败的 http 状态码，未知时为 0
+	LastFailureAt int64  `json:"last_failure_at,omitempty"` // 最近一次失败的时间戳
+}
+
+// GetBreakerStates 获取所有有过失败的机器人的状态
+func GetBreakerStates() (map[string]*BreakerState, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	vals, err := StoreInstance.HGetAll(BREAKER_KEY)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]*BreakerState, len(vals))
+	for botType, val := range vals {
+		var state BreakerState
+		if err = sonic.UnmarshalString(val, &state); err == nil {
+			states[botType] = &state
+		}
+	}
+	return states, nil
+}
+
+// GetBreakerState 获取机器人的状态，没有失败记录时返回 nil
+func GetBreakerState(botType string) (*BreakerState, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	val, ok, err := StoreInstance.HGet(BREAKER_KEY, botType)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var state BreakerState
+	if err = sonic.UnmarshalString(val, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// UpdateBreakerState 原子地修改机器人的状态
+func UpdateBreakerState(botType string, f func(state *BreakerState)) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	return StoreInstance.HUpdate(BREAKER_KEY, botType, func(val string, ok bool) (string, error) {
+		var state BreakerState
+		if ok {
+			sonic.UnmarshalString(val, &state)
+		}
+		f(&state)
+		return sonic.MarshalString(state)
+	})
+}
+
+// ResetBreakerState 调用成功后清除机器人的失败记录
+func ResetBreakerState(botType string) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	return StoreInstance.HDel(BREAKER_KEY, botType)
+}
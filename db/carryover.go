@@ -0,0 +1,32 @@
+package db
+
+// CARRY_OVER_KEY 用户对切换机器人时携带对话历史的设置，field 为用户openid，未设置时使用全局配置
+const CARRY_OVER_KEY = "carryover"
+
+// SetCarryOver 保存用户的设置，enabled 为 nil 时恢复全局配置
+func SetCarryOver(userId string, enabled *bool) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	if enabled == nil {
+		return StoreInstance.HDel(CARRY_OVER_KEY, userId)
+	}
+	val := "false"
+	if *enabled {
+		val = "true"
+	}
+	return StoreInstance.HSet(CARRY_OVER_KEY, userId, val)
+}
+
+// GetCarryOver 获取用户的设置，未设置时返回 nil
+func GetCarryOver(userId string) (*bool, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	val, ok, err := StoreInstance.HGet(CARRY_OVER_KEY, userId)
+	if err != nil || !ok {
+		return nil, err
+	}
+	enabled := val == "true"
+	return &enabled, nil
+}
@@ -1,9 +1,6 @@
 package db
 
 import (
-	"context"
-	"crypto/tls"
-	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -12,18 +9,18 @@ import (
 	"time"
 
 	"github.com/bytedance/sonic"
-	"github.com/go-redis/redis/v8"
 )
 
 var (
-	ChatDbInstance ChatDb        = nil
-	RedisClient    *redis.Client = nil
+	ChatDbInstance ChatDb = nil
+	StoreInstance  Store  = nil
 	Cache          sync.Map
 )
 
 const (
 	PROMPT_KEY = "prompt"
 	MSG_KEY    = "msg"
+	SUMMARY_KEY = "summary"
 	MODEL_KEY  = "model"
 	TODO_KEY   = "todo"
 	KEYWORD_REPLY_KEY = "keyword"
@@ -36,12 +33,13 @@ type KeywordReply struct {
 }
 
 func init() {
-	db, err := GetChatDb()
+	store, err := NewStore()
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	ChatDbInstance = db
+	StoreInstance = store
+	ChatDbInstance = NewStoreChatDb(store)
 }
 
 // ContentPart represents a part of a message, which can be text or an image.
@@ -57,48 +55,58 @@ type Msg struct {
 	Parts []ContentPart `json:"parts"`
 }
 
+// ChatDb 对话历史按机器人、用户和会话保存，sessionId 见 DefaultSessionId
 type ChatDb interface {
-	GetMsgList(botType string, userId string) ([]Msg, error)
-	SetMsgList(botType string, userId string, msgList []Msg)
+	GetMsgList(botType string, userId string, sessionId string) ([]Msg, error)
+	SetMsgList(botType string, userId string, sessionId string, msgList []Msg)
+	// 较早的对话被压缩成的摘要，与对话历史一同过期
+	GetSummary(botType string, userId string, sessionId string) (string, error)
+	SetSummary(botType string, userId string, sessionId string, summary string)
 }
 
-type RedisChatDb struct {
-	client *redis.Client
+type StoreChatDb struct {
+	store Store
 }
 
-func NewRedisChatDb(url string) (*RedisChatDb, error) {
-	options, err := redis.ParseURL(url)
-	if err != nil {
-		fmt.Println(err)
-		return nil, errors.New("redis url error")
+func NewStoreChatDb(store Store) *StoreChatDb {
+	return &StoreChatDb{
+		store: store,
 	}
-	options.TLSConfig = &tls.Config{InsecureSkipVerify: true}
-	client := redis.NewClient(options)
-	RedisClient = client
-	return &RedisChatDb{
-		client: client,
-	}, nil
 }
 
-func (r *RedisChatDb) GetMsgList(botType string, userId string) ([]Msg, error) {
-	result, err := r.client.Get(context.Background(), fmt.Sprintf("%v:%v:%v", MSG_KEY, botType, userId)).Result()
-	if err != nil {
+func (s *StoreChatDb) GetMsgList(botType string, userId string, sessionId string) ([]Msg, error) {
+	result, ok, err := s.store.Get(historyKey(MSG_KEY, botType, userId, sessionId))
+	if err != nil || !ok {
 		return nil, err
 	}
 	var msgList []Msg
-	err = sonic.Unmarshal([]byte(result), &msgList)
+	err = sonic.UnmarshalString(result, &msgList)
 	if err != nil {
 		return nil, err
 	}
 	return msgList, nil
 }
 
-func (r *RedisChatDb) SetMsgList(botType string, userId string, msgList []Msg) {
-	res, err := sonic.Marshal(msgList)
+func (s *StoreChatDb) SetMsgList(botType string, userId string, sessionId string, msgList []Msg) {
+	res, err := sonic.MarshalString(msgList)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	s.store.Set(historyKey(MSG_KEY, botType, userId, sessionId), res, msgExpires())
+}
+
+func (s *StoreChatDb) GetSummary(botType string, userId string, sessionId string) (string, error) {
+	result, _, err := s.store.Get(historyKey(SUMMARY_KEY, botType, userId, sessionId))
+	return result, err
+}
+
+func (s *StoreChatDb) SetSummary(botType string, userId string, sessionId string, summary string) {
+	s.store.Set(historyKey(SUMMARY_KEY, botType, userId, sessionId), summary, msgExpires())
+}
+
+// msgExpires 对话记录的过期时间，由 MSG_TIME 配置(单位分钟)
+func msgExpires() time.Duration {
 	msgTime := os.Getenv("MSG_TIME")
 	var expires time.Duration
 	//转换为数字
@@ -111,21 +119,14 @@ func (r *RedisChatDb) SetMsgList(botType string, userId string, msgList []Msg) {
 	} else {
 		expires = time.Minute * time.Duration(msgT)
 	}
-
-	r.client.Set(context.Background(), fmt.Sprintf("%v:%v:%v", MSG_KEY, botType, userId), res, expires)
+	return expires
 }
 
 func GetChatDb() (ChatDb, error) {
-	kvUrl := os.Getenv("KV_URL")
-	if kvUrl == "" {
-		return nil, errors.New("请配置KV_URL")
-	} else {
-		db, err := NewRedisChatDb(kvUrl)
-		if err != nil {
-			return nil, err
-		}
-		return db, nil
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
 	}
+	return NewStoreChatDb(StoreInstance), nil
 }
 
 func GetValueWithMemory(key string) (string, bool) {
@@ -147,12 +148,14 @@ func DeleteKeyWithMemory(key string) {
 func GetValue(key string) (val string, err error) {
 	val, flag := GetValueWithMemory(key)
 	if !flag {
-		if RedisClient == nil {
-			return "", errors.New("redis client is nil")
+		if StoreInstance == nil {
+			return "", ErrStoreNil
 		}
-		val, err = RedisClient.Get(context.Background(), key).Result()
-		if err == redis.Nil {
-			return "", nil
+		var ok bool
+		val, ok, err = StoreInstance.Get(key)
+		if err != nil || !ok {
+			// 不缓存不存在的键，其它实例之后写入的值才能被读到
+			return "", err
 		}
 		SetValueWithMemory(key, val)
 		return
@@ -161,27 +164,44 @@ func GetValue(key string) (val string, err error) {
 }
 
 func SetValue(key string, val any, expires time.Duration) (err error) {
-	SetValueWithMemory(key, val)
+	str := storeString(val)
+	SetValueWithMemory(key, str)
 
-	if RedisClient == nil {
-		return errors.New("redis client is nil")
+	if StoreInstance == nil {
+		return ErrStoreNil
 	}
-	
-	err = RedisClient.Set(context.Background(), key, val, expires).Err()
+
+	err = StoreInstance.Set(key, str, expires)
 
 	return
 }
 
+// storeString 将写入的值转换为字符串，[]byte 按内容转换
+func storeString(val any) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
 func DeleteKey(key string) {
 	DeleteKeyWithMemory(key)
-	if RedisClient == nil {
+	if StoreInstance == nil {
 		return
 	}
-	RedisClient.Del(context.Background(), key)
+	StoreInstance.Del(key)
 }
 
-func DeleteMsgList(botType string, userId string) {
-	RedisClient.Del(context.Background(), fmt.Sprintf("%v:%v:%v", MSG_KEY, botType, userId))
+func DeleteMsgList(botType string, userId string, sessionId string) {
+	if StoreInstance == nil {
+		return
+	}
+	StoreInstance.Del(historyKey(MSG_KEY, botType, userId, sessionId),
+		historyKey(SUMMARY_KEY, botType, userId, sessionId))
 }
 
 func SetPrompt(userId, botType, prompt string) {
@@ -199,7 +219,7 @@ func RemovePrompt(userId, botType string) {
 // todolist format: "todo1|todo2|todo3"
 func GetTodoList(userId string) (string, error) {
 	tListStr, err := GetValue(fmt.Sprintf("%s:%s", TODO_KEY, userId))
-	if err != nil && RedisClient == nil {
+	if err != nil && StoreInstance == nil {
 		return "", err
 	}
 	if tListStr == "" {
@@ -215,7 +235,7 @@ func GetTodoList(userId string) (string, error) {
 
 func AddTodoList(userId string, todo string) error {
 	todoList, err := GetValue(fmt.Sprintf("%s:%s", TODO_KEY, userId))
-	if err != nil && RedisClient == nil {
+	if err != nil && StoreInstance == nil {
 		return err
 	}
 	if todoList == "" {
@@ -228,7 +248,7 @@ func AddTodoList(userId string, todo string) error {
 
 func DelTodoList(userId string, todoIndex int) error {
 	todoList, err := GetValue(fmt.Sprintf("%s:%s", TODO_KEY, userId))
-	if err != nil && RedisClient == nil {
+	if err != nil && StoreInstance == nil {
 		return err
 	}
 	todoList = strings.Split(todoList, "|")[todoIndex-1]
@@ -245,13 +265,13 @@ func SetModel(userId, botType, model string) error {
 }
 
 func GetModel(userId, botType string) (string, error) {
-	return GetValue(fmt.Sprintf("%s:%s:%s", MODEL_KEY, userId))
+	return GetValue(fmt.Sprintf("%s:%s:%s", MODEL_KEY, userId, botType))
 }
 
 // SetKeywordReply adds or updates a keyword reply.
 func SetKeywordReply(keyword, reply string) error {
 	replies, err := GetKeywordReplies()
-	if err != nil && err != redis.Nil {
+	if err != nil {
 		return err
 	}
 
@@ -279,9 +299,6 @@ func SetKeywordReply(keyword, reply string) error {
 func GetKeywordReplies() ([]KeywordReply, error) {
 	val, err := GetValue(KEYWORD_REPLY_KEY)
 	if err != nil {
-		if err == redis.Nil {
-			return []KeywordReply{}, nil
-		}
 		return nil, err
 	}
 
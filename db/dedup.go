@@ -0,0 +1,35 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	MSG_DEDUP_KEY        = "msgdedup"
+	MSG_DEDUP_RESULT_KEY = "msgresult"
+)
+
+// TryLockMsg 尝试占用一条微信消息的处理权，返回 true 表示当前请求负责处理该消息
+func TryLockMsg(msgKey string, expires time.Duration) (bool, error) {
+	if StoreInstance == nil {
+		return false, ErrStoreNil
+	}
+	return StoreInstance.SetNX(fmt.Sprintf("%s:%s", MSG_DEDUP_KEY, msgKey), "1", expires)
+}
+
+// SetMsgResult 保存消息的处理结果，供微信重试请求直接取用
+func SetMsgResult(msgKey, result string, expires time.Duration) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	return StoreInstance.Set(fmt.Sprintf("%s:%s", MSG_DEDUP_RESULT_KEY, msgKey), result, expires)
+}
+
+// GetMsgResult 获取消息的处理结果，ok 为 false 表示仍在处理中
+func GetMsgResult(msgKey string) (result string, ok bool, err error) {
+	if StoreInstance == nil {
+		return "", false, ErrStoreNil
+	}
+	return StoreInstance.Get(fmt.Sprintf("%s:%s", MSG_DEDUP_RESULT_KEY, msgKey))
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+const (
+	// INVITE_KEY 邀请码保存在一个 hash 中，field 为邀请码
+	INVITE_KEY = "invite"
+	// ROLE_EXPIRE_KEY 通过邀请码获得的角色的过期时间，field 为用户openid
+	ROLE_EXPIRE_KEY = "roleexpire"
+)
+
+var (
+	ErrInviteNotFound = errors.New("邀请码不存在")
+	ErrInviteRevoked  = errors.New("邀请码已作废")
+	ErrInviteExpired  = errors.New("邀请码已过期")
+	ErrInviteUsedUp   = errors.New("邀请码已用完")
+	ErrInviteRedeemed = errors.New("已经使用过该邀请码")
+)
+
+type Invite struct {
+	Code       string             `json:"code"`
+	MaxUses    int                `json:"max_uses"`
+	Role       string             `json:"role"`
+	Tier       string             `json:"tier,omitempty"`
+	AccessDays int                `json:"access_days"` // 获得的角色的有效天数，0 为永久
+	ExpiresAt  int64              `json:"expires_at"`  // 邀请码的过期时间，0 为永不过期
+	CreatedBy  string             `json:"created_by"`
+	CreatedAt  int64              `json:"created_at"`
+	Revoked    bool               `json:"revoked"`
+	Redeemed   []InviteRedemption `json:"redeemed"`
+}
+
+type InviteRedemption struct {
+	UserId string `json:"user_id"`
+	At     int64  `json:"at"`
+}
+
+func (i *Invite) RemainingUses() int {
+	return max(i.MaxUses-len(i.Redeemed), 0)
+}
+
+func SaveInvite(invite *Invite) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	val, err := sonic.MarshalString(invite)
+	if err != nil {
+		return err
+	}
+	return StoreInstance.HSet(INVITE_KEY, invite.Code, val)
+}
+
+func GetInvite(code string) (*Invite, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	val, ok, err := StoreInstance.HGet(INVITE_KEY, code)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrInviteNotFound
+	}
+	var invite Invite
+	if err = sonic.UnmarshalString(val, &invite); err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// GetInvites 获取所有邀请码，按创建时间从新到旧排序
+func GetInvites() ([]*Invite, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	vals, err := StoreInstance.HGetAll(INVITE_KEY)
+	if err != nil {
+		return nil, err
+	}
+	invites := make([]*Invite, 0, len(vals))
+	for _, val := range vals {
+		var invite Invite
+		if err = sonic.UnmarshalString(val, &invite); err == nil {
+			invites = append(invites, &invite)
+		}
+	}
+	sort.Slice(invites, func(i, j int) bool { return invites[i].CreatedAt > invites[j].CreatedAt })
+	return invites, nil
+}
+
+// RedeemInvite 使用邀请码，多个用户同时使用时通过 HUpdate 保证次数不会超出
+func RedeemInvite(code, userId string, now time.Time) (*Invite, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	var redeemed *Invite
+	err := StoreInstance.HUpdate(INVITE_KEY, code, func(val string, ok bool) (string, error) {
+		if !ok {
+			return "", ErrInviteNotFound
+		}
+		var invite Invite
+		if err := sonic.UnmarshalString(val, &invite); err != nil {
+			return "", err
+		}
+		switch {
+		case invite.Revoked:
+			return "", ErrInviteRevoked
+		case invite.ExpiresAt > 0 && now.Unix() > invite.ExpiresAt:
+			return "", ErrInviteExpired
+		case invite.RemainingUses() <= 0:
+			return "", ErrInviteUsedUp
+		}
+		for _, r := range invite.Redeemed {
+			if r.UserId == userId {
+				return "", ErrInviteRedeemed
+			}
+		}
+		invite.Redeemed = append(invite.Redeemed, InviteRedemption{UserId: userId, At: now.Unix()})
+		redeemed = &invite
+		return sonic.MarshalString(&invite)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return redeemed, nil
+}
+
+// SetRoleExpire 设置角色的过期时间，expiresAt 为零值时清除
+func SetRoleExpire(userId string, expiresAt time.Time) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	if expiresAt.IsZero() {
+		return StoreInstance.HDel(ROLE_EXPIRE_KEY, userId)
+	}
+	return StoreInstance.HSet(ROLE_EXPIRE_KEY, userId, strconv.FormatInt(expiresAt.Unix(), 10))
+}
+
+// GetRoleExpire 获取角色的过期时间，永久有效时返回零值
+func GetRoleExpire(userId string) (time.Time, error) {
+	if StoreInstance == nil {
+		return time.Time{}, ErrStoreNil
+	}
+	val, ok, err := StoreInstance.HGet(ROLE_EXPIRE_KEY, userId)
+	if err != nil || !ok {
+		return time.Time{}, err
+	}
+	ts, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts, 0), nil
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/bytedance/sonic"
+)
+
+const PARAMS_KEY = "params"
+
+// GenParams 生成参数，nil 表示未设置
+type GenParams struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+}
+
+func (p GenParams) IsEmpty() bool {
+	return p.Temperature == nil && p.TopP == nil && p.TopK == nil && p.MaxTokens == nil
+}
+
+// SetGenParams 保存用户在某个机器人上的生成参数，全部未设置时清除
+func SetGenParams(userId, botType string, params GenParams) error {
+	key := fmt.Sprintf("%s:%s:%s", PARAMS_KEY, userId, botType)
+	if params.IsEmpty() {
+		DeleteKey(key)
+		return nil
+	}
+	res, err := sonic.MarshalString(params)
+	if err != nil {
+		return err
+	}
+	return SetValue(key, res, 0)
+}
+
+func GetGenParams(userId, botType string) (GenParams, error) {
+	var params GenParams
+	val, err := GetValue(fmt.Sprintf("%s:%s:%s", PARAMS_KEY, userId, botType))
+	if err != nil || val == "" {
+		return params, err
+	}
+	err = sonic.UnmarshalString(val, &params)
+	return params, err
+}
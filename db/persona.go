@@ -0,0 +1,113 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/bytedance/sonic"
+)
+
+const (
+	// PERSONA_KEY 共享的角色保存在一个 hash 中，field 为角色名；用户私有的角色保存在 persona:<用户openid> 中
+	PERSONA_KEY = "persona"
+	// ACTIVE_PERSONA_KEY 用户当前使用的角色，field 为用户openid
+	ACTIVE_PERSONA_KEY = "activepersona"
+)
+
+var ErrPersonaNotFound = errors.New("角色不存在")
+
+// Persona 预设的角色，除名称外的字段均可为空
+type Persona struct {
+	Name        string   `json:"name"`
+	Prompt      string   `json:"prompt"`
+	BotType     string   `json:"bot_type,omitempty"` // 使用角色时切换到的机器人
+	Model       string   `json:"model,omitempty"`    // 仅在 BotType 上生效
+	Temperature *float64 `json:"temperature,omitempty"`
+	Welcome     string   `json:"welcome,omitempty"`
+	CreatedBy   string   `json:"created_by"`
+	CreatedAt   int64    `json:"created_at"`
+}
+
+// personaKey owner 为空时是共享的角色，否则是该用户私有的角色
+func personaKey(owner string) string {
+	if owner == "" {
+		return PERSONA_KEY
+	}
+	return fmt.Sprintf("%s:%s", PERSONA_KEY, owner)
+}
+
+func SavePersona(owner string, persona *Persona) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	val, err := sonic.MarshalString(persona)
+	if err != nil {
+		return err
+	}
+	return StoreInstance.HSet(personaKey(owner), persona.Name, val)
+}
+
+func GetPersona(owner, name string) (*Persona, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	val, ok, err := StoreInstance.HGet(personaKey(owner), name)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrPersonaNotFound
+	}
+	var persona Persona
+	if err = sonic.UnmarshalString(val, &persona); err != nil {
+		return nil, err
+	}
+	return &persona, nil
+}
+
+// GetPersonas 获取所有角色，按名称排序
+func GetPersonas(owner string) ([]*Persona, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	vals, err := StoreInstance.HGetAll(personaKey(owner))
+	if err != nil {
+		return nil, err
+	}
+	personas := make([]*Persona, 0, len(vals))
+	for _, val := range vals {
+		var persona Persona
+		if err = sonic.UnmarshalString(val, &persona); err == nil {
+			personas = append(personas, &persona)
+		}
+	}
+	sort.Slice(personas, func(i, j int) bool { return personas[i].Name < personas[j].Name })
+	return personas, nil
+}
+
+func DeletePersona(owner, name string) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	return StoreInstance.HDel(personaKey(owner), name)
+}
+
+// SetActivePersona 设置用户当前使用的角色，name 为空时清除
+func SetActivePersona(userId, name string) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	if name == "" {
+		return StoreInstance.HDel(ACTIVE_PERSONA_KEY, userId)
+	}
+	return StoreInstance.HSet(ACTIVE_PERSONA_KEY, userId, name)
+}
+
+// GetActivePersona 未使用角色时返回空字符串
+func GetActivePersona(userId string) (string, error) {
+	if StoreInstance == nil {
+		return "", ErrStoreNil
+	}
+	name, _, err := StoreInstance.HGet(ACTIVE_PERSONA_KEY, userId)
+	return name, err
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	QUOTA_KEY      = "quota"
+	QUOTA_TIER_KEY = "quotatier"
+
+	// QUOTA_GLOBAL_USER 全局计数使用的用户标识
+	QUOTA_GLOBAL_USER = "_global"
+
+	QuotaRequests = "rpm"    // 每分钟请求数
+	QuotaMsgs     = "msgs"   // 每日消息数
+	QuotaTokens   = "tokens" // 每日token数
+
+	// Quota_Timezone_Key 按天和分钟计数使用的时区，默认为北京时间
+	Quota_Timezone_Key = "QUOTA_TIMEZONE"
+)
+
+// defaultQuotaLocation 北京时间没有夏令时，使用固定时区，不依赖运行环境中的时区数据
+var defaultQuotaLocation = time.FixedZone("Asia/Shanghai", 8*60*60)
+
+// QuotaLocation QUOTA_TIMEZONE 配置的时区，服务器通常为 UTC，直接使用会让每日额度在北京时间 8 点重置。
+// 用量账本也按此时区划分日期，与每日额度一致
+func QuotaLocation() *time.Location {
+	name := os.Getenv(Quota_Timezone_Key)
+	if name == "" {
+		return defaultQuotaLocation
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		fmt.Printf("invalid %s %s: %v\n", Quota_Timezone_Key, name, err)
+		return defaultQuotaLocation
+	}
+	return loc
+}
+
+// quotaWindow 计数所在的时间窗口及其过期时间，每日计数多保留一天便于查询
+func quotaWindow(counter string, now time.Time) (string, time.Duration) {
+	now = now.In(QuotaLocation())
+	if counter == QuotaRequests {
+		return now.Format("200601021504"), 2 * time.Minute
+	}
+	return now.Format("20060102"), 48 * time.Hour
+}
+
+func quotaKey(counter, userId string, now time.Time) (string, time.Duration) {
+	window, expires := quotaWindow(counter, now)
+	return fmt.Sprintf("%s:%s:%s:%s", QUOTA_KEY, counter, userId, window), expires
+}
+
+// IncrQuota 增加用户在当前时间窗口内的计数，返回增加后的值
+func IncrQuota(counter, userId string, n int64, now time.Time) (int64, error) {
+	if StoreInstance == nil {
+		return 0, ErrStoreNil
+	}
+	key, expires := quotaKey(counter, userId, now)
+	return StoreInstance.IncrBy(key, n, expires)
+}
+
+// GetQuota 获取用户在当前时间窗口内的计数
+func GetQuota(counter, userId string, now time.Time) (int64, error) {
+	if StoreInstance == nil {
+		return 0, ErrStoreNil
+	}
+	key, _ := quotaKey(counter, userId, now)
+	val, ok, err := StoreInstance.Get(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
+}
+
+// SetUserTier 设置用户的额度等级，tier 为空时恢复默认等级
+func SetUserTier(userId, tier string) error {
+	key := fmt.Sprintf("%s:%s", QUOTA_TIER_KEY, userId)
+	if tier == "" {
+		DeleteKey(key)
+		return nil
+	}
+	return SetValue(key, tier, 0)
+}
+
+func GetUserTier(userId string) (string, error) {
+	return GetValue(fmt.Sprintf("%s:%s", QUOTA_TIER_KEY, userId))
+}
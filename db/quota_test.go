@@ -0,0 +1,27 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaWindowDayBoundary(t *testing.T) {
+	t.Setenv(Quota_Timezone_Key, "")
+	// UTC 15:59 为北京时间 23:59，16:00 为次日 0 点
+	before := time.Date(2026, 10, 17, 15, 59, 0, 0, time.UTC)
+	after := before.Add(time.Minute)
+	if w, _ := quotaWindow(QuotaMsgs, before); w != "20261017" {
+		t.Errorf("got window %s", w)
+	}
+	if w, _ := quotaWindow(QuotaMsgs, after); w != "20261018" {
+		t.Errorf("daily quota should reset at midnight Beijing time, got window %s", w)
+	}
+	if w, _ := quotaWindow(QuotaRequests, after); w != "202610180000" {
+		t.Errorf("got window %s", w)
+	}
+
+	t.Setenv(Quota_Timezone_Key, "UTC")
+	if w, _ := quotaWindow(QuotaMsgs, after); w != "20261017" {
+		t.Errorf("QUOTA_TIMEZONE should be used, got window %s", w)
+	}
+}
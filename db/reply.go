@@ -0,0 +1,40 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+const (
+	MORE_REPLY_KEY     = "morereply"
+	MORE_REPLY_EXPIRES = 24 * time.Hour
+)
+
+// SetMoreReply 保存超长回复中尚未发送的部分，chunks 为空时清除
+func SetMoreReply(userId string, chunks []string) error {
+	key := fmt.Sprintf("%s:%s", MORE_REPLY_KEY, userId)
+	if len(chunks) == 0 {
+		DeleteKey(key)
+		return nil
+	}
+	res, err := sonic.MarshalString(chunks)
+	if err != nil {
+		return err
+	}
+	return SetValue(key, res, MORE_REPLY_EXPIRES)
+}
+
+// GetMoreReply 获取超长回复中尚未发送的部分
+func GetMoreReply(userId string) ([]string, error) {
+	val, err := GetValue(fmt.Sprintf("%s:%s", MORE_REPLY_KEY, userId))
+	if err != nil || val == "" {
+		return nil, err
+	}
+	var chunks []string
+	if err = sonic.UnmarshalString(val, &chunks); err != nil {
+		return nil, err
+	}
+	return chunks, nil
+}
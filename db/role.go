@@ -0,0 +1,35 @@
+package db
+
+// ROLE_KEY 用户角色保存在一个 hash 中，field 为用户openid
+const ROLE_KEY = "role"
+
+func SetUserRole(userId, role string) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	return StoreInstance.HSet(ROLE_KEY, userId, role)
+}
+
+// GetUserRole 未授予角色时返回空字符串
+func GetUserRole(userId string) (string, error) {
+	if StoreInstance == nil {
+		return "", ErrStoreNil
+	}
+	role, _, err := StoreInstance.HGet(ROLE_KEY, userId)
+	return role, err
+}
+
+func DeleteUserRole(userId string) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	return StoreInstance.HDel(ROLE_KEY, userId)
+}
+
+// GetUserRoles 获取所有被授予过角色的用户
+func GetUserRoles() (map[string]string, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	return StoreInstance.HGetAll(ROLE_KEY)
+}
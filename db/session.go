@@ -0,0 +1,147 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/bytedance/sonic"
+)
+
+const (
+	// SESSION_KEY 用户的会话保存在 session:<用户openid> hash 中，field 为会话id
+	SESSION_KEY = "session"
+	// ACTIVE_SESSION_KEY 用户当前的会话，field 为用户openid
+	ACTIVE_SESSION_KEY = "activesession"
+	SESSION_SEQ_KEY    = "sessionseq"
+
+	// DefaultSessionId 默认会话，对话历史沿用没有会话之前的 key
+	DefaultSessionId   = "default"
+	DefaultSessionName = "默认"
+)
+
+type Session struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func sessionKey(userId string) string {
+	return fmt.Sprintf("%s:%s", SESSION_KEY, userId)
+}
+
+// historyKey 对话历史和摘要的 key，默认会话为 prefix:botType:userId，其它会话在末尾加上会话id
+func historyKey(prefix, botType, userId, sessionId string) string {
+	if sessionId == "" || sessionId == DefaultSessionId {
+		return fmt.Sprintf("%v:%v:%v", prefix, botType, userId)
+	}
+	return fmt.Sprintf("%v:%v:%v:%v", prefix, botType, userId, sessionId)
+}
+
+// NewSessionId 生成用户的下一个会话id
+func NewSessionId(userId string) (string, error) {
+	if StoreInstance == nil {
+		return "", ErrStoreNil
+	}
+	n, err := StoreInstance.IncrBy(fmt.Sprintf("%s:%s", SESSION_SEQ_KEY, userId), 1, 0)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("s%d", n), nil
+}
+
+func SaveSession(userId string, session *Session) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	val, err := sonic.MarshalString(session)
+	if err != nil {
+		return err
+	}
+	return StoreInstance.HSet(sessionKey(userId), session.Id, val)
+}
+
+// GetSessions 获取用户的所有会话，默认会话总是排在第一个，其余按创建时间排序
+func GetSessions(userId string) ([]*Session, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	vals, err := StoreInstance.HGetAll(sessionKey(userId))
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*Session, 0, len(vals)+1)
+	if _, ok := vals[DefaultSessionId]; !ok {
+		sessions = append(sessions, &Session{Id: DefaultSessionId, Name: DefaultSessionName})
+	}
+	for _, val := range vals {
+		var session Session
+		if err = sonic.UnmarshalString(val, &session); err == nil {
+			sessions = append(sessions, &session)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if sessions[i].Id == DefaultSessionId || sessions[j].Id == DefaultSessionId {
+			return sessions[i].Id == DefaultSessionId
+		}
+		return sessions[i].CreatedAt < sessions[j].CreatedAt
+	})
+	return sessions, nil
+}
+
+// DeleteSession 删除会话及其在各个机器人上的对话历史
+func DeleteSession(userId, sessionId string, botTypes []string) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	for _, botType := range botTypes {
+		DeleteMsgList(botType, userId, sessionId)
+	}
+	if sessionId == DefaultSessionId {
+		return nil
+	}
+	return StoreInstance.HDel(sessionKey(userId), sessionId)
+}
+
+// SetActiveSession 设置用户当前的会话，切换回默认会话时清除
+func SetActiveSession(userId, sessionId string) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	if sessionId == DefaultSessionId {
+		return StoreInstance.HDel(ACTIVE_SESSION_KEY, userId)
+	}
+	return StoreInstance.HSet(ACTIVE_SESSION_KEY, userId, sessionId)
+}
+
+// GetActiveSession 获取用户当前的会话id，未切换过会话时为默认会话
+func GetActiveSession(userId string) (string, error) {
+	if StoreInstance == nil {
+		return DefaultSessionId, ErrStoreNil
+	}
+	id, ok, err := StoreInstance.HGet(ACTIVE_SESSION_KEY, userId)
+	if err != nil || !ok {
+		return DefaultSessionId, err
+	}
+	return id, nil
+}
+
+// ReserveSessionId 导入会话后调用，保证 NewSessionId 不会再生成 sessionId
+func ReserveSessionId(userId, sessionId string) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	var n int64
+	if _, err := fmt.Sscanf(sessionId, "s%d", &n); err != nil {
+		return nil
+	}
+	key := fmt.Sprintf("%s:%s", SESSION_SEQ_KEY, userId)
+	cur, _, err := StoreInstance.Get(key)
+	if err != nil {
+		return err
+	}
+	if seq, _ := strconv.ParseInt(cur, 10, 64); seq >= n {
+		return nil
+	}
+	return StoreInstance.Set(key, strconv.FormatInt(n, 10), 0)
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	Store_Type_Key = "STORE_TYPE"
+	Store_Path_Key = "STORE_PATH"
+
+	Store_Type_Redis  = "redis"
+	Store_Type_Memory = "memory"
+	Store_Type_File   = "file"
+
+	DefaultStorePath = "data/aiwechat.json"
+)
+
+var ErrStoreNil = errors.New("store is nil")
+
+// Store 存储后端，db 包中所有的数据都通过 Store 读写。
+// 键值和 hash 两类数据共用同一个键空间，expires 为 0 表示永不过期
+type Store interface {
+	// Get 获取字符串，ok 为 false 表示不存在
+	Get(key string) (val string, ok bool, err error)
+	Set(key, val string, expires time.Duration) error
+	// SetNX 仅当键不存在时设置，返回是否设置成功
+	SetNX(key, val string, expires time.Duration) (bool, error)
+	Del(keys ...string) error
+	// IncrBy 增加计数并刷新过期时间，返回增加后的值
+	IncrBy(key string, n int64, expires time.Duration) (int64, error)
+
+	HGet(key, field string) (val string, ok bool, err error)
+	HSet(key, field, val string) error
+	HDel(key string, fields ...string) error
+	HGetAll(key string) (map[string]string, error)
+	// HIncrBy 增加 hash 中的计数，expires 大于 0 时刷新整个 hash 的过期时间
+	HIncrBy(key, field string, n int64, expires time.Duration) (int64, error)
+	// HUpdate 原子地读取并修改 hash 中的一个字段，f 返回错误时不做修改
+	HUpdate(key, field string, f func(val string, ok bool) (string, error)) error
+}
+
+// memoryFallbackWarning 未配置存储时回退为内存存储的提示
+const memoryFallbackWarning = "未配置KV_URL，使用内存存储，数据只保存在当前实例中，重启或serverless实例回收后丢失"
+
+// resolveStoreType 根据 STORE_TYPE 选择存储后端，未配置时有 KV_URL 使用 redis，否则回退为内存，fallback 表示是否为回退
+func resolveStoreType() (storeType string, fallback bool) {
+	storeType = os.Getenv(Store_Type_Key)
+	if storeType != "" {
+		return storeType, false
+	}
+	if os.Getenv("KV_URL") != "" {
+		return Store_Type_Redis, false
+	}
+	return Store_Type_Memory, true
+}
+
+// StoreStatus 当前使用的存储后端，用于 /api/check，回退为内存存储时附带提示
+func StoreStatus() string {
+	storeType, fallback := resolveStoreType()
+	switch {
+	case StoreInstance == nil:
+		return fmt.Sprintf("%s (unavailable)", storeType)
+	case fallback:
+		return fmt.Sprintf("%s (%s)", storeType, memoryFallbackWarning)
+	default:
+		return storeType
+	}
+}
+
+// NewStore 根据 STORE_TYPE 创建存储后端，未配置时有 KV_URL 使用 redis，否则使用内存
+func NewStore() (Store, error) {
+	storeType, fallback := resolveStoreType()
+	if fallback {
+		fmt.Println(memoryFallbackWarning)
+	}
+	switch storeType {
+	case Store_Type_Redis:
+		kvUrl := os.Getenv("KV_URL")
+		if kvUrl == "" {
+			return nil, errors.New("请配置KV_URL")
+		}
+		return NewRedisStore(kvUrl)
+	case Store_Type_Memory:
+		return NewMemoryStore(), nil
+	case Store_Type_File:
+		path := os.Getenv(Store_Path_Key)
+		if path == "" {
+			path = DefaultStorePath
+		}
+		return NewFileStore(path)
+	default:
+		return nil, fmt.Errorf("不支持的%s：%s", Store_Type_Key, storeType)
+	}
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// NewFileStore 创建文件存储，数据保存在内存中，每次修改后整体写入 path 指定的 json 文件，
+// 适合单实例部署，不适合 vercel 等只读或多实例环境
+func NewFileStore(path string) (*MemoryStore, error) {
+	m := NewMemoryStore()
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(content) > 0 {
+		if err = sonic.Unmarshal(content, &m.data); err != nil {
+			return nil, err
+		}
+		if m.data == nil {
+			m.data = make(map[string]*memEntry)
+		}
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	m.persist = func(data map[string]*memEntry) error {
+		return saveStoreFile(path, data)
+	}
+	return m, nil
+}
+
+// saveStoreFile 先写临时文件再重命名，避免写入中断导致文件损坏
+func saveStoreFile(path string, data map[string]*memEntry) error {
+	now := time.Now()
+	for key, e := range data {
+		if e.expired(now) {
+			delete(data, key)
+		}
+	}
+	content, err := sonic.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errWrongType = errors.New("键的类型不匹配")
+
+type memEntry struct {
+	Value    string            `json:"value,omitempty"`
+	Hash     map[string]string `json:"hash,omitempty"`
+	ExpireAt int64             `json:"expire_at,omitempty"` // 过期时间(unix 纳秒)，0 为永不过期
+}
+
+func (e *memEntry) expired(now time.Time) bool {
+	return e.ExpireAt > 0 && now.UnixNano() >= e.ExpireAt
+}
+
+func (e *memEntry) setExpires(expires time.Duration) {
+	if expires > 0 {
+		e.ExpireAt = time.Now().Add(expires).UnixNano()
+	} else {
+		e.ExpireAt = 0
+	}
+}
+
+// MemoryStore 进程内存储，数据在重启后丢失，适合测试和本地开发
+type MemoryStore struct {
+	mu   sync.Mutex
+	data map[string]*memEntry
+	// persist 在每次修改后调用，用于文件存储落盘
+	persist func(data map[string]*memEntry) error
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string]*memEntry)}
+}
+
+// entry 获取未过期的键，需持有锁
+func (m *MemoryStore) entry(key string) *memEntry {
+	e, ok := m.data[key]
+	if !ok {
+		return nil
+	}
+	if e.expired(time.Now()) {
+		delete(m.data, key)
+		return nil
+	}
+	return e
+}
+
+func (m *MemoryStore) hashEntry(key string, create bool) (*memEntry, error) {
+	e := m.entry(key)
+	if e == nil {
+		if !create {
+			return nil, nil
+		}
+		e = &memEntry{Hash: make(map[string]string)}
+		m.data[key] = e
+	}
+	if e.Hash == nil {
+		return nil, errWrongType
+	}
+	return e, nil
+}
+
+func (m *MemoryStore) changed() error {
+	if m.persist == nil {
+		return nil
+	}
+	return m.persist(m.data)
+}
+
+func (m *MemoryStore) Get(key string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	if e == nil {
+		return "", false, nil
+	}
+	if e.Hash != nil {
+		return "", false, errWrongType
+	}
+	return e.Value, true, nil
+}
+
+func (m *MemoryStore) Set(key, val string, expires time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := &memEntry{Value: val}
+	e.setExpires(expires)
+	m.data[key] = e
+	return m.changed()
+}
+
+func (m *MemoryStore) SetNX(key, val string, expires time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.entry(key) != nil {
+		return false, nil
+	}
+	e := &memEntry{Value: val}
+	e.setExpires(expires)
+	m.data[key] = e
+	return true, m.changed()
+}
+
+func (m *MemoryStore) Del(keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		delete(m.data, key)
+	}
+	return m.changed()
+}
+
+func (m *MemoryStore) IncrBy(key string, n int64, expires time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entry(key)
+	if e == nil {
+		e = &memEntry{Value: "0"}
+		m.data[key] = e
+	} else if e.Hash != nil {
+		return 0, errWrongType
+	}
+	v, err := strconv.ParseInt(e.Value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	v += n
+	e.Value = strconv.FormatInt(v, 10)
+	if expires > 0 {
+		e.setExpires(expires)
+	}
+	return v, m.changed()
+}
+
+func (m *MemoryStore) HGet(key, field string) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.hashEntry(key, false)
+	if e == nil || err != nil {
+		return "", false, err
+	}
+	val, ok := e.Hash[field]
+	return val, ok, nil
+}
+
+func (m *MemoryStore) HSet(key, field, val string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.hashEntry(key, true)
+	if err != nil {
+		return err
+	}
+	e.Hash[field] = val
+	return m.changed()
+}
+
+func (m *MemoryStore) HDel(key string, fields ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.hashEntry(key, false)
+	if e == nil || err != nil {
+		return err
+	}
+	for _, field := range fields {
+		delete(e.Hash, field)
+	}
+	if len(e.Hash) == 0 {
+		delete(m.data, key)
+	}
+	return m.changed()
+}
+
+func (m *MemoryStore) HGetAll(key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make(map[string]string)
+	e, err := m.hashEntry(key, false)
+	if e == nil || err != nil {
+		return res, err
+	}
+	for k, v := range e.Hash {
+		res[k] = v
+	}
+	return res, nil
+}
+
+func (m *MemoryStore) HIncrBy(key, field string, n int64, expires time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.hashEntry(key, true)
+	if err != nil {
+		return 0, err
+	}
+	var v int64
+	if s, ok := e.Hash[field]; ok {
+		if v, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return 0, err
+		}
+	}
+	v += n
+	e.Hash[field] = strconv.FormatInt(v, 10)
+	if expires > 0 {
+		e.setExpires(expires)
+	}
+	return v, m.changed()
+}
+
+func (m *MemoryStore) HUpdate(key, field string, f func(val string, ok bool) (string, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.hashEntry(key, true)
+	if err != nil {
+		return err
+	}
+	val, ok := e.Hash[field]
+	newVal, err := f(val, ok)
+	if err != nil {
+		if len(e.Hash) == 0 {
+			delete(m.data, key)
+		}
+		return err
+	}
+	e.Hash[field] = newVal
+	return m.changed()
+}
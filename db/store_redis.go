@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// hUpdateRetries HUpdate 在并发修改时的重试次数
+const hUpdateRetries = 3
+
+// RedisStore 基于 redis 的存储，适合部署在 vercel 等无状态环境
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(url string) (*RedisStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		fmt.Println(err)
+		return nil, errors.New("redis url error")
+	}
+	options.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	return &RedisStore{client: redis.NewClient(options)}, nil
+}
+
+func (r *RedisStore) Get(key string) (string, bool, error) {
+	val, err := r.client.Get(context.Background(), key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return val, true, nil
+}
+
+func (r *RedisStore) Set(key, val string, expires time.Duration) error {
+	return r.client.Set(context.Background(), key, val, expires).Err()
+}
+
+func (r *RedisStore) SetNX(key, val string, expires time.Duration) (bool, error) {
+	return r.client.SetNX(context.Background(), key, val, expires).Result()
+}
+
+func (r *RedisStore) Del(keys ...string) error {
+	return r.client.Del(context.Background(), keys...).Err()
+}
+
+func (r *RedisStore) IncrBy(key string, n int64, expires time.Duration) (int64, error) {
+	ctx := context.Background()
+	pipe := r.client.TxPipeline()
+	incr := pipe.IncrBy(ctx, key, n)
+	if expires > 0 {
+		pipe.Expire(ctx, key, expires)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (r *RedisStore) HGet(key, field string) (string, bool, error) {
+	val, err := r.client.HGet(context.Background(), key, field).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	return val, true, nil
+}
+
+func (r *RedisStore) HSet(key, field, val string) error {
+	return r.client.HSet(context.Background(), key, field, val).Err()
+}
+
+func (r *RedisStore) HDel(key string, fields ...string) error {
+	return r.client.HDel(context.Background(), key, fields...).Err()
+}
+
+func (r *RedisStore) HGetAll(key string) (map[string]string, error) {
+	return r.client.HGetAll(context.Background(), key).Result()
+}
+
+func (r *RedisStore) HIncrBy(key, field string, n int64, expires time.Duration) (int64, error) {
+	ctx := context.Background()
+	pipe := r.client.TxPipeline()
+	incr := pipe.HIncrBy(ctx, key, field, n)
+	if expires > 0 {
+		pipe.Expire(ctx, key, expires)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// HUpdate 通过 WATCH 实现乐观锁，其它请求同时修改时重试
+func (r *RedisStore) HUpdate(key, field string, f func(val string, ok bool) (string, error)) error {
+	ctx := context.Background()
+	txf := func(tx *redis.Tx) error {
+		val, err := tx.HGet(ctx, key, field).Result()
+		ok := err == nil
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		newVal, err := f(val, ok)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, field, newVal)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < hUpdateRetries; i++ {
+		err := r.client.Watch(ctx, txf, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return errors.New("并发修改冲突，请稍后再试")
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	m := NewMemoryStore()
+
+	if _, ok, err := m.Get("k"); ok || err != nil {
+		t.Fatalf("missing key: ok=%v err=%v", ok, err)
+	}
+	m.Set("k", "v", 0)
+	if ok, _ := m.SetNX("k", "other", 0); ok {
+		t.Error("SetNX should not overwrite an existing key")
+	}
+	if v, ok, _ := m.Get("k"); !ok || v != "v" {
+		t.Errorf("got %q %v", v, ok)
+	}
+
+	m.Set("short", "v", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := m.Get("short"); ok {
+		t.Error("expired key should be gone")
+	}
+	if ok, _ := m.SetNX("short", "v", 0); !ok {
+		t.Error("SetNX should succeed after expiry")
+	}
+
+	if n, _ := m.IncrBy("n", 3, time.Minute); n != 3 {
+		t.Errorf("IncrBy got %d", n)
+	}
+	if n, _ := m.IncrBy("n", -1, time.Minute); n != 2 {
+		t.Errorf("IncrBy got %d", n)
+	}
+
+	m.HSet("h", "a", "1")
+	if n, _ := m.HIncrBy("h", "a", 2, 0); n != 3 {
+		t.Errorf("HIncrBy got %d", n)
+	}
+	if _, _, err := m.Get("h"); err == nil {
+		t.Error("Get on a hash should fail")
+	}
+	m.HDel("h", "a")
+	if all, _ := m.HGetAll("h"); len(all) != 0 {
+		t.Errorf("expected empty hash, got %v", all)
+	}
+
+	errStop := errors.New("stop")
+	if err := m.HUpdate("h", "a", func(val string, ok bool) (string, error) { return "", errStop }); err != errStop {
+		t.Errorf("HUpdate should return f's error, got %v", err)
+	}
+	if _, ok, _ := m.HGet("h", "a"); ok {
+		t.Error("HUpdate should not write when f fails")
+	}
+	m.HUpdate("h", "a", func(val string, ok bool) (string, error) { return val + "x", nil })
+	if v, _, _ := m.HGet("h", "a"); v != "x" {
+		t.Errorf("HUpdate got %q", v)
+	}
+
+	m.Del("k", "h")
+	if _, ok, _ := m.Get("k"); ok {
+		t.Error("Del should remove the key")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "store.json")
+	f, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Set("k", "v", 0)
+	f.Set("gone", "v", time.Millisecond)
+	f.HSet("h", "a", "1")
+	time.Sleep(5 * time.Millisecond)
+	f.Set("k2", "v2", time.Hour)
+
+	f, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := f.Get("k"); v != "v" {
+		t.Errorf("got %q", v)
+	}
+	if v, _, _ := f.Get("k2"); v != "v2" {
+		t.Errorf("got %q", v)
+	}
+	if v, _, _ := f.HGet("h", "a"); v != "1" {
+		t.Errorf("got %q", v)
+	}
+	if _, ok := f.data["gone"]; ok {
+		t.Error("expired keys should not be saved")
+	}
+}
+
+func TestGetValueMiss(t *testing.T) {
+	oldStore := StoreInstance
+	StoreInstance = NewMemoryStore()
+	defer func() { StoreInstance = oldStore }()
+
+	// 不存在的键不缓存，之后由其它实例写入存储的值可以读到
+	if v, err := GetValue("miss-key"); v != "" || err != nil {
+		t.Fatalf("got %q %v", v, err)
+	}
+	StoreInstance.Set("miss-key", "v", 0)
+	if v, _ := GetValue("miss-key"); v != "v" {
+		t.Errorf("value written after a miss should be read, got %q", v)
+	}
+}
+
+func TestStoreStatus(t *testing.T) {
+	oldStore := StoreInstance
+	StoreInstance = NewMemoryStore()
+	defer func() { StoreInstance = oldStore }()
+	t.Setenv(Store_Type_Key, "")
+	t.Setenv("KV_URL", "")
+	if status := StoreStatus(); status != Store_Type_Memory+" ("+memoryFallbackWarning+")" {
+		t.Errorf("the memory fallback should be reported, got %q", status)
+	}
+	t.Setenv(Store_Type_Key, Store_Type_Memory)
+	if status := StoreStatus(); status != Store_Type_Memory {
+		t.Errorf("got %q", status)
+	}
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	USAGE_KEY = "usage"
+
+	usageRequests   = "requests"
+	usagePrompt     = "prompt"
+	usageCompletion = "completion"
+)
+
+// UsageRecord 某天某个用户在某个机器人、模型上的用量
+type UsageRecord struct {
+	UserId           string
+	BotType          string
+	Model            string
+	Requests         int64
+	PromptTokens     int64
+	CompletionTokens int64
+}
+
+// 每天的用量保存在一个 hash 中，field 为 用户|机器人|模型|指标，日期按 QuotaLocation 划分
+func usageKey(day time.Time) string {
+	return fmt.Sprintf("%s:%s", USAGE_KEY, day.In(QuotaLocation()).Format("20060102"))
+}
+
+func usageField(r UsageRecord, metric string) string {
+	return strings.Join([]string{r.UserId, r.BotType, r.Model, metric}, "|")
+}
+
+// AddUsage 累加一次调用的用量，expires 为账本的保留时间
+func AddUsage(day time.Time, r UsageRecord, expires time.Duration) error {
+	if StoreInstance == nil {
+		return ErrStoreNil
+	}
+	key := usageKey(day)
+	metrics := []struct {
+		name string
+		n    int64
+	}{
+		{usageRequests, r.Requests},
+		{usagePrompt, r.PromptTokens},
+		{usageCompletion, r.CompletionTokens},
+	}
+	for _, m := range metrics {
+		if _, err := StoreInstance.HIncrBy(key, usageField(r, m.name), m.n, expires); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUsage 获取某天所有的用量记录
+func GetUsage(day time.Time) ([]UsageRecord, error) {
+	if StoreInstance == nil {
+		return nil, ErrStoreNil
+	}
+	fields, err := StoreInstance.HGetAll(usageKey(day))
+	if err != nil {
+		return nil, err
+	}
+	records := make(map[string]*UsageRecord)
+	var keys []string
+	for field, val := range fields {
+		// 模型名中可能包含 |，用户和机器人取开头，指标取结尾
+		parts := strings.Split(field, "|")
+		if len(parts) < 4 {
+			continue
+		}
+		metric := parts[len(parts)-1]
+		r := UsageRecord{UserId: parts[0], BotType: parts[1], Model: strings.Join(parts[2:len(parts)-1], "|")}
+		id := strings.TrimSuffix(field, metric)
+		if records[id] == nil {
+			records[id] = &r
+			keys = append(keys, id)
+		}
+		n, _ := strconv.ParseInt(val, 10, 64)
+		switch metric {
+		case usageRequests:
+			records[id].Requests = n
+		case usagePrompt:
+			records[id].PromptTokens = n
+		case usageCompletion:
+			records[id].CompletionTokens = n
+		}
+	}
+	list := make([]UsageRecord, 0, len(keys))
+	for _, id := range keys {
+		list = append(list, *records[id])
+	}
+	return list, nil
+}